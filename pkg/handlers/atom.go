@@ -0,0 +1,188 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"xml"
+)
+
+const (
+	atomNamespace    = "http://www.w3.org/2005/Atom"
+	atomPubNamespace = "http://www.w3.org/2007/app"
+	atomTimeFormat   = time.RFC3339
+)
+
+// atomCategory is an Atom category element.
+type atomCategory struct {
+	Scheme string
+	Term   string
+}
+
+// atomEntry holds the parts of an Atom entry that map onto a post.
+type atomEntry struct {
+	Title       string
+	Content     string
+	ContentType string
+	Categories  []atomCategory
+	Published   *time.Time
+
+	// Whether the entry is marked as a draft using app:control.
+	Draft bool
+}
+
+// decodeAtomEntry parses an Atom entry document.
+func decodeAtomEntry(r io.Reader) (entry *atomEntry, err os.Error) {
+	p := xml.NewParser(r)
+	entry = &atomEntry{ContentType: "text"}
+
+	// The path of element names from the root to the current element.
+	var stack []string
+	var text bytes.Buffer
+	for {
+		var t xml.Token
+		if t, err = p.Token(); err != nil {
+			if err == os.EOF && len(stack) == 0 {
+				err = nil
+			}
+			break
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 && t.Name.Local != "entry" {
+				return nil, os.NewError("Expected <entry>")
+			}
+
+			name := t.Name.Local
+			if len(stack) == 1 {
+				text.Reset()
+				switch name {
+				case "category":
+					entry.Categories = append(entry.Categories, atomCategory{
+						attr(t, "scheme"), attr(t, "term")})
+				case "content":
+					if contentType := attr(t, "type"); contentType != "" {
+						entry.ContentType = contentType
+					}
+					if entry.ContentType == "xhtml" {
+						// Keep the markup inside the wrapping div.
+						if entry.Content, err = innerXml(p); err != nil {
+							return
+						}
+						continue
+					}
+				}
+			}
+
+			stack = append(stack, name)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, os.NewError("Unexpected </" + t.Name.Local + ">")
+			}
+
+			switch strings.Join(stack, "/") {
+			case "entry/title":
+				entry.Title = strings.TrimSpace(text.String())
+			case "entry/content":
+				entry.Content = text.String()
+			case "entry/published":
+				if entry.Published, err = time.Parse(atomTimeFormat, strings.TrimSpace(text.String())); err != nil {
+					return
+				}
+			case "entry/control/draft":
+				entry.Draft = strings.TrimSpace(text.String()) == "yes"
+			}
+
+			stack = stack[:len(stack)-1]
+			text.Reset()
+		}
+	}
+
+	return
+}
+
+// innerXml returns the markup inside the first child element of the current
+// element, consuming everything up to and including the current element's end
+// tag.
+func innerXml(p *xml.Parser) (string, os.Error) {
+	var buf bytes.Buffer
+	depth := 0
+	for {
+		t, err := p.Token()
+		if err != nil {
+			return "", err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			if depth > 0 {
+				fmt.Fprintf(&buf, "<%s", t.Name.Local)
+				for _, a := range t.Attr {
+					fmt.Fprintf(&buf, " %s=\"", a.Name.Local)
+					xml.Escape(&buf, []byte(a.Value))
+					buf.WriteString("\"")
+				}
+				buf.WriteString(">")
+			}
+			depth += 1
+		case xml.EndElement:
+			depth -= 1
+			if depth < 0 {
+				return buf.String(), nil
+			}
+			if depth > 0 {
+				fmt.Fprintf(&buf, "</%s>", t.Name.Local)
+			}
+		case xml.CharData:
+			if depth > 0 {
+				xml.Escape(&buf, t)
+			}
+		}
+	}
+
+	panic("unreachable")
+}
+
+func attr(t xml.StartElement, name string) string {
+	for _, a := range t.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+
+	return ""
+}
+
+// writeXmlText writes s, escaped for use in XML.
+func writeXmlText(w io.Writer, s string) {
+	xml.Escape(w, []byte(s))
+}
+
+// writeXmlElement writes <name>s</name>, with s escaped.
+func writeXmlElement(w io.Writer, name, s string) {
+	fmt.Fprintf(w, "<%s>", name)
+	writeXmlText(w, s)
+	fmt.Fprintf(w, "</%s>", name)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"testing"
+)
+
+func TestAtomFeed(t *testing.T) {
+	context, cleanup := newTestContext(t)
+	defer cleanup()
+
+	req, resp := newTestRequest(t, "http://example.com/index.atom", nil)
+	AtomFeedHandler(context).ServeWeb(req)
+	if resp.status != 200 || resp.header.Get("Content-Type") != atomFeedContentType {
+		t.Fatalf("AtomFeedHandler() responded %d with %v", resp.status, resp.header)
+	}
+	if s := resp.header.Get("Last-Modified"); s != "Thu, 02 Jun 2011 10:00:00 GMT" {
+		t.Errorf("Last-Modified = '%s'", s)
+	}
+
+	// The newest post comes first, and the draft and the page are left out.
+	lines := xmlOutline(t, resp.body.Bytes())
+	checkLines(t, "the Atom feed", lines, []string{
+		"feed@xmlns http://www.w3.org/2005/Atom",
+		"feed/title Tests & Such",
+		"feed/link@rel alternate",
+		"feed/link@href http://example.com/",
+		"feed/link@rel self",
+		"feed/link@href http://example.com/index.atom",
+		"feed/id http://example.com/index.atom",
+		"feed/updated 2011-06-02T10:00:00Z",
+		"feed/author/name A. Blogger",
+		"feed/entry/id urn:uuid:second",
+		"feed/entry/title Fish & <Chips>",
+		"feed/entry/link@href http://example.com/2011/06/second",
+		"feed/entry/published 2011-06-01T10:00:00Z",
+		"feed/entry/updated 2011-06-02T10:00:00Z",
+		"feed/entry/category@term go",
+		"feed/entry/category@term food",
+		"feed/entry/content <p>Salt &amp; vinegar.</p>",
+		"feed/entry/content@type html",
+		"feed/entry/id urn:uuid:first",
+		"feed/entry/title First",
+		"feed/entry/link@href http://example.com/2011/05/first",
+		"feed/entry/published 2011-05-01T10:00:00Z",
+		"feed/entry/updated 2011-05-03T10:00:00Z",
+		"feed/entry/category@term go",
+		`feed/entry/content <p>Hello, see <a href="http://example.com/2011/06/second">the next post</a>.</p>`,
+	})
+	for _, uuid := range []string{"draft", "about"} {
+		if hasLinePrefix(lines, "feed/entry/id urn:uuid:"+uuid) {
+			t.Errorf("the Atom feed includes '%s'", uuid)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"http"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+var atomEntryTests = []struct {
+	in          string
+	title       string
+	content     string
+	contentType string
+	categories  int
+	draft       bool
+}{
+	{"<entry xmlns=\"http://www.w3.org/2005/Atom\"><title>Hello</title>" +
+		"<content>Some *textile*</content></entry>",
+		"Hello", "Some *textile*", "text", 0, false},
+	{"<?xml version=\"1.0\"?>\n<entry xmlns=\"http://www.w3.org/2005/Atom\" xmlns:app=\"http://www.w3.org/2007/app\">\n" +
+		"  <title type=\"text\"> Hello &amp; goodbye </title>\n" +
+		"  <category term=\"tech\"/><category scheme=\"http://example.com/tag/\" term=\"go\"/>\n" +
+		"  <app:control><app:draft>yes</app:draft></app:control>\n" +
+		"  <content type=\"html\">&lt;p&gt;Hi&lt;/p&gt;</content>\n" +
+		"</entry>",
+		"Hello & goodbye", "<p>Hi</p>", "html", 2, true},
+	{"<entry xmlns=\"http://www.w3.org/2005/Atom\"><title>X</title>" +
+		"<content type=\"xhtml\"><div xmlns=\"http://www.w3.org/1999/xhtml\"><p class=\"a\">Hi &amp; bye</p></div></content></entry>",
+		"X", "<p class=\"a\">Hi &amp; bye</p>", "xhtml", 0, false},
+}
+
+func TestDecodeAtomEntry(t *testing.T) {
+	for _, at := range atomEntryTests {
+		entry, err := decodeAtomEntry(strings.NewReader(at.in))
+		if err != nil {
+			t.Errorf("decodeAtomEntry(%q) failed: %s", at.in, err)
+			continue
+		}
+		if entry.Title != at.title {
+			t.Errorf("decodeAtomEntry(%q) title = '%s' want '%s'", at.in, entry.Title, at.title)
+		}
+		if entry.Content != at.content {
+			t.Errorf("decodeAtomEntry(%q) content = '%s' want '%s'", at.in, entry.Content, at.content)
+		}
+		if entry.ContentType != at.contentType {
+			t.Errorf("decodeAtomEntry(%q) content type = '%s' want '%s'", at.in, entry.ContentType, at.contentType)
+		}
+		if len(entry.Categories) != at.categories {
+			t.Errorf("decodeAtomEntry(%q) got %d categories want %d", at.in, len(entry.Categories), at.categories)
+		}
+		if entry.Draft != at.draft {
+			t.Errorf("decodeAtomEntry(%q) draft = %v want %v", at.in, entry.Draft, at.draft)
+		}
+	}
+}
+
+func TestAtomPubStatusRoundTrip(t *testing.T) {
+	blogUrl := &http.URL{Scheme: "http", Host: "example.com"}
+	ah := &atomPubHandler{context: &RenderContext{Config: &lwb.BlogConfig{BlogUrl: blogUrl, AtomPubUrl: "/atompub"}}}
+
+	now := time.Seconds()
+	for _, st := range []struct {
+		status    string
+		published int64
+	}{
+		{"publish", now - 3600},
+		{"scheduled", now + 3600},
+		{"draft", now - 3600},
+	} {
+		published := time.SecondsToUTC(st.published)
+		post := &store.Post{Title: "Hello", Body: "Hi", Status: st.status, Published: published,
+			LastModified: published, CanonicalBlogUrl: blogUrl}
+
+		var buf bytes.Buffer
+		ah.writeEntry(&buf, post, true)
+		entry, err := decodeAtomEntry(&buf)
+		if err != nil {
+			t.Fatalf("decodeAtomEntry() failed: %s", err)
+		}
+
+		edited := post.Copy()
+		ah.applyEntry(edited, entry)
+		if edited.Status != st.status {
+			t.Errorf("status '%s' came back as '%s'", st.status, edited.Status)
+		}
+	}
+}
+
+func TestSaveMedia(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atom_test")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &lwb.BlogConfig{BlogUrl: &http.URL{Scheme: "http", Host: "example.com"},
+		MediaPath: dir, MediaUrl: "/media"}
+
+	// Uploads with the same name are all kept.
+	for _, upload := range []struct{ data, want string }{
+		{"a", "photo.jpg"},
+		{"b", "photo-2.jpg"},
+		{"c", "photo-3.jpg"},
+	} {
+		saved, url, err := saveMedia(config, "photo.jpg", []byte(upload.data))
+		if err != nil {
+			t.Fatalf("saveMedia() failed: %s", err)
+		}
+		if saved != upload.want || url != "http://example.com/media/"+upload.want {
+			t.Errorf("saveMedia() saved '%s' at '%s' want '%s'", saved, url, upload.want)
+		}
+		if b, err := ioutil.ReadFile(path.Join(dir, upload.want)); err != nil || string(b) != upload.data {
+			t.Errorf("%s holds '%s': %v", upload.want, b, err)
+		}
+	}
+
+	if saved, _, err := saveMedia(config, "2011/notes", []byte("a")); err != nil || saved != "2011/notes" {
+		t.Errorf("saveMedia() saved '%s': %v", saved, err)
+	}
+	if saved, _, err := saveMedia(config, "2011/notes", []byte("b")); err != nil || saved != "2011/notes-2" {
+		t.Errorf("saveMedia() saved '%s': %v", saved, err)
+	}
+	if _, _, err := saveMedia(config, "../photo.jpg", []byte("c")); err == nil {
+		t.Errorf("saveMedia() accepted a name outside the media directory")
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"io"
+	"time"
+)
+
+type atomFeedHandler struct {
+	context *RenderContext
+}
+
+func (afh *atomFeedHandler) ServeWeb(req *web.Request) {
+	afh.context.Config.Cache.Run(req, func(w *lwb.Page) bool {
+		context := afh.context.snapshot()
+
+		posts := context.Db.GetRecentPosts(context.Config.NumRssFeedPosts)
+		w.ContentType = atomFeedContentType
+		w.LastModified = lastModified(posts)
+		renderAtomFeed(w, context, context.Config.AtomUrl, posts)
+
+		return true
+	})
+}
+
+// renderAtomFeed renders an Atom 1.0 feed of posts. selfUrl is the path the
+// feed is published at; the feed links to the page in the same directory.
+func renderAtomFeed(w io.Writer, context *RenderContext, selfUrl string, posts []*store.Post) {
+	// Render posts, keeping track of the most recent modification.
+	var content bytes.Buffer
+	updated := time.SecondsToUTC(0)
+	for _, post := range posts {
+		if post.LastModified.Seconds() > updated.Seconds() {
+			updated = post.LastModified
+		}
+
+		data := makeTemplateParams(context, post)
+		templates["atom_entry"].Template.Execute(&content, data)
+	}
+
+	// Render page.
+	data := makeTemplateParams(context, content.Bytes())
+	data["selfUrl"] = selfUrl
+	data["htmlUrl"] = feedHtmlUrl(selfUrl)
+	data["updated"] = updated.Format(time.RFC3339)
+
+	templates["atom"].Template.Execute(w, data)
+}
+
+// AtomFeedHandler returns a request handler that serves an Atom 1.0 feed of
+// the most recent posts.
+func AtomFeedHandler(context *RenderContext) web.Handler {
+	return &atomFeedHandler{context}
+}
@@ -0,0 +1,416 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"fmt"
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// An implementation of the Atom Publishing Protocol (RFC 5023). The service
+// document lives at AtomPubUrl, with a "posts" collection and a "media"
+// collection below it. Members of the posts collection are named by uuid.
+
+const (
+	atomPubPosts = "posts"
+	atomPubMedia = "media"
+
+	// The most entries listed in the posts collection feed, which lists every
+	// post and page, newest first.
+	maxAtomPubEntries = 1000
+
+	atomEntryContentType   = "application/atom+xml;type=entry"
+	atomFeedContentType    = "application/atom+xml;type=feed"
+	atomServiceContentType = "application/atomsvc+xml"
+)
+
+type atomPubHandler struct {
+	context *RenderContext
+	db      store.WritableStore
+}
+
+func (ah *atomPubHandler) ServeWeb(req *web.Request) {
+	if !authorized(req, ah.context.Config) {
+		return
+	}
+
+	collection := req.Param.Get("collection")
+	member := req.Param.Get("member")
+
+	switch {
+	case collection == "":
+		ah.serveService(req)
+	case collection == atomPubPosts && member == "":
+		switch req.Method {
+		case "GET":
+			ah.servePostsFeed(req)
+		case "POST":
+			ah.createPost(req)
+		default:
+			req.Error(web.StatusMethodNotAllowed, os.NewError("Method not allowed."))
+		}
+	case collection == atomPubPosts:
+		post, found := ah.db.GetPostByUuid(member)
+		if !found {
+			req.Error(web.StatusNotFound, os.NewError("Not Found."))
+			return
+		}
+
+		switch req.Method {
+		case "GET":
+			page := &lwb.Page{ContentType: atomEntryContentType, LastModified: post.LastModified}
+			ah.writeEntry(page, post, true)
+			page.Serve(req)
+		case "PUT":
+			ah.updatePost(req, post)
+		case "DELETE":
+			if err := ah.db.DeletePost(post.Uuid); err != nil {
+				req.Error(web.StatusInternalServerError, err)
+				return
+			}
+			postChanged(ah.context, ah.db, post.Uuid, post)
+			req.Respond(web.StatusOK)
+		default:
+			req.Error(web.StatusMethodNotAllowed, os.NewError("Method not allowed."))
+		}
+	case collection == atomPubMedia && member == "":
+		switch req.Method {
+		case "GET":
+			ah.serveMediaFeed(req)
+		case "POST":
+			ah.createMedia(req)
+		default:
+			req.Error(web.StatusMethodNotAllowed, os.NewError("Method not allowed."))
+		}
+	case collection == atomPubMedia:
+		ah.serveMediaMember(req, member)
+	default:
+		req.Error(web.StatusNotFound, os.NewError("Not Found."))
+	}
+}
+
+func (ah *atomPubHandler) collectionUrl(collection string) string {
+	return ah.context.Config.BlogUrl.String() + ah.context.Config.AtomPubUrl + "/" + collection
+}
+
+func (ah *atomPubHandler) serveService(req *web.Request) {
+	config := ah.context.Config
+	w := &lwb.Page{ContentType: atomServiceContentType}
+
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n"+
+		"<service xmlns=\"%s\" xmlns:atom=\"%s\">\n<workspace>\n", atomPubNamespace, atomNamespace)
+	writeXmlElement(w, "atom:title", config.Title)
+	fmt.Fprintf(w, "\n<collection href=\"%s\">\n", ah.collectionUrl(atomPubPosts))
+	io.WriteString(w, "<atom:title>Posts</atom:title>\n<accept>application/atom+xml;type=entry</accept>\n")
+	fmt.Fprintf(w, "<categories fixed=\"no\" scheme=\"%s/category/\">", config.BlogUrl)
+	for _, category := range ah.db.GetCategories() {
+		io.WriteString(w, "<atom:category term=\"")
+		writeXmlText(w, category)
+		io.WriteString(w, "\"/>")
+	}
+	io.WriteString(w, "</categories>\n</collection>\n")
+	fmt.Fprintf(w, "<collection href=\"%s\">\n", ah.collectionUrl(atomPubMedia))
+	io.WriteString(w, "<atom:title>Media</atom:title>\n<accept>*/*</accept>\n</collection>\n")
+	io.WriteString(w, "</workspace>\n</service>\n")
+	w.Serve(req)
+}
+
+func (ah *atomPubHandler) servePostsFeed(req *web.Request) {
+	// Clients need to see drafts and scheduled posts to edit them.
+	posts := newestFirst(ah.db.GetAllPosts())
+	if len(posts) > maxAtomPubEntries {
+		posts = posts[:maxAtomPubEntries]
+	}
+
+	updated := lastModified(posts)
+	w := &lwb.Page{ContentType: atomFeedContentType, LastModified: updated}
+	if updated == nil {
+		updated = time.SecondsToUTC(0)
+	}
+
+	ah.writeFeedStart(w, atomPubPosts, updated)
+	for _, post := range posts {
+		ah.writeEntry(w, post, false)
+	}
+	io.WriteString(w, "</feed>\n")
+	w.Serve(req)
+}
+
+// postsByDate sorts posts and pages newest first.
+type postsByDate []*store.Post
+
+// sort.Interface
+func (p postsByDate) Len() int {
+	return len(p)
+}
+
+func (p postsByDate) Less(i, j int) bool {
+	// Reverse sort.
+	return p[i].Published.Seconds() > p[j].Published.Seconds()
+}
+
+func (p postsByDate) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
+
+// newestFirst returns a copy of posts sorted newest first.
+func newestFirst(posts []*store.Post) []*store.Post {
+	sorted := make([]*store.Post, len(posts))
+	copy(sorted, posts)
+	sort.Sort(postsByDate(sorted))
+
+	return sorted
+}
+
+func (ah *atomPubHandler) writeFeedStart(w io.Writer, collection string, updated *time.Time) {
+	config := ah.context.Config
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n"+
+		"<feed xmlns=\"%s\" xmlns:app=\"%s\">\n", atomNamespace, atomPubNamespace)
+	writeXmlElement(w, "id", ah.collectionUrl(collection))
+	writeXmlElement(w, "title", config.Title)
+	writeXmlElement(w, "updated", updated.Format(atomTimeFormat))
+	io.WriteString(w, "<author>")
+	writeXmlElement(w, "name", config.Author)
+	io.WriteString(w, "</author>\n")
+	fmt.Fprintf(w, "<link rel=\"self\" href=\"%s\"/>\n", ah.collectionUrl(collection))
+}
+
+// writeEntry writes the Atom entry for a post, as a standalone document if
+// document is true.
+func (ah *atomPubHandler) writeEntry(w io.Writer, post *store.Post, document bool) {
+	config := ah.context.Config
+	if document {
+		fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n"+
+			"<entry xmlns=\"%s\" xmlns:app=\"%s\">\n", atomNamespace, atomPubNamespace)
+	} else {
+		io.WriteString(w, "<entry>\n")
+	}
+
+	writeXmlElement(w, "id", "urn:uuid:"+post.Uuid)
+	writeXmlElement(w, "title", post.Title)
+	writeXmlElement(w, "updated", post.LastModified.Format(atomTimeFormat))
+	writeXmlElement(w, "published", post.Published.Format(atomTimeFormat))
+	io.WriteString(w, "\n<author>")
+	writeXmlElement(w, "name", config.Author)
+	io.WriteString(w, "</author>\n")
+	fmt.Fprintf(w, "<link rel=\"alternate\" type=\"text/html\" href=\"%s%s\"/>\n",
+		post.CanonicalBlogUrl, post.CanonicalPath)
+	fmt.Fprintf(w, "<link rel=\"edit\" href=\"%s/%s\"/>\n", ah.collectionUrl(atomPubPosts), post.Uuid)
+	for _, category := range post.Categories {
+		fmt.Fprintf(w, "<category scheme=\"%s/category/\" term=\"", config.BlogUrl)
+		writeXmlText(w, category)
+		io.WriteString(w, "\"/>\n")
+	}
+	for _, tag := range post.Tags {
+		fmt.Fprintf(w, "<category scheme=\"%s/tag/\" term=\"", config.BlogUrl)
+		writeXmlText(w, tag)
+		io.WriteString(w, "\"/>\n")
+	}
+	// Scheduled posts aren't drafts; they're told apart from published posts
+	// by their published date.
+	if !post.IsPublished() && !post.IsScheduled() {
+		io.WriteString(w, "<app:control><app:draft>yes</app:draft></app:control>\n")
+	}
+
+	// The content is the unprocessed body, so that it can be edited.
+	contentType := "text"
+	if post.Format == "none" {
+		contentType = "html"
+	}
+	fmt.Fprintf(w, "<content type=\"%s\">", contentType)
+	writeXmlText(w, post.Body)
+	io.WriteString(w, "</content>\n</entry>\n")
+}
+
+func (ah *atomPubHandler) createPost(req *web.Request) {
+	entry, err := decodeAtomEntry(io.LimitReader(req.Body, maxUploadLen))
+	if err != nil {
+		req.Error(web.StatusBadRequest, err)
+		return
+	}
+
+	post := new(store.Post)
+	if slug := req.Header.Get("Slug"); slug != "" {
+		post.Basename = slug
+	}
+	ah.applyEntry(post, entry)
+
+	if err = ah.db.SavePost(post); err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	postChanged(ah.context, ah.db, post.Uuid, nil)
+
+	location := ah.collectionUrl(atomPubPosts) + "/" + post.Uuid
+	ah.writeEntry(req.Respond(web.StatusCreated,
+		web.HeaderContentType, atomEntryContentType,
+		web.HeaderLocation, location,
+		"Content-Location", location), post, true)
+}
+
+func (ah *atomPubHandler) updatePost(req *web.Request, old *store.Post) {
+	entry, err := decodeAtomEntry(io.LimitReader(req.Body, maxUploadLen))
+	if err != nil {
+		req.Error(web.StatusBadRequest, err)
+		return
+	}
+
+	post := old.Copy()
+	ah.applyEntry(post, entry)
+
+	if err = ah.db.UpdatePost(post); err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	postChanged(ah.context, ah.db, post.Uuid, old)
+
+	ah.writeEntry(req.Respond(web.StatusOK, web.HeaderContentType, atomEntryContentType), post, true)
+}
+
+// applyEntry copies the fields of an Atom entry into a post. Categories using
+// the blog's tag scheme become tags, everything else becomes a category.
+func (ah *atomPubHandler) applyEntry(post *store.Post, entry *atomEntry) {
+	tagScheme := ah.context.Config.BlogUrl.String() + "/tag/"
+
+	post.Title = entry.Title
+	post.Body = entry.Content
+	if entry.ContentType != "text" {
+		post.Format = "none"
+	}
+	if entry.Published != nil {
+		post.Published = entry.Published
+	}
+
+	post.Tags = nil
+	post.Categories = nil
+	for _, category := range entry.Categories {
+		if category.Scheme == tagScheme {
+			post.Tags = append(post.Tags, category.Term)
+		} else {
+			post.Categories = append(post.Categories, category.Term)
+		}
+	}
+
+	post.Status = publishStatus(entry.Draft, post.Published)
+}
+
+func (ah *atomPubHandler) serveMediaFeed(req *web.Request) {
+	config := ah.context.Config
+	fileInfos, _ := ioutil.ReadDir(config.MediaPath)
+
+	w := &lwb.Page{ContentType: atomFeedContentType}
+	ah.writeFeedStart(w, atomPubMedia, time.UTC())
+	for _, fileInfo := range fileInfos {
+		if fileInfo.IsRegular() {
+			ah.writeMediaEntry(w, fileInfo, false)
+		}
+	}
+	io.WriteString(w, "</feed>\n")
+	w.Serve(req)
+}
+
+func (ah *atomPubHandler) createMedia(req *web.Request) {
+	name := path.Base(req.Header.Get("Slug"))
+	if name == "." || name == "/" {
+		name = fmt.Sprintf("upload-%d", time.Seconds())
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(req.Body, maxUploadLen))
+	if err != nil {
+		req.Error(web.StatusBadRequest, err)
+		return
+	}
+
+	if name, _, err = saveMedia(ah.context.Config, name, data); err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+
+	fileInfo, err := os.Stat(path.Join(ah.context.Config.MediaPath, name))
+	if err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+
+	location := ah.collectionUrl(atomPubMedia) + "/" + name
+	ah.writeMediaEntry(req.Respond(web.StatusCreated,
+		web.HeaderContentType, atomEntryContentType,
+		web.HeaderLocation, location,
+		"Content-Location", location), fileInfo, true)
+}
+
+func (ah *atomPubHandler) serveMediaMember(req *web.Request, name string) {
+	name = path.Base(name)
+	filename := path.Join(ah.context.Config.MediaPath, name)
+	fileInfo, err := os.Stat(filename)
+	if err != nil || !fileInfo.IsRegular() {
+		req.Error(web.StatusNotFound, os.NewError("Not Found."))
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		page := &lwb.Page{ContentType: atomEntryContentType, LastModified: time.SecondsToUTC(fileInfo.Mtime_ns / 1e9)}
+		ah.writeMediaEntry(page, fileInfo, true)
+		page.Serve(req)
+	case "DELETE":
+		if err = os.Remove(filename); err != nil {
+			req.Error(web.StatusInternalServerError, err)
+			return
+		}
+		req.Respond(web.StatusOK)
+	default:
+		req.Error(web.StatusMethodNotAllowed, os.NewError("Method not allowed."))
+	}
+}
+
+// writeMediaEntry writes the media link entry for an uploaded file.
+func (ah *atomPubHandler) writeMediaEntry(w io.Writer, fileInfo *os.FileInfo, document bool) {
+	config := ah.context.Config
+	mediaUrl := config.BlogUrl.String() + config.MediaUrl + "/" + fileInfo.Name
+	if document {
+		fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<entry xmlns=\"%s\">\n", atomNamespace)
+	} else {
+		io.WriteString(w, "<entry>\n")
+	}
+
+	writeXmlElement(w, "id", mediaUrl)
+	writeXmlElement(w, "title", fileInfo.Name)
+	writeXmlElement(w, "updated", time.SecondsToUTC(fileInfo.Mtime_ns/1e9).Format(atomTimeFormat))
+	io.WriteString(w, "\n<author>")
+	writeXmlElement(w, "name", config.Author)
+	io.WriteString(w, "</author>\n<summary/>\n")
+	fmt.Fprintf(w, "<content src=\"%s\"/>\n", mediaUrl)
+	fmt.Fprintf(w, "<link rel=\"edit-media\" href=\"%s\"/>\n", mediaUrl)
+	fmt.Fprintf(w, "<link rel=\"edit\" href=\"%s/%s\"/>\n", ah.collectionUrl(atomPubMedia), fileInfo.Name)
+	io.WriteString(w, "</entry>\n")
+}
+
+// AtomPubHandler returns a request handler that implements the Atom
+// Publishing Protocol. It expects the "collection" and "member" parameters
+// from the route.
+func AtomPubHandler(context *RenderContext, db store.WritableStore) web.Handler {
+	return &atomPubHandler{context, db}
+}
@@ -19,8 +19,8 @@ package handlers
 import (
 	"bytes"
 	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
 	"github.com/stevela/lwb/store"
-	"io"
 	"strconv"
 )
 
@@ -29,7 +29,8 @@ type dateArchiveHandler struct {
 }
 
 func (dah *dateArchiveHandler) ServeWeb(req *web.Request) {
-	dah.context.Config.Cache.Run(req, func(w io.Writer) bool {
+	dah.context.Config.Cache.Run(req, func(w *lwb.Page) bool {
+		context := dah.context.snapshot()
 		yearStr := req.Param.Get("year")
 		monthStr := req.Param.Get("month")
 
@@ -39,9 +40,9 @@ func (dah *dateArchiveHandler) ServeWeb(req *web.Request) {
 			year, _ := strconv.Atoi(yearStr)
 			if monthStr != "" {
 				month, _ := strconv.Atoi(monthStr)
-				posts, found = dah.context.Db.GetPostsByYearMonth(year, month)
+				posts, found = context.Db.GetPostsByYearMonth(year, month)
 			} else {
-				posts, found = dah.context.Db.GetPostsByYear(year)
+				posts, found = context.Db.GetPostsByYear(year)
 			}
 		}
 
@@ -49,14 +50,25 @@ func (dah *dateArchiveHandler) ServeWeb(req *web.Request) {
 			return false
 		}
 
+		posts, pagination, ok := paginate(req, posts, context.Config.NumArchivePosts, queryPageUrl(req.URL.Path))
+		if !ok {
+			return false
+		}
+
 		// Render posts.
+		w.LastModified = lastModified(posts)
 		var content bytes.Buffer
 		for _, post := range posts {
-			renderPost(&content, dah.context, post, false)
+			renderPost(&content, context, post, false)
 		}
 
 		// Render page.
-		templates["main"].Template.Execute(w, makeTemplateParams(dah.context, content.Bytes()))
+		data := makeTemplateParams(context, content.Bytes())
+		if pagination != nil {
+			data["pagination"] = pagination
+		}
+
+		templates["main"].Template.Execute(w, data)
 
 		return true
 	})
@@ -0,0 +1,97 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"json"
+	"time"
+)
+
+const (
+	jsonFeedVersion     = "https://jsonfeed.org/version/1.1"
+	jsonFeedContentType = "application/feed+json"
+)
+
+type jsonFeedHandler struct {
+	context *RenderContext
+}
+
+func (jfh *jsonFeedHandler) ServeWeb(req *web.Request) {
+	jfh.context.Config.Cache.Run(req, func(w *lwb.Page) bool {
+		context := jfh.context.snapshot()
+
+		posts := context.Db.GetRecentPosts(context.Config.NumRssFeedPosts)
+		b, err := json.Marshal(makeJsonFeed(context, context.Config.JsonFeedUrl, posts))
+		if err != nil {
+			return false
+		}
+
+		w.ContentType = jsonFeedContentType
+		w.LastModified = lastModified(posts)
+		w.Write(b)
+
+		return true
+	})
+}
+
+// makeJsonFeed builds a JSON Feed document for the given posts, ready to be
+// marshalled.
+func makeJsonFeed(context *RenderContext, feedUrl string, posts []*store.Post) map[string]interface{} {
+	blogUrl := context.Config.BlogUrl.String()
+	author := map[string]interface{}{"name": context.Config.Author}
+
+	items := make([]interface{}, len(posts))
+	for i, post := range posts {
+		var content bytes.Buffer
+		formatBody(&content, context, post)
+
+		item := map[string]interface{}{
+			"id":             "urn:uuid:" + post.Uuid,
+			"url":            post.CanonicalBlogUrl.String() + post.CanonicalPath,
+			"title":          post.Title,
+			"content_html":   content.String(),
+			"date_published": post.Published.Format(time.RFC3339),
+			"date_modified":  post.LastModified.Format(time.RFC3339),
+		}
+		if len(post.Tags) > 0 {
+			item["tags"] = post.Tags
+		}
+
+		items[i] = item
+	}
+
+	return map[string]interface{}{
+		"version":       jsonFeedVersion,
+		"title":         context.Config.Title,
+		"description":   context.Config.Description,
+		"home_page_url": blogUrl + "/",
+		"feed_url":      blogUrl + feedUrl,
+		"authors":       []interface{}{author},
+		"language":      "en-US",
+		"items":         items,
+	}
+}
+
+// JsonFeedHandler returns a request handler that serves a JSON Feed
+// (http://jsonfeed.org) of the most recent posts.
+func JsonFeedHandler(context *RenderContext) web.Handler {
+	return &jsonFeedHandler{context}
+}
@@ -18,8 +18,9 @@ package handlers
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/garyburd/twister/web"
-	"io"
+	"github.com/stevela/lwb/lwb"
 )
 
 type mainIndexHandler struct {
@@ -27,20 +28,44 @@ type mainIndexHandler struct {
 }
 
 func (mih *mainIndexHandler) ServeWeb(req *web.Request) {
-	mih.context.Config.Cache.Run(req, func(w io.Writer) bool {
+	mih.context.Config.Cache.Run(req, func(w *lwb.Page) bool {
+		context := mih.context.snapshot()
+
+		pageUrl := func(page int) string { return mih.pageUrl(page) }
+		start, end, pagination, ok := pageBounds(req, context.Db.NumPosts(),
+			context.Config.NumMainIndexPosts, pageUrl)
+		if !ok {
+			return false
+		}
+		posts := context.Db.GetPostsPage(start, end-start)
+
 		// Render posts.
+		w.LastModified = lastModified(posts)
 		var content bytes.Buffer
-		for _, post := range mih.context.Db.GetRecentPosts(mih.context.Config.NumMainIndexPosts) {
-			renderPost(&content, mih.context, post, false)
+		for _, post := range posts {
+			renderPost(&content, context, post, false)
 		}
 
 		// Render page.
-		templates["main"].Template.Execute(w, makeTemplateParams(mih.context, content.Bytes()))
+		data := makeTemplateParams(context, content.Bytes())
+		if pagination != nil {
+			data["pagination"] = pagination
+		}
+
+		templates["main"].Template.Execute(w, data)
 
 		return true
 	})
 }
 
+// pageUrl returns the url of a page of the main index.
+func (mih *mainIndexHandler) pageUrl(page int) string {
+	if page == 1 {
+		return "/"
+	}
+
+	return fmt.Sprintf(mih.context.Config.MainIndexPageUrl, page)
+}
 
 // MainIndexHandler returns a request handler that serves the main index.
 func MainIndexHandler(context *RenderContext) web.Handler {
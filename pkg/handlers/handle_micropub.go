@@ -0,0 +1,534 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"http"
+	"io"
+	"io/ioutil"
+	"json"
+	"os"
+	"strings"
+	"time"
+)
+
+// An implementation of Micropub (http://www.w3.org/TR/micropub/) for h-entry
+// posts. Properties map onto posts as follows:
+//
+//	name        -> Title
+//	content     -> Body (an {"html": ...} value sets the format to "none")
+//	category    -> Categories
+//	published   -> Published
+//	post-status -> Status ("published", "scheduled" or "draft")
+//	mp-slug     -> Basename
+
+// TokenVerifier checks Micropub access tokens.
+type TokenVerifier interface {
+	// VerifyToken returns the scopes granted to a token, or an error if the
+	// token isn't valid.
+	VerifyToken(token string) (scopes []string, err os.Error)
+}
+
+// StaticTokenVerifier is a TokenVerifier backed by a fixed map of token ->
+// scopes, which is useful for tests and single user setups.
+type StaticTokenVerifier map[string][]string
+
+func (v StaticTokenVerifier) VerifyToken(token string) ([]string, os.Error) {
+	scopes, found := v[token]
+	if !found {
+		return nil, os.NewError("Invalid token.")
+	}
+
+	return scopes, nil
+}
+
+// TokenEndpointVerifier is a TokenVerifier that asks an IndieAuth token
+// endpoint about each token, accepting it only if it was issued for Me.
+type TokenEndpointVerifier struct {
+	Endpoint string
+	Me       string
+}
+
+func (v *TokenEndpointVerifier) VerifyToken(token string) (scopes []string, err os.Error) {
+	req, err := http.NewRequest("GET", v.Endpoint, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, os.NewError("Token endpoint rejected token: " + resp.Status)
+	}
+
+	var info struct {
+		Me    string
+		Scope string
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return
+	}
+	if strings.TrimRight(info.Me, "/") != strings.TrimRight(v.Me, "/") {
+		return nil, os.NewError("Token was issued for " + info.Me)
+	}
+
+	return strings.Fields(info.Scope), nil
+}
+
+type micropubHandler struct {
+	context  *RenderContext
+	db       store.WritableStore
+	verifier TokenVerifier
+}
+
+// micropubError responds with a Micropub error.
+func micropubError(req *web.Request, status int, code, description string) {
+	b, _ := json.Marshal(map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+	req.Respond(status, web.HeaderContentType, "application/json").Write(b)
+}
+
+func micropubJson(req *web.Request, v interface{}) {
+	b, _ := json.Marshal(v)
+	page := &lwb.Page{ContentType: "application/json"}
+	page.Write(b)
+	page.Serve(req)
+}
+
+func (mh *micropubHandler) ServeWeb(req *web.Request) {
+	var values map[string][]string
+	var body map[string]interface{}
+
+	// Parse the request.
+	if req.Method == "POST" {
+		data, err := ioutil.ReadAll(io.LimitReader(req.Body, maxUploadLen))
+		if err != nil {
+			micropubError(req, web.StatusBadRequest, "invalid_request", err.String())
+			return
+		}
+
+		contentType := req.Header.Get(web.HeaderContentType)
+		switch {
+		case strings.HasPrefix(contentType, "application/json"):
+			err = json.Unmarshal(data, &body)
+		case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+			values, err = http.ParseQuery(string(data))
+		default:
+			micropubError(req, web.StatusUnsupportedMediaType, "invalid_request",
+				"Unsupported content type: "+contentType)
+			return
+		}
+
+		if err != nil {
+			micropubError(req, web.StatusBadRequest, "invalid_request", err.String())
+			return
+		}
+	}
+
+	// Check the token.
+	token := ""
+	if auth := req.Header.Get(web.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimSpace(auth[7:])
+	} else if tokens := values["access_token"]; len(tokens) > 0 {
+		token = tokens[0]
+	}
+
+	if token == "" {
+		micropubError(req, web.StatusUnauthorized, "unauthorized", "No access token.")
+		return
+	}
+
+	scopes, err := mh.verifier.VerifyToken(token)
+	if err != nil {
+		micropubError(req, web.StatusForbidden, "forbidden", err.String())
+		return
+	}
+
+	switch {
+	case req.Method == "GET":
+		mh.query(req)
+	case body != nil:
+		mh.serveJson(req, body, scopes)
+	default:
+		mh.serveForm(req, values, scopes)
+	}
+}
+
+func (mh *micropubHandler) query(req *web.Request) {
+	switch req.Param.Get("q") {
+	case "config":
+		// Nothing to syndicate to, and media is uploaded to MediaUrl if
+		// uploads are enabled.
+		config := map[string]interface{}{"syndicate-to": []interface{}{}}
+		if mh.context.Config.MediaPath != "" {
+			config["media-endpoint"] = mh.context.Config.BlogUrl.String() + mh.context.Config.MediaUrl
+		}
+		micropubJson(req, config)
+	case "category":
+		micropubJson(req, map[string]interface{}{"categories": mh.db.GetCategories()})
+	case "source":
+		post, found := mh.lookup(req.Param.Get("url"))
+		if !found {
+			micropubError(req, web.StatusBadRequest, "invalid_request", "No such post.")
+			return
+		}
+
+		source := micropubSource(post)
+		if wanted := req.Param["properties[]"]; len(wanted) > 0 {
+			properties := source["properties"].(map[string]interface{})
+			filtered := make(map[string]interface{})
+			for _, name := range wanted {
+				if value, found := properties[name]; found {
+					filtered[name] = value
+				}
+			}
+			source = map[string]interface{}{"properties": filtered}
+		}
+
+		micropubJson(req, source)
+	default:
+		micropubError(req, web.StatusBadRequest, "invalid_request", "Unknown query.")
+	}
+}
+
+func (mh *micropubHandler) serveForm(req *web.Request, values map[string][]string, scopes []string) {
+	action := ""
+	if actions := values["action"]; len(actions) > 0 {
+		action = actions[0]
+	}
+
+	switch action {
+	case "":
+		mh.create(req, micropubFormProperties(values), scopes)
+	case "delete":
+		url := ""
+		if urls := values["url"]; len(urls) > 0 {
+			url = urls[0]
+		}
+		mh.delete(req, url, scopes)
+	default:
+		micropubError(req, web.StatusBadRequest, "invalid_request", "Unsupported action: "+action)
+	}
+}
+
+func (mh *micropubHandler) serveJson(req *web.Request, body map[string]interface{}, scopes []string) {
+	action, _ := body["action"].(string)
+	url, _ := body["url"].(string)
+
+	switch action {
+	case "":
+		properties, _ := body["properties"].(map[string]interface{})
+		mh.create(req, micropubJsonProperties(properties), scopes)
+	case "update":
+		mh.update(req, url, body, scopes)
+	case "delete":
+		mh.delete(req, url, scopes)
+	default:
+		micropubError(req, web.StatusBadRequest, "invalid_request", "Unsupported action: "+action)
+	}
+}
+
+func (mh *micropubHandler) create(req *web.Request, properties map[string][]interface{}, scopes []string) {
+	if !hasScope(scopes, "create") && !hasScope(scopes, "post") {
+		micropubError(req, web.StatusForbidden, "insufficient_scope", "The create scope is required.")
+		return
+	}
+
+	post := &store.Post{Type: "post", Status: "publish", Format: "convertbreaks"}
+	applyMicropubProperties(post, properties)
+
+	if err := mh.db.SavePost(post); err != nil {
+		micropubError(req, web.StatusInternalServerError, "server_error", err.String())
+		return
+	}
+	postChanged(mh.context, mh.db, post.Uuid, nil)
+
+	req.Respond(web.StatusCreated, web.HeaderLocation, post.CanonicalBlogUrl.String()+post.CanonicalPath)
+}
+
+func (mh *micropubHandler) update(req *web.Request, url string, body map[string]interface{}, scopes []string) {
+	if !hasScope(scopes, "update") {
+		micropubError(req, web.StatusForbidden, "insufficient_scope", "The update scope is required.")
+		return
+	}
+
+	old, found := mh.lookup(url)
+	if !found {
+		micropubError(req, web.StatusBadRequest, "invalid_request", "No such post.")
+		return
+	}
+
+	// Work on the current properties of the post and apply them all again.
+	properties := micropubJsonProperties(micropubSource(old)["properties"].(map[string]interface{}))
+
+	if replace, ok := body["replace"].(map[string]interface{}); ok {
+		for name, values := range micropubJsonProperties(replace) {
+			properties[name] = values
+		}
+	}
+	if add, ok := body["add"].(map[string]interface{}); ok {
+		for name, values := range micropubJsonProperties(add) {
+			properties[name] = append(properties[name], values...)
+		}
+	}
+	switch remove := body["delete"].(type) {
+	case []interface{}:
+		// Remove whole properties.
+		for _, name := range remove {
+			if name, ok := name.(string); ok {
+				properties[name] = nil
+			}
+		}
+	case map[string]interface{}:
+		// Remove individual values.
+		for name, values := range micropubJsonProperties(remove) {
+			var kept []interface{}
+			for _, value := range properties[name] {
+				if !containsValue(values, value) {
+					kept = append(kept, value)
+				}
+			}
+			properties[name] = kept
+		}
+	}
+
+	post := old.Copy()
+	applyMicropubProperties(post, properties)
+
+	if err := mh.db.UpdatePost(post); err != nil {
+		micropubError(req, web.StatusInternalServerError, "server_error", err.String())
+		return
+	}
+	postChanged(mh.context, mh.db, post.Uuid, old)
+
+	req.Respond(web.StatusNoContent)
+}
+
+func (mh *micropubHandler) delete(req *web.Request, url string, scopes []string) {
+	if !hasScope(scopes, "delete") {
+		micropubError(req, web.StatusForbidden, "insufficient_scope", "The delete scope is required.")
+		return
+	}
+
+	post, found := mh.lookup(url)
+	if !found {
+		micropubError(req, web.StatusBadRequest, "invalid_request", "No such post.")
+		return
+	}
+
+	if err := mh.db.DeletePost(post.Uuid); err != nil {
+		micropubError(req, web.StatusInternalServerError, "server_error", err.String())
+		return
+	}
+	postChanged(mh.context, mh.db, post.Uuid, post)
+
+	req.Respond(web.StatusNoContent)
+}
+
+// lookup finds the post or page with the given url, whatever its status, so
+// that drafts and scheduled posts can be edited too. Visible items take
+// precedence over others with the same path.
+func (mh *micropubHandler) lookup(rawUrl string) (post *store.Post, found bool) {
+	url, err := http.ParseURL(rawUrl)
+	if err != nil {
+		return
+	}
+
+	if post, found = mh.db.GetPostByPath(url.Path); found {
+		return
+	}
+	if post, found = mh.db.GetPage(url.Path); found {
+		return
+	}
+
+	for _, item := range mh.db.GetAllPosts() {
+		if item.Path == url.Path {
+			return mh.db.GetPostByUuid(item.Uuid)
+		}
+	}
+
+	return nil, false
+}
+
+// micropubFormProperties returns the properties of a form-encoded request.
+func micropubFormProperties(values map[string][]string) map[string][]interface{} {
+	properties := make(map[string][]interface{})
+	for name, list := range values {
+		if name == "h" || name == "access_token" || name == "action" {
+			continue
+		}
+
+		name = strings.TrimRight(name, "[]")
+		for _, value := range list {
+			properties[name] = append(properties[name], value)
+		}
+	}
+
+	return properties
+}
+
+// micropubJsonProperties returns the properties of a JSON request, in which
+// every property should be an array but single values are tolerated.
+func micropubJsonProperties(object map[string]interface{}) map[string][]interface{} {
+	properties := make(map[string][]interface{})
+	for name, value := range object {
+		if list, ok := value.([]interface{}); ok {
+			properties[name] = list
+		} else {
+			properties[name] = []interface{}{value}
+		}
+	}
+
+	return properties
+}
+
+// applyMicropubProperties copies h-entry properties into a post.
+func applyMicropubProperties(post *store.Post, properties map[string][]interface{}) {
+	first := func(name string) (interface{}, bool) {
+		values, found := properties[name]
+		if !found {
+			return nil, false
+		}
+		if len(values) == 0 {
+			return "", true
+		}
+		return values[0], true
+	}
+
+	if name, found := first("name"); found {
+		post.Title, _ = name.(string)
+	}
+	if content, found := first("content"); found {
+		switch content := content.(type) {
+		case string:
+			post.Body = content
+		case map[string]interface{}:
+			if html, ok := content["html"].(string); ok {
+				post.Body = html
+				post.Format = "none"
+			} else if text, ok := content["value"].(string); ok {
+				post.Body = text
+			}
+		}
+	}
+	if categories, found := properties["category"]; found {
+		post.Categories = nil
+		for _, category := range categories {
+			if category, ok := category.(string); ok && category != "" {
+				post.Categories = append(post.Categories, category)
+			}
+		}
+	}
+	if published, found := first("published"); found {
+		if published, ok := published.(string); ok {
+			if t, err := time.Parse(time.RFC3339, published); err == nil {
+				post.Published = t
+			}
+		}
+	}
+	if status, found := first("post-status"); found {
+		// Any other status leaves the post as it is.
+		switch status {
+		case "published":
+			post.Status = "publish"
+		case "scheduled", "draft":
+			post.Status = status.(string)
+		}
+	}
+	if slug, found := first("mp-slug"); found {
+		if slug, ok := slug.(string); ok && slug != "" {
+			post.Basename = slug
+		}
+	}
+}
+
+// micropubSource returns the h-entry for a post, as used by q=source.
+func micropubSource(post *store.Post) map[string]interface{} {
+	content := interface{}(post.Body)
+	if post.Format == "none" {
+		content = map[string]interface{}{"html": post.Body}
+	}
+
+	status := "draft"
+	switch {
+	case post.IsPublished():
+		status = "published"
+	case post.IsScheduled():
+		status = "scheduled"
+	}
+
+	categories := []interface{}{}
+	for _, category := range post.Categories {
+		categories = append(categories, category)
+	}
+
+	return map[string]interface{}{
+		"type": []interface{}{"h-entry"},
+		"properties": map[string]interface{}{
+			"name":        []interface{}{post.Title},
+			"content":     []interface{}{content},
+			"category":    categories,
+			"published":   []interface{}{post.Published.Format(time.RFC3339)},
+			"post-status": []interface{}{status},
+			"mp-slug":     []interface{}{post.Basename},
+		},
+	}
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsValue returns whether value is one of values. Only strings can be
+// matched.
+func containsValue(values []interface{}, value interface{}) bool {
+	s, ok := value.(string)
+	if !ok {
+		return false
+	}
+
+	for _, v := range values {
+		if v, ok := v.(string); ok && v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MicropubHandler returns a request handler that implements Micropub, using
+// verifier to check access tokens.
+func MicropubHandler(context *RenderContext, db store.WritableStore, verifier TokenVerifier) web.Handler {
+	return &micropubHandler{context, db, verifier}
+}
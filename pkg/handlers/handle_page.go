@@ -18,9 +18,8 @@ package handlers
 
 import (
 	"bytes"
-	"fmt"
 	"github.com/garyburd/twister/web"
-	"os"
+	"github.com/stevela/lwb/lwb"
 )
 
 type pageHandler struct {
@@ -28,25 +27,25 @@ type pageHandler struct {
 }
 
 func (ph *pageHandler) ServeWeb(req *web.Request) {
-	// Render page.
-	fmt.Println(req.URL.Path)
-	post, found := ph.context.Db.GetPage(req.URL.Path)
-	if !found {
-		req.Error(web.StatusNotFound, os.NewError("Not Found."))
-		return
-	}
-
-	local_context := *ph.context
-	local_context.Title = post.Title
-	local_context.Path = post.CanonicalBlogUrl.String() + post.CanonicalPath
-
-	var content bytes.Buffer
-	renderPost(&content, &local_context, post, post.CommentOnPage)
-
-	// Render page.
-	templates["main"].Execute(
-		req.Respond(web.StatusOK, web.HeaderContentType, "text/html"),
-		makeTemplateParams(&local_context, content.Bytes()))
+	ph.context.Config.Cache.Run(req, func(w *lwb.Page) bool {
+		post, found := ph.context.Db.GetPage(req.URL.Path)
+		if !found {
+			return false
+		}
+		w.LastModified = post.LastModified
+
+		local_context := ph.context.snapshot()
+		local_context.Title = post.Title
+		local_context.Path = post.CanonicalBlogUrl.String() + post.CanonicalPath
+
+		var content bytes.Buffer
+		renderPost(&content, local_context, post, post.CommentOnPage)
+
+		// Render page.
+		templates["main"].Execute(w, makeTemplateParams(local_context, content.Bytes()))
+
+		return true
+	})
 }
 
 func PageHandler(context *RenderContext) web.Handler {
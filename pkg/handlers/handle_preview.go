@@ -0,0 +1,119 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"os"
+	"strconv"
+	"time"
+)
+
+// How long the preview links handed out by the editing handlers stay valid.
+const previewLifetime = 24 * 60 * 60
+
+type previewHandler struct {
+	context *RenderContext
+	db      store.WritableStore
+}
+
+// Previews are rendered afresh for every request and never cached, as they are
+// only visible to whoever holds the link.
+func (ph *previewHandler) ServeWeb(req *web.Request) {
+	config := ph.context.Config
+	uuid := req.Param.Get("uuid")
+	expires, err := strconv.Atoi64(req.Param.Get("expires"))
+	if err != nil || !checkPreviewSignature(config, uuid, expires, req.Param.Get("sig")) {
+		req.Error(web.StatusForbidden, os.NewError("Invalid preview link."))
+		return
+	}
+
+	if expires < time.Seconds() {
+		req.Error(web.StatusForbidden, os.NewError("Preview link has expired."))
+		return
+	}
+
+	post, found := ph.db.GetPostByUuid(uuid)
+	if !found {
+		req.Error(web.StatusNotFound, os.NewError("Not Found."))
+		return
+	}
+
+	local_context := ph.context.snapshot()
+	local_context.Title = "Preview: " + post.Title
+	local_context.UseCache = false
+
+	var content bytes.Buffer
+	renderPost(&content, local_context, post, false)
+
+	// Render page.
+	page := &lwb.Page{
+		Header: web.Header{
+			web.HeaderCacheControl: {"private, no-cache"},
+			"X-Robots-Tag":         {"noindex"},
+		},
+		LastModified: post.LastModified,
+	}
+	templates["main"].Execute(page, makeTemplateParams(local_context, content.Bytes()))
+	page.Serve(req)
+}
+
+// previewSignature returns the signature of a preview link for the post with
+// the given uuid that expires at the given time.
+func previewSignature(config *lwb.BlogConfig, uuid string, expires int64) string {
+	h := hmac.NewSHA1([]byte(config.PreviewSecret))
+	fmt.Fprintf(h, "%s:%d", uuid, expires)
+
+	return hex.EncodeToString(h.Sum())
+}
+
+// checkPreviewSignature returns whether sig is a valid signature. Previews are
+// disabled if there is no secret.
+func checkPreviewSignature(config *lwb.BlogConfig, uuid string, expires int64, sig string) bool {
+	if config.PreviewSecret == "" {
+		return false
+	}
+
+	expected := previewSignature(config, uuid, expires)
+
+	return len(sig) == len(expected) && subtle.ConstantTimeCompare([]byte(sig), []byte(expected)) == 1
+}
+
+// PreviewUrl returns a link that shows the post with the given uuid, whether
+// or not it has been published, until expires (in seconds since the epoch).
+// It returns an empty string if previews are disabled.
+func PreviewUrl(config *lwb.BlogConfig, uuid string, expires int64) string {
+	if config.PreviewSecret == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s%s/%s?expires=%d&sig=%s", config.BlogUrl, config.PreviewUrl,
+		uuid, expires, previewSignature(config, uuid, expires))
+}
+
+// PreviewHandler returns a request handler that renders drafts given a link
+// from PreviewUrl.
+func PreviewHandler(context *RenderContext, db store.WritableStore) web.Handler {
+	return &previewHandler{context, db}
+}
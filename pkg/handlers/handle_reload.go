@@ -0,0 +1,57 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"crypto/subtle"
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/store"
+	"io"
+	"os"
+)
+
+type reloadHandler struct {
+	context *RenderContext
+	db      store.Reloader
+}
+
+// Reloads the store, e.g. from a git post-receive hook, given the secret as
+// the "token" parameter.
+func (rh *reloadHandler) ServeWeb(req *web.Request) {
+	secret := rh.context.Config.ReloadSecret
+	token := req.Param.Get("token")
+	if secret == "" || len(token) != len(secret) ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		req.Error(web.StatusForbidden, os.NewError("Forbidden."))
+		return
+	}
+
+	if err := rh.db.Reload(); err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+
+	io.WriteString(req.Respond(web.StatusOK,
+		web.HeaderContentType, "text/plain; charset=utf-8",
+		web.HeaderCacheControl, "private, no-cache"), "Reloaded.\n")
+}
+
+// ReloadHandler returns a request handler that reloads the store. It is
+// disabled if the config has no ReloadSecret.
+func ReloadHandler(context *RenderContext, db store.Reloader) web.Handler {
+	return &reloadHandler{context, db}
+}
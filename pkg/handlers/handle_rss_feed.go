@@ -19,34 +19,52 @@ package handlers
 import (
 	"bytes"
 	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
 	"io"
 	"time"
 )
 
+const rssContentType = "application/rss+xml"
+
 type rssFeedHandler struct {
 	context *RenderContext
 }
 
 func (rfh *rssFeedHandler) ServeWeb(req *web.Request) {
-	rfh.context.Config.Cache.Run(req, func(w io.Writer) bool {
-		// Render posts.
-		var content bytes.Buffer
-		posts := rfh.context.Db.GetRecentPosts(rfh.context.Config.NumRssFeedPosts)
-		for _, post := range posts {
-			data := makeTemplateParams(rfh.context, post)
-			templates["rss_item"].Template.Execute(&content, data)
-		}
-
-		// Render page.
-		data := makeTemplateParams(rfh.context, content.Bytes())
-		data["lastBuildDate"] = posts[0].Published.Format(time.RFC1123)
+	rfh.context.Config.Cache.Run(req, func(w *lwb.Page) bool {
+		context := rfh.context.snapshot()
 
-		templates["rss"].Template.Execute(w, data)
+		posts := context.Db.GetRecentPosts(context.Config.NumRssFeedPosts)
+		w.ContentType = rssContentType
+		w.LastModified = lastModified(posts)
+		renderRssFeed(w, context, context.Config.RssUrl, posts)
 
 		return true
 	})
 }
 
+// renderRssFeed renders an RSS 2.0 feed of posts. selfUrl is the path the feed
+// is published at; the feed links to the page in the same directory.
+func renderRssFeed(w io.Writer, context *RenderContext, selfUrl string, posts []*store.Post) {
+	// Render posts.
+	var content bytes.Buffer
+	for _, post := range posts {
+		data := makeTemplateParams(context, post)
+		templates["rss_item"].Template.Execute(&content, data)
+	}
+
+	// Render page.
+	data := makeTemplateParams(context, content.Bytes())
+	data["selfUrl"] = selfUrl
+	data["htmlUrl"] = feedHtmlUrl(selfUrl)
+	if len(posts) > 0 {
+		data["lastBuildDate"] = posts[0].Published.Format(time.RFC1123)
+	}
+
+	templates["rss"].Template.Execute(w, data)
+}
+
 func RssFeedHandler(context *RenderContext) web.Handler {
 	return &rssFeedHandler{context}
 }
@@ -0,0 +1,79 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
+	"http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type searchHandler struct {
+	context *RenderContext
+}
+
+// The results depend on the query, so aren't cached.
+func (sh *searchHandler) ServeWeb(req *web.Request) {
+	context := sh.context.snapshot()
+
+	query := strings.TrimSpace(req.Param.Get("q"))
+	results := context.Db.Search(query)
+
+	pageUrl := func(page int) string {
+		url := context.Config.SearchUrl + "?q=" + http.URLEscape(query)
+		if page > 1 {
+			url += "&page=" + strconv.Itoa(page)
+		}
+		return url
+	}
+
+	posts, pagination, ok := paginate(req, results, context.Config.NumArchivePosts, pageUrl)
+	if !ok {
+		req.Error(web.StatusNotFound, os.NewError("Not Found."))
+		return
+	}
+
+	context.Title = "Search results for " + query + " - " + context.Config.Title
+
+	// Render results.
+	var content bytes.Buffer
+	data := makeTemplateParams(context, posts)
+	data["query"] = query
+	data["numResults"] = len(results)
+	templates["search"].Template.Execute(&content, data)
+
+	// Render page.
+	data = makeTemplateParams(context, content.Bytes())
+	if pagination != nil {
+		data["pagination"] = pagination
+	}
+
+	page := &lwb.Page{LastModified: lastModified(posts)}
+	templates["main"].Template.Execute(page, data)
+
+	page.Serve(req)
+}
+
+// SearchHandler returns a request handler that serves search results for the
+// query in the "q" parameter.
+func SearchHandler(context *RenderContext) web.Handler {
+	return &searchHandler{context}
+}
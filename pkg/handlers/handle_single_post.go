@@ -19,7 +19,7 @@ package handlers
 import (
 	"bytes"
 	"github.com/garyburd/twister/web"
-	"io"
+	"github.com/stevela/lwb/lwb"
 )
 
 type singlePostHandler struct {
@@ -27,22 +27,23 @@ type singlePostHandler struct {
 }
 
 func (sph *singlePostHandler) ServeWeb(req *web.Request) {
-	sph.context.Config.Cache.Run(req, func(w io.Writer) bool {
+	sph.context.Config.Cache.Run(req, func(w *lwb.Page) bool {
 		// Render post.
 		post, found := sph.context.Db.GetPostByPath(req.URL.Path)
 		if !found {
 			return false
 		}
+		w.LastModified = post.LastModified
 
-		local_context := *sph.context
+		local_context := sph.context.snapshot()
 		local_context.Title = post.Title
 		local_context.Path = post.CanonicalBlogUrl.String() + post.CanonicalPath
 
 		var content bytes.Buffer
-		renderPost(&content, &local_context, post, true)
+		renderPost(&content, local_context, post, true)
 
 		// Render page.
-		templates["main"].Execute(w, makeTemplateParams(&local_context, content.Bytes()))
+		templates["main"].Execute(w, makeTemplateParams(local_context, content.Bytes()))
 
 		return true
 	})
@@ -0,0 +1,244 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"fmt"
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sitemapNamespace   = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	sitemapContentType = "application/xml"
+)
+
+// The most urls allowed in a single sitemap by the protocol. Larger sitemaps
+// are split up and listed in a sitemap index. Tests lower it to split small
+// sitemaps.
+var maxSitemapUrls = 50000
+
+// sitemapUrl is a single entry in a sitemap.
+type sitemapUrl struct {
+	Path    string
+	LastMod *time.Time
+}
+
+type sitemapHandler struct {
+	context *RenderContext
+}
+
+func (sh *sitemapHandler) ServeWeb(req *web.Request) {
+	sh.context.Config.Cache.Run(req, func(w *lwb.Page) bool {
+		context := sh.context.snapshot()
+		sm := newSitemap(context.Db, context.Config.NumMainIndexPosts)
+		numSitemaps := (sm.len() + maxSitemapUrls - 1) / maxSitemapUrls
+		w.ContentType = sitemapContentType
+
+		pageStr := req.Param.Get("page")
+		if pageStr == "" {
+			if numSitemaps > 1 {
+				w.LastModified = writeSitemapIndex(w, context, sm, numSitemaps)
+			} else {
+				urls := sm.urls(0, sm.len())
+				w.LastModified = urlsLastModified(urls)
+				writeSitemap(w, context, urls)
+			}
+
+			return true
+		}
+
+		// One part of a split sitemap.
+		page, err := strconv.Atoi(pageStr)
+		if err != nil || page < 1 || page > numSitemaps || numSitemaps == 1 {
+			return false
+		}
+
+		urls := sm.urls((page-1)*maxSitemapUrls, page*maxSitemapUrls)
+		w.LastModified = urlsLastModified(urls)
+		writeSitemap(w, context, urls)
+
+		return true
+	})
+}
+
+// sitemap lists the urls of the main index, every published page and post, and
+// the date, tag and category archives, in that order. The posts are only read
+// a part of the sitemap at a time, as there may be too many to hold at once.
+type sitemap struct {
+	db       store.Store
+	head     []sitemapUrl // The main index and the pages.
+	numPosts int
+	tail     []sitemapUrl // The archives.
+}
+
+// newSitemap lists the urls of the blog in db, whose main index shows
+// numIndexPosts posts.
+func newSitemap(db store.Store, numIndexPosts int) *sitemap {
+	sm := &sitemap{db: db, numPosts: db.NumPosts()}
+	if numIndexPosts <= 0 {
+		numIndexPosts = sm.numPosts
+	}
+	sm.head = append(sm.head, sitemapUrl{"/", lastModified(db.GetPostsPage(0, numIndexPosts))})
+
+	for _, page := range db.GetPages() {
+		sm.head = append(sm.head, sitemapUrl{page.Path, page.LastModified})
+	}
+
+	// Yearly archives come before the monthly archives within them.
+	year := 0
+	for _, archive := range db.GetArchives() {
+		if archive.Year != year {
+			year = archive.Year
+			yearPosts, _ := db.GetPostsByYear(year)
+			sm.tail = append(sm.tail, sitemapUrl{fmt.Sprintf("/%d/", year), lastModified(yearPosts)})
+		}
+
+		monthPosts, _ := db.GetPostsByYearMonth(archive.Year, archive.Month+1)
+		sm.tail = append(sm.tail, sitemapUrl{archive.Path, lastModified(monthPosts)})
+	}
+
+	// Sorted, so that every part of a split sitemap is cut from the same list.
+	tags := db.GetTags()
+	categories := db.GetCategories()
+	sort.SortStrings(tags)
+	sort.SortStrings(categories)
+
+	for _, tag := range tags {
+		tagPosts, _ := db.GetPostsByTag(tag)
+		sm.tail = append(sm.tail, sitemapUrl{"/tag/" + escapeSpaces(tag) + "/", lastModified(tagPosts)})
+	}
+
+	for _, category := range categories {
+		categoryPosts, _ := db.GetPostsByCategory(category)
+		sm.tail = append(sm.tail, sitemapUrl{"/category/" + escapeSpaces(category) + "/", lastModified(categoryPosts)})
+	}
+
+	return sm
+}
+
+// len returns the number of urls in the sitemap.
+func (sm *sitemap) len() int {
+	return len(sm.head) + sm.numPosts + len(sm.tail)
+}
+
+// urls returns the urls from start up to, but not including, end.
+func (sm *sitemap) urls(start, end int) (urls []sitemapUrl) {
+	if end > sm.len() {
+		end = sm.len()
+	}
+
+	for i := start; i < end && i < len(sm.head); i += 1 {
+		urls = append(urls, sm.head[i])
+	}
+
+	// The posts in range, read in one go.
+	postsStart, postsEnd := len(sm.head), len(sm.head)+sm.numPosts
+	from, to := start, end
+	if from < postsStart {
+		from = postsStart
+	}
+	if to > postsEnd {
+		to = postsEnd
+	}
+	if from < to {
+		for _, post := range sm.db.GetPostsPage(from-postsStart, to-from) {
+			urls = append(urls, sitemapUrl{post.Path, post.LastModified})
+		}
+	}
+
+	from = start
+	if from < postsEnd {
+		from = postsEnd
+	}
+	for i := from; i < end; i += 1 {
+		urls = append(urls, sm.tail[i-postsEnd])
+	}
+
+	return
+}
+
+func escapeSpaces(s string) string {
+	return strings.Replace(s, " ", "%20", -1)
+}
+
+// urlsLastModified returns the most recent modification time of urls, or nil
+// if none are known.
+func urlsLastModified(urls []sitemapUrl) (t *time.Time) {
+	for _, url := range urls {
+		if url.LastMod != nil && (t == nil || url.LastMod.Seconds() > t.Seconds()) {
+			t = url.LastMod
+		}
+	}
+
+	return
+}
+
+func writeSitemap(w io.Writer, context *RenderContext, urls []sitemapUrl) {
+	blogUrl := context.Config.BlogUrl.String()
+
+	io.WriteString(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	fmt.Fprintf(w, "<urlset xmlns=\"%s\">\n", sitemapNamespace)
+	for _, url := range urls {
+		io.WriteString(w, "<url>")
+		writeXmlElement(w, "loc", blogUrl+url.Path)
+		if url.LastMod != nil {
+			writeXmlElement(w, "lastmod", url.LastMod.Format(time.RFC3339))
+		}
+		io.WriteString(w, "</url>\n")
+	}
+	io.WriteString(w, "</urlset>\n")
+}
+
+// writeSitemapIndex writes an index of the parts of a split sitemap, and
+// returns the most recent modification of anything in them.
+func writeSitemapIndex(w io.Writer, context *RenderContext, sm *sitemap, numSitemaps int) (updated *time.Time) {
+	indexUrl := context.Config.BlogUrl.String() + context.Config.SitemapUrl
+
+	io.WriteString(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	fmt.Fprintf(w, "<sitemapindex xmlns=\"%s\">\n", sitemapNamespace)
+	for page := 1; page <= numSitemaps; page += 1 {
+		// The most recent modification of anything in this part.
+		lastMod := urlsLastModified(sm.urls((page-1)*maxSitemapUrls, page*maxSitemapUrls))
+		if lastMod != nil && (updated == nil || lastMod.Seconds() > updated.Seconds()) {
+			updated = lastMod
+		}
+
+		io.WriteString(w, "<sitemap>")
+		writeXmlElement(w, "loc", indexUrl+"?page="+strconv.Itoa(page))
+		if lastMod != nil {
+			writeXmlElement(w, "lastmod", lastMod.Format(time.RFC3339))
+		}
+		io.WriteString(w, "</sitemap>\n")
+	}
+	io.WriteString(w, "</sitemapindex>\n")
+
+	return
+}
+
+// SitemapHandler returns a request handler that serves a sitemap
+// (http://www.sitemaps.org) of the blog, or a sitemap index if there are too
+// many urls for a single sitemap.
+func SitemapHandler(context *RenderContext) web.Handler {
+	return &sitemapHandler{context}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
+	"http"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"time"
+)
+
+// The precompressed siblings of static files, in order of preference, along
+// with the content coding each is served with. Brotli files have to be made
+// ahead of time, as the standard library can't compress them.
+var staticEncodings = []struct {
+	coding string
+	ext    string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+type staticHandler struct {
+	root      string
+	mimeTypes map[string]string
+	header    web.Header
+
+	// The options for serving files without a compressed sibling, and for
+	// serving the uncompressed version of those with one.
+	options     *web.ServeFileOptions
+	varyOptions *web.ServeFileOptions
+}
+
+func (sh *staticHandler) ServeWeb(req *web.Request) {
+	name := path.Clean("/" + req.Param.Get("path"))
+	fname := path.Join(sh.root, name)
+
+	options := sh.options
+	for _, encoding := range staticEncodings {
+		fileInfo, err := os.Stat(fname + encoding.ext)
+		if err != nil || !fileInfo.IsRegular() {
+			continue
+		}
+
+		if lwb.AcceptsEncoding(req.Header, encoding.coding) {
+			sh.serveCompressed(req, name, fname+encoding.ext, encoding.coding,
+				time.SecondsToUTC(fileInfo.Mtime_ns/1e9))
+			return
+		}
+		options = sh.varyOptions
+	}
+
+	web.ServeFile(req, fname, options)
+}
+
+// serveCompressed serves fname, the compressed sibling of the static file at
+// name, with the content type of the original.
+func (sh *staticHandler) serveCompressed(req *web.Request, name, fname, coding string, modified *time.Time) {
+	f, err := os.Open(fname)
+	if err != nil {
+		req.Error(web.StatusNotFound, os.NewError("Not Found."))
+		return
+	}
+	defer f.Close()
+
+	header := []string{
+		web.HeaderVary, web.HeaderAcceptEncoding,
+		web.HeaderLastModified, modified.Format(http.TimeFormat),
+	}
+	for key, values := range sh.header {
+		for _, value := range values {
+			header = append(header, key, value)
+		}
+	}
+
+	since, err := time.Parse(http.TimeFormat, req.Header.Get(web.HeaderIfModifiedSince))
+	if err == nil && modified.Seconds() <= since.Seconds() {
+		req.Respond(web.StatusNotModified, header...)
+		return
+	}
+
+	ext := path.Ext(name)
+	mimeType := sh.mimeTypes[ext]
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(ext)
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	header = append(header, web.HeaderContentType, mimeType, web.HeaderContentEncoding, coding)
+
+	io.Copy(req.Respond(web.StatusOK, header...), f)
+}
+
+// StaticHandler returns a request handler that serves the files under root,
+// like web.DirectoryHandler. Files with a precompressed sibling, e.g.
+// style.css.gz or style.css.br, are served compressed to clients that accept
+// it. mimeTypes maps extensions to content types, and header is added to every
+// response.
+func StaticHandler(root string, mimeTypes map[string]string, header web.Header) web.Handler {
+	varyHeader := web.Header{web.HeaderVary: {web.HeaderAcceptEncoding}}
+	for key, values := range header {
+		varyHeader[key] = values
+	}
+
+	return &staticHandler{
+		root:        root,
+		mimeTypes:   mimeTypes,
+		header:      header,
+		options:     &web.ServeFileOptions{mimeTypes, header},
+		varyOptions: &web.ServeFileOptions{mimeTypes, varyHeader},
+	}
+}
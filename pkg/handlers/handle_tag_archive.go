@@ -19,8 +19,8 @@ package handlers
 import (
 	"bytes"
 	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
 	"github.com/stevela/lwb/store"
-	"io"
 )
 
 type TagLookupFunc func(string) ([]*store.Post, bool)
@@ -31,7 +31,9 @@ type tagArchiveHandler struct {
 }
 
 func (tah *tagArchiveHandler) ServeWeb(req *web.Request) {
-	tah.context.Config.Cache.Run(req, func(w io.Writer) bool {
+	tah.context.Config.Cache.Run(req, func(w *lwb.Page) bool {
+		context := tah.context.snapshot()
+
 		var posts []*store.Post
 
 		found := false
@@ -41,14 +43,25 @@ func (tah *tagArchiveHandler) ServeWeb(req *web.Request) {
 			}
 		}
 
+		posts, pagination, ok := paginate(req, posts, context.Config.NumArchivePosts, queryPageUrl(req.URL.Path))
+		if !ok {
+			return false
+		}
+
 		// Render posts.
+		w.LastModified = lastModified(posts)
 		var content bytes.Buffer
 		for _, post := range posts {
-			renderPost(&content, tah.context, post, false)
+			renderPost(&content, context, post, false)
 		}
 
 		// Render page.
-		templates["main"].Template.Execute(w, makeTemplateParams(tah.context, content.Bytes()))
+		data := makeTemplateParams(context, content.Bytes())
+		if pagination != nil {
+			data["pagination"] = pagination
+		}
+
+		templates["main"].Template.Execute(w, data)
 
 		return true
 	})
@@ -0,0 +1,82 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"io"
+	"strings"
+)
+
+// feedRenderFunc renders a feed of posts published at selfUrl.
+type feedRenderFunc func(w io.Writer, context *RenderContext, selfUrl string, posts []*store.Post)
+
+type tagFeedHandler struct {
+	context  *RenderContext
+	fnLookup TagLookupFunc
+	fnRender feedRenderFunc
+
+	// The content type of the feeds fnRender renders.
+	contentType string
+}
+
+func (tfh *tagFeedHandler) ServeWeb(req *web.Request) {
+	tfh.context.Config.Cache.Run(req, func(w *lwb.Page) bool {
+		context := tfh.context.snapshot()
+
+		tag := req.Param.Get("tag")
+		if tag == "" {
+			return false
+		}
+
+		posts, found := tfh.fnLookup(tag)
+		if !found {
+			return false
+		}
+
+		if len(posts) > context.Config.NumRssFeedPosts {
+			posts = posts[:context.Config.NumRssFeedPosts]
+		}
+
+		context.Title = context.Config.Title + " - " + tag
+		w.ContentType = tfh.contentType
+		w.LastModified = lastModified(posts)
+		tfh.fnRender(w, context, req.URL.Path, posts)
+
+		return true
+	})
+}
+
+// feedHtmlUrl returns the path of the page a feed at selfUrl belongs to, which
+// is the directory containing it.
+func feedHtmlUrl(selfUrl string) string {
+	return selfUrl[:strings.LastIndex(selfUrl, "/")+1]
+}
+
+// TagRssFeedHandler returns a request handler that serves an RSS 2.0 feed of
+// the posts with a tag or category.
+func TagRssFeedHandler(context *RenderContext, fn TagLookupFunc) web.Handler {
+	return &tagFeedHandler{context, fn, renderRssFeed, rssContentType}
+}
+
+// TagAtomFeedHandler returns a request handler that serves an Atom 1.0 feed of
+// the posts with a tag or category.
+func TagAtomFeedHandler(context *RenderContext, fn TagLookupFunc) web.Handler {
+	return &tagFeedHandler{context, fn, renderAtomFeed, atomFeedContentType}
+}
@@ -0,0 +1,351 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"io"
+	"strings"
+	"time"
+)
+
+// The blog id handed out to editors. There is only ever one blog.
+const rpcBlogId = "1"
+
+type xmlRpcHandler struct {
+	context *RenderContext
+	db      store.WritableStore
+}
+
+type rpcMethod func(xh *xmlRpcHandler, params []interface{}) (interface{}, *rpcFault)
+
+// The supported methods, along with the index of the username parameter. The
+// password always follows the username.
+var rpcMethods = map[string]struct {
+	fn            rpcMethod
+	usernameParam int
+}{
+	"blogger.getUsersBlogs":     {rpcGetUsersBlogs, 1},
+	"blogger.deletePost":        {rpcDeletePost, 2},
+	"metaWeblog.newPost":        {rpcNewPost, 1},
+	"metaWeblog.editPost":       {rpcEditPost, 1},
+	"metaWeblog.getPost":        {rpcGetPost, 1},
+	"metaWeblog.getRecentPosts": {rpcGetRecentPosts, 1},
+	"metaWeblog.getCategories":  {rpcGetCategories, 1},
+	"metaWeblog.newMediaObject": {rpcNewMediaObject, 1},
+}
+
+func (xh *xmlRpcHandler) ServeWeb(req *web.Request) {
+	w := req.Respond(web.StatusOK, web.HeaderContentType, "text/xml; charset=utf-8")
+
+	method, params, err := decodeRpcCall(io.LimitReader(req.Body, maxUploadLen))
+	if err != nil {
+		encodeRpcResponse(w, nil, &rpcFault{400, "Malformed request: " + err.String()})
+		return
+	}
+
+	entry, found := rpcMethods[method]
+	if !found {
+		encodeRpcResponse(w, nil, &rpcFault{404, "Unknown method: " + method})
+		return
+	}
+
+	if !xh.authenticate(params, entry.usernameParam) {
+		encodeRpcResponse(w, nil, &rpcFault{403, "Invalid username or password."})
+		return
+	}
+
+	result, fault := entry.fn(xh, params)
+	encodeRpcResponse(w, result, fault)
+}
+
+// authenticate checks the username and password parameters against the
+// configured credentials. Editing is disabled if there is no password.
+func (xh *xmlRpcHandler) authenticate(params []interface{}, usernameParam int) bool {
+	config := xh.context.Config
+	if config.RpcPassword == "" || len(params) < usernameParam+2 {
+		return false
+	}
+
+	username, _ := params[usernameParam].(string)
+	password, _ := params[usernameParam+1].(string)
+
+	return username == config.RpcUsername && password == config.RpcPassword
+}
+
+// blogger.getUsersBlogs(appkey, username, password)
+func rpcGetUsersBlogs(xh *xmlRpcHandler, params []interface{}) (interface{}, *rpcFault) {
+	config := xh.context.Config
+
+	return []interface{}{map[string]interface{}{
+		"blogid":   rpcBlogId,
+		"blogName": config.Title,
+		"url":      config.BlogUrl.String() + "/",
+		"isAdmin":  true,
+	}}, nil
+}
+
+// blogger.deletePost(appkey, postid, username, password, publish)
+func rpcDeletePost(xh *xmlRpcHandler, params []interface{}) (interface{}, *rpcFault) {
+	uuid, _ := params[1].(string)
+	old, _ := xh.db.GetPostByUuid(uuid)
+	if err := xh.db.DeletePost(uuid); err != nil {
+		return nil, &rpcFault{404, err.String()}
+	}
+	postChanged(xh.context, xh.db, uuid, old)
+
+	return true, nil
+}
+
+// metaWeblog.newPost(blogid, username, password, struct, publish)
+func rpcNewPost(xh *xmlRpcHandler, params []interface{}) (interface{}, *rpcFault) {
+	content, publish, fault := rpcContent(params)
+	if fault != nil {
+		return nil, fault
+	}
+
+	post := new(store.Post)
+	applyRpcContent(post, content, publish)
+
+	if err := xh.db.SavePost(post); err != nil {
+		return nil, &rpcFault{500, err.String()}
+	}
+	postChanged(xh.context, xh.db, post.Uuid, nil)
+
+	return post.Uuid, nil
+}
+
+// metaWeblog.editPost(postid, username, password, struct, publish)
+func rpcEditPost(xh *xmlRpcHandler, params []interface{}) (interface{}, *rpcFault) {
+	content, publish, fault := rpcContent(params)
+	if fault != nil {
+		return nil, fault
+	}
+
+	uuid, _ := params[0].(string)
+	old, found := xh.db.GetPostByUuid(uuid)
+	if !found {
+		return nil, &rpcFault{404, "No such post: " + uuid}
+	}
+
+	post := old.Copy()
+	applyRpcContent(post, content, publish)
+
+	if err := xh.db.UpdatePost(post); err != nil {
+		return nil, &rpcFault{500, err.String()}
+	}
+	postChanged(xh.context, xh.db, uuid, old)
+
+	return true, nil
+}
+
+// metaWeblog.getPost(postid, username, password)
+func rpcGetPost(xh *xmlRpcHandler, params []interface{}) (interface{}, *rpcFault) {
+	uuid, _ := params[0].(string)
+	post, found := xh.db.GetPostByUuid(uuid)
+	if !found {
+		return nil, &rpcFault{404, "No such post: " + uuid}
+	}
+
+	return rpcPostStruct(xh.context.Config, post), nil
+}
+
+// metaWeblog.getRecentPosts(blogid, username, password, numberOfPosts)
+func rpcGetRecentPosts(xh *xmlRpcHandler, params []interface{}) (interface{}, *rpcFault) {
+	numPosts := xh.context.Config.NumRecentPosts
+	if len(params) > 3 {
+		if n, ok := params[3].(int); ok {
+			numPosts = n
+		}
+	}
+
+	// Like AtomPub, list drafts and scheduled posts along with published ones.
+	posts := newestFirst(xh.db.GetAllPosts())
+	if numPosts >= 0 && len(posts) > numPosts {
+		posts = posts[:numPosts]
+	}
+
+	var result []interface{}
+	for _, post := range posts {
+		result = append(result, rpcPostStruct(xh.context.Config, post))
+	}
+
+	return result, nil
+}
+
+// metaWeblog.getCategories(blogid, username, password)
+func rpcGetCategories(xh *xmlRpcHandler, params []interface{}) (interface{}, *rpcFault) {
+	blogUrl := xh.context.Config.BlogUrl.String()
+
+	var result []interface{}
+	for _, category := range xh.db.GetCategories() {
+		result = append(result, map[string]interface{}{
+			"categoryId":  category,
+			"title":       category,
+			"description": category,
+			"htmlUrl":     blogUrl + "/category/" + category + "/",
+		})
+	}
+
+	return result, nil
+}
+
+// metaWeblog.newMediaObject(blogid, username, password, struct)
+func rpcNewMediaObject(xh *xmlRpcHandler, params []interface{}) (interface{}, *rpcFault) {
+	config := xh.context.Config
+	if config.MediaPath == "" {
+		return nil, &rpcFault{403, "Media uploads are disabled."}
+	}
+
+	if len(params) < 4 {
+		return nil, &rpcFault{400, "Expected 4 parameters."}
+	}
+
+	media, ok := params[3].(map[string]interface{})
+	if !ok {
+		return nil, &rpcFault{400, "Expected a media object."}
+	}
+
+	name, _ := media["name"].(string)
+	bits, _ := media["bits"].([]byte)
+	_, url, err := saveMedia(config, name, bits)
+	if err != nil {
+		return nil, &rpcFault{500, err.String()}
+	}
+
+	return map[string]interface{}{"url": url}, nil
+}
+
+// rpcContent extracts the content struct and publish flag from the
+// parameters of newPost and editPost.
+func rpcContent(params []interface{}) (content map[string]interface{}, publish bool, fault *rpcFault) {
+	if len(params) < 5 {
+		return nil, false, &rpcFault{400, "Expected 5 parameters."}
+	}
+
+	content, ok := params[3].(map[string]interface{})
+	if !ok {
+		return nil, false, &rpcFault{400, "Expected a content struct."}
+	}
+
+	publish, _ = params[4].(bool)
+
+	return
+}
+
+// applyRpcContent copies the fields of a metaWeblog content struct into a post.
+func applyRpcContent(post *store.Post, content map[string]interface{}, publish bool) {
+	if title, ok := content["title"].(string); ok {
+		post.Title = title
+	}
+	if body, ok := content["description"].(string); ok {
+		post.Body = body
+	}
+	if basename, ok := content["mt_basename"].(string); ok && basename != "" {
+		post.Basename = basename
+	} else if basename, ok := content["wp_slug"].(string); ok && basename != "" {
+		post.Basename = basename
+	}
+	if published, ok := content["dateCreated"].(*time.Time); ok {
+		post.Published = published
+	}
+	if categories, ok := content["categories"].([]interface{}); ok {
+		post.Categories = rpcStrings(categories)
+	}
+	if keywords, ok := content["mt_keywords"].(string); ok {
+		post.Tags = nil
+		for _, tag := range strings.Split(keywords, ",", -1) {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				post.Tags = append(post.Tags, tag)
+			}
+		}
+	}
+	if format, ok := content["mt_convert_breaks"].(string); ok {
+		switch format {
+		case "textile", "markdown", "convertbreaks", "none":
+			post.Format = format
+		case "1":
+			post.Format = "convertbreaks"
+		case "0":
+			post.Format = "none"
+		}
+	}
+
+	// WordPress clients send a post_status that overrides the publish flag.
+	draft := !publish
+	switch content["post_status"] {
+	case "publish", "future":
+		draft = false
+	case "draft", "pending", "private":
+		draft = true
+	}
+	post.Status = publishStatus(draft, post.Published)
+}
+
+// rpcPostStruct returns the metaWeblog content struct for a post. Posts that
+// aren't visible yet link to a preview instead.
+func rpcPostStruct(config *lwb.BlogConfig, post *store.Post) map[string]interface{} {
+	link := post.CanonicalBlogUrl.String() + post.CanonicalPath
+	if now := time.Seconds(); !post.IsVisibleAt(now) {
+		if preview := PreviewUrl(config, post.Uuid, now+previewLifetime); preview != "" {
+			link = preview
+		}
+	}
+
+	return map[string]interface{}{
+		"postid":            post.Uuid,
+		"title":             post.Title,
+		"description":       post.Body,
+		"dateCreated":       post.Published,
+		"categories":        post.Categories,
+		"mt_keywords":       strings.Join(post.Tags, ", "),
+		"mt_basename":       post.Basename,
+		"mt_convert_breaks": post.Format,
+		"post_status":       rpcPostStatus(post),
+		"link":              link,
+		"permaLink":         link,
+	}
+}
+
+// rpcPostStatus returns the WordPress post_status of a post.
+func rpcPostStatus(post *store.Post) string {
+	switch {
+	case post.IsScheduled():
+		return "future"
+	case post.IsPublished():
+		return "publish"
+	}
+	return "draft"
+}
+
+func rpcStrings(values []interface{}) (result []string) {
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			result = append(result, s)
+		}
+	}
+
+	return
+}
+
+// XmlRpcHandler returns a request handler that implements the MetaWeblog and
+// Blogger XML-RPC APIs used by desktop editors such as MarsEdit.
+func XmlRpcHandler(context *RenderContext, db store.WritableStore) web.Handler {
+	return &xmlRpcHandler{context, db}
+}
@@ -20,11 +20,14 @@ import (
 	"flag"
 	"github.com/garyburd/twister/web"
 	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/markdown"
 	"github.com/stevela/lwb/store"
 	"github.com/stevela/lwb/textile"
 	"io/ioutil"
 	"path"
+	"sort"
 	"strings"
+	"sync"
 	"template"
 )
 
@@ -43,6 +46,39 @@ type RenderContext struct {
 	Path        string
 }
 
+// contextLock guards the parts of a RenderContext that are derived from the
+// store, as they are rebuilt whenever the store is reloaded.
+var contextLock sync.RWMutex
+
+// Refresh rebuilds the recent posts, tags, categories and archives from the store.
+func (context *RenderContext) Refresh() {
+	tags := context.Db.GetTags()
+	categories := context.Db.GetCategories()
+	sort.SortStrings(tags)
+	sort.SortStrings(categories)
+
+	recentPosts := context.Db.GetRecentPosts(context.Config.NumRecentPosts)
+	archives := context.Db.GetArchives()
+
+	contextLock.Lock()
+	defer contextLock.Unlock()
+
+	context.RecentPosts = recentPosts
+	context.Tags = tags
+	context.Categories = categories
+	context.Archives = archives
+}
+
+// snapshot returns a copy of the context that is safe to render with while
+// the store is being reloaded.
+func (context *RenderContext) snapshot() *RenderContext {
+	contextLock.RLock()
+	defer contextLock.RUnlock()
+
+	local_context := *context
+	return &local_context
+}
+
 type templateEntry struct {
 	Path      string
 	Timestamp int64
@@ -69,11 +105,13 @@ func ReloadTemplates(config *lwb.BlogConfig) {
 			path := path.Join(*flagTemplatePath, fileInfo.Name)
 			tmpl := template.New(
 				template.FormatterMap{
-					"textile":          textile.TextileFormatter,
-					"textileFullLinks": textile.GetTextileFullLinkFormatter(config.BlogUrl.String()),
-					"entities":         textile.EncodeEntitiesFormatter,
-					"spaces":           lwb.EncodeSpacesFormatter,
-					"convertbreaks":    lwb.ConvertBreaksFormatter,
+					"textile":           textile.TextileFormatter,
+					"textileFullLinks":  textile.GetTextileFullLinkFormatter(config.BlogUrl.String()),
+					"markdown":          markdown.MarkdownFormatter,
+					"markdownFullLinks": markdown.GetMarkdownFullLinkFormatter(config.BlogUrl.String()),
+					"entities":          textile.EncodeEntitiesFormatter,
+					"spaces":            lwb.EncodeSpacesFormatter,
+					"convertbreaks":     lwb.ConvertBreaksFormatter,
 				})
 			tmpl.SetDelims("{{", "}}")
 
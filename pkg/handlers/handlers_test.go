@@ -0,0 +1,220 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"flag"
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"http"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"xml"
+)
+
+// testResponder records the response to a request.
+type testResponder struct {
+	status int
+	header web.Header
+	body   bytes.Buffer
+}
+
+func (r *testResponder) Respond(status int, header web.Header) web.ResponseBody {
+	r.status = status
+	r.header = header
+	return r
+}
+
+func (r *testResponder) Write(p []byte) (int, os.Error) {
+	return r.body.Write(p)
+}
+
+func (r *testResponder) Flush() os.Error {
+	return nil
+}
+
+func (r *testResponder) Hijack() (net.Conn, []byte, os.Error) {
+	return nil, nil, os.NewError("Can't hijack a test request.")
+}
+
+// newTestRequest returns a GET request for rawUrl, with the parameters a route
+// would have added, whose response is recorded by the returned responder.
+func newTestRequest(t *testing.T, rawUrl string, param web.Values) (*web.Request, *testResponder) {
+	url, err := http.ParseURL(rawUrl)
+	if err != nil {
+		t.Fatalf("ParseURL(%s) failed: %s", rawUrl, err)
+	}
+	req, err := web.NewRequest("127.0.0.1:80", "GET", url, web.ProtocolVersion(1, 1), web.Header{})
+	if err != nil {
+		t.Fatalf("NewRequest(%s) failed: %s", rawUrl, err)
+	}
+	for key, values := range param {
+		req.Param[key] = values
+	}
+
+	resp := new(testResponder)
+	req.Responder = resp
+
+	return req, resp
+}
+
+// testStoreFiles are the contents of the store newTestContext sets up: two
+// posts, the newest with a title that needs escaping, a draft and a page.
+var testStoreFiles = map[string]string{
+	"first.post": `{"title": "First", "basename": "first", "type": "post", "status": "publish",
+		"format": "textile", "uuid": "first", "tags": ["go"], "categories": ["tech"],
+		"lastModifiedDate": "2011-05-03T10:00:00Z", "publishedDate": "2011-05-01T10:00:00Z"}`,
+	"first.body": `Hello, see "the next post":/2011/06/second.`,
+	"second.post": `{"title": "Fish & <Chips>", "basename": "second", "type": "post", "status": "publish",
+		"format": "html", "uuid": "second", "tags": ["go", "food"], "categories": ["misc"],
+		"lastModifiedDate": "2011-06-02T10:00:00Z", "publishedDate": "2011-06-01T10:00:00Z"}`,
+	"second.body": "<p>Salt &amp; vinegar.</p>",
+	"draft.post": `{"title": "Draft", "basename": "draft", "type": "post", "status": "draft",
+		"format": "textile", "uuid": "draft", "tags": ["go"], "categories": ["tech"],
+		"lastModifiedDate": "2011-07-02T10:00:00Z", "publishedDate": "2011-07-01T10:00:00Z"}`,
+	"draft.body": "Not yet.",
+	"about.page": `{"title": "About", "basename": "about", "type": "page", "status": "publish",
+		"format": "textile", "uuid": "about",
+		"lastModifiedDate": "2011-04-02T10:00:00Z", "publishedDate": "2011-04-01T10:00:00Z"}`,
+	"about.body": "About this blog.",
+}
+
+// newTestContext returns a render context for a json store holding
+// testStoreFiles, with the sample blog's templates loaded. The store's
+// directory is removed by the returned function.
+func newTestContext(t *testing.T) (*RenderContext, func()) {
+	dir, err := ioutil.TempDir("", "handlers_test")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %s", err)
+	}
+	for name, data := range testStoreFiles {
+		if err = ioutil.WriteFile(path.Join(dir, name), []byte(data), 0644); err != nil {
+			os.RemoveAll(dir)
+			t.Fatalf("WriteFile() failed: %s", err)
+		}
+	}
+
+	config := &lwb.BlogConfig{
+		BlogUrl:         &http.URL{Scheme: "http", Host: "example.com"},
+		Author:          "A. Blogger",
+		Title:           "Tests & Such",
+		Description:     "A blog for testing.",
+		NumRecentPosts:  10,
+		NumRssFeedPosts: 10,
+		RssUrl:          "/index.rss",
+		AtomUrl:         "/index.atom",
+		JsonFeedUrl:     "/feed.json",
+		SitemapUrl:      "/sitemap.xml",
+		Cache:           lwb.NewDummyCache(),
+	}
+
+	flag.Set("json_dir", dir)
+	db, err := store.NewJsonStore(config, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("NewJsonStore() failed: %s", err)
+	}
+
+	flag.Set("tmpl", "../../samples/blog_lwbd/tmpl")
+	ReloadTemplates(config)
+
+	context := &RenderContext{
+		Db:        db,
+		Config:    config,
+		Generator: "lwb",
+		Title:     config.Title,
+		Path:      config.BlogUrl.String(),
+	}
+	context.Refresh()
+
+	return context, func() { os.RemoveAll(dir) }
+}
+
+// xmlOutline parses an XML document into a line for each element, in document
+// order, naming it by its path from the root and giving its trimmed text, e.g.
+// "feed/entry/title Hello", followed by a line for each of its attributes, e.g.
+// "feed/link@rel self". As the text is unescaped, escaping mistakes show up as
+// parse errors or wrong text.
+func xmlOutline(t *testing.T, data []byte) []string {
+	p := xml.NewParser(bytes.NewBuffer(data))
+
+	var lines, names, texts []string
+	var open []int
+	for {
+		token, err := p.Token()
+		if err == os.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to parse '%s': %s", data, err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			names = append(names, token.Name.Local)
+			name := strings.Join(names, "/")
+			open = append(open, len(lines))
+			texts = append(texts, "")
+			lines = append(lines, name)
+			for _, a := range token.Attr {
+				lines = append(lines, name+"@"+a.Name.Local+" "+a.Value)
+			}
+		case xml.CharData:
+			if len(texts) > 0 {
+				texts[len(texts)-1] += string(token)
+			}
+		case xml.EndElement:
+			last := len(open) - 1
+			if text := strings.TrimSpace(texts[last]); text != "" {
+				lines[open[last]] += " " + text
+			}
+			names, texts, open = names[:last], texts[:last], open[:last]
+		}
+	}
+
+	return lines
+}
+
+// checkLines reports an error unless want appears within got, in order.
+func checkLines(t *testing.T, what string, got, want []string) {
+	i := 0
+	for _, line := range got {
+		if i < len(want) && line == want[i] {
+			i++
+		}
+	}
+	if i < len(want) {
+		t.Errorf("%s is missing '%s' in order, got:\n%s", what, want[i], strings.Join(got, "\n"))
+	}
+}
+
+// hasLinePrefix returns whether any of lines starts with prefix.
+func hasLinePrefix(lines []string, prefix string) bool {
+	for _, line := range lines {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
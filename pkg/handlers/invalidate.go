@@ -0,0 +1,85 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"fmt"
+	"github.com/stevela/lwb/store"
+	"strings"
+)
+
+// InvalidatePost discards the cached pages that show a post: the post itself,
+// the posts either side of it, whose navigation links to it, its date, tag and
+// category archives along with their feeds, the main index and the feeds. When
+// a post is edited both the old and the new versions should be invalidated, as
+// the post may have moved.
+func InvalidatePost(context *RenderContext, post *store.Post) {
+	config := context.Config
+	cache := config.Cache
+
+	post.CachedPost = nil
+	post.CachedPostWithFeedback = nil
+
+	cache.Invalidate(post.Path)
+	cache.Invalidate(config.SitemapUrl)
+	if post.IsPage() {
+		return
+	}
+
+	for _, path := range []string{post.PreviousPath, post.NextPath} {
+		if path != "" {
+			cache.Invalidate(path)
+		}
+	}
+
+	if post.Published != nil {
+		cache.Invalidate(fmt.Sprintf("/%d/", post.Published.Year))
+		cache.Invalidate(fmt.Sprintf("/%d/%02d/", post.Published.Year, post.Published.Month))
+	}
+
+	// The archive, its pages and its feeds are all below the archive's path.
+	for _, tag := range post.Tags {
+		cache.InvalidatePrefix("/tag/" + tag + "/")
+	}
+	for _, category := range post.Categories {
+		cache.InvalidatePrefix("/category/" + category + "/")
+	}
+
+	// The prefix of the main index's pages may match other pages too, which
+	// only costs rendering them again.
+	cache.Invalidate("/")
+	if i := strings.Index(config.MainIndexPageUrl, "%"); i > 0 {
+		cache.InvalidatePrefix(config.MainIndexPageUrl[:i])
+	}
+	cache.Invalidate(config.RssUrl)
+	cache.Invalidate(config.AtomUrl)
+	cache.Invalidate(config.JsonFeedUrl)
+}
+
+// postChanged refreshes the context and discards the cached pages affected by
+// creating, editing or deleting the post with the given uuid, as the store
+// doesn't notify its reload listeners of edits. old is the post as it was
+// before the change, or nil if it is new.
+func postChanged(context *RenderContext, db store.WritableStore, uuid string, old *store.Post) {
+	context.Refresh()
+	if old != nil {
+		InvalidatePost(context, old)
+	}
+	if post, found := db.GetPostByUuid(uuid); found {
+		InvalidatePost(context, post)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"testing"
+	"time"
+)
+
+// isCached returns whether cache holds the page at path, caching it if not.
+func isCached(t *testing.T, cache lwb.PageCache, path string) bool {
+	req, _ := newTestRequest(t, path, nil)
+	generated := false
+	cache.Run(req, func(w *lwb.Page) bool {
+		generated = true
+		return true
+	})
+
+	return !generated
+}
+
+func TestInvalidatePost(t *testing.T) {
+	config := &lwb.BlogConfig{
+		MainIndexPageUrl: "/index/%d",
+		SitemapUrl:       "/sitemap.xml",
+		RssUrl:           "/index.xml",
+		AtomUrl:          "/atom.xml",
+		JsonFeedUrl:      "/feed.json",
+		Cache:            lwb.NewCache(),
+	}
+	context := &RenderContext{Config: config}
+
+	post := &store.Post{Type: "post", Path: "/2011/05/hello", Published: time.SecondsToUTC(1304400826),
+		PreviousPath: "/2011/04/before", NextPath: "/2011/06/after",
+		Tags: []string{"go"}, Categories: []string{"tech"}}
+
+	evicted := []string{
+		"/2011/05/hello", "/2011/04/before", "/2011/06/after",
+		"/2011/", "/2011/05/", "/2011/05/?page=2",
+		"/tag/go/", "/tag/go/?page=2", "/tag/go/index.xml", "/tag/go/atom.xml",
+		"/category/tech/", "/category/tech/atom.xml",
+		"/", "/index/2", "/index.xml", "/atom.xml", "/feed.json", "/sitemap.xml",
+	}
+	kept := []string{
+		"/2011/04/other", "/2011/04/", "/2010/", "/2011/05/hello/more",
+		"/tag/golang/", "/tag/tech/", "/category/go/", "/page/about", "/search",
+	}
+
+	for _, path := range append(evicted, kept...) {
+		isCached(t, config.Cache, path)
+	}
+	InvalidatePost(context, post)
+
+	for _, path := range evicted {
+		if isCached(t, config.Cache, path) {
+			t.Errorf("%s is still cached", path)
+		}
+	}
+	for _, path := range kept {
+		if !isCached(t, config.Cache, path) {
+			t.Errorf("%s was evicted", path)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"fmt"
+	"json"
+	"testing"
+)
+
+func TestJsonFeed(t *testing.T) {
+	context, cleanup := newTestContext(t)
+	defer cleanup()
+
+	req, resp := newTestRequest(t, "http://example.com/feed.json", nil)
+	JsonFeedHandler(context).ServeWeb(req)
+	if resp.status != 200 || resp.header.Get("Content-Type") != jsonFeedContentType {
+		t.Fatalf("JsonFeedHandler() responded %d with %v", resp.status, resp.header)
+	}
+	if s := resp.header.Get("Last-Modified"); s != "Thu, 02 Jun 2011 10:00:00 GMT" {
+		t.Errorf("Last-Modified = '%s'", s)
+	}
+
+	var feed map[string]interface{}
+	if err := json.Unmarshal(resp.body.Bytes(), &feed); err != nil {
+		t.Fatalf("failed to decode '%s': %s", resp.body.Bytes(), err)
+	}
+
+	for key, want := range map[string]string{
+		"version":       jsonFeedVersion,
+		"title":         "Tests & Such",
+		"description":   "A blog for testing.",
+		"home_page_url": "http://example.com/",
+		"feed_url":      "http://example.com/feed.json",
+		"authors":       "[map[name:A. Blogger]]",
+	} {
+		if s := fmt.Sprint(feed[key]); s != want {
+			t.Errorf("feed %s = '%s' want '%s'", key, s, want)
+		}
+	}
+
+	// The newest post comes first, and the draft and the page are left out.
+	items, _ := feed["items"].([]interface{})
+	wantItems := []map[string]string{
+		{
+			"id":             "urn:uuid:second",
+			"url":            "http://example.com/2011/06/second",
+			"title":          "Fish & <Chips>",
+			"content_html":   "<p>Salt &amp; vinegar.</p>",
+			"date_published": "2011-06-01T10:00:00Z",
+			"date_modified":  "2011-06-02T10:00:00Z",
+			"tags":           "[go food]",
+		},
+		{
+			"id":             "urn:uuid:first",
+			"url":            "http://example.com/2011/05/first",
+			"title":          "First",
+			"content_html":   `<p>Hello, see <a href="http://example.com/2011/06/second">the next post</a>.</p>`,
+			"date_published": "2011-05-01T10:00:00Z",
+			"date_modified":  "2011-05-03T10:00:00Z",
+			"tags":           "[go]",
+		},
+	}
+	if len(items) != len(wantItems) {
+		t.Fatalf("feed has %d items want %d: %v", len(items), len(wantItems), items)
+	}
+	for i, want := range wantItems {
+		item, _ := items[i].(map[string]interface{})
+		for key, value := range want {
+			if s := fmt.Sprint(item[key]); s != value {
+				t.Errorf("item %d %s = '%s' want '%s'", i, key, s, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"http"
+	"json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStaticTokenVerifier(t *testing.T) {
+	verifier := StaticTokenVerifier{"secret": {"create", "update"}}
+
+	scopes, err := verifier.VerifyToken("secret")
+	if err != nil || !hasScope(scopes, "create") || hasScope(scopes, "delete") {
+		t.Errorf("VerifyToken(\"secret\") = %v, %v", scopes, err)
+	}
+
+	if _, err = verifier.VerifyToken("wrong"); err == nil {
+		t.Errorf("VerifyToken(\"wrong\") succeeded")
+	}
+}
+
+func TestMicropubFormProperties(t *testing.T) {
+	post := new(store.Post)
+	applyMicropubProperties(post, micropubFormProperties(map[string][]string{
+		"h":            {"entry"},
+		"access_token": {"secret"},
+		"name":         {"Hello"},
+		"content":      {"Some text"},
+		"category[]":   {"tech", "go"},
+		"published":    {"2011-05-02T22:33:46-07:00"},
+		"post-status":  {"draft"},
+		"mp-slug":      {"hello_there"},
+	}))
+
+	if post.Title != "Hello" || post.Body != "Some text" || post.Basename != "hello_there" {
+		t.Errorf("got title '%s' body '%s' basename '%s'", post.Title, post.Body, post.Basename)
+	}
+	if !reflect.DeepEqual(post.Categories, []string{"tech", "go"}) {
+		t.Errorf("got categories %v", post.Categories)
+	}
+	if post.Status != "draft" {
+		t.Errorf("got status '%s' want 'draft'", post.Status)
+	}
+	if post.Published == nil || post.Published.Year != 2011 || post.Published.ZoneOffset != -7*60*60 {
+		t.Errorf("got published %v", post.Published)
+	}
+}
+
+func TestMicropubJsonProperties(t *testing.T) {
+	post := &store.Post{Format: "textile"}
+	applyMicropubProperties(post, micropubJsonProperties(map[string]interface{}{
+		"name":        []interface{}{"Hello"},
+		"content":     []interface{}{map[string]interface{}{"html": "<p>Hi</p>"}},
+		"post-status": "published",
+	}))
+
+	if post.Title != "Hello" || post.Body != "<p>Hi</p>" || post.Format != "none" {
+		t.Errorf("got title '%s' body '%s' format '%s'", post.Title, post.Body, post.Format)
+	}
+	if post.Status != "publish" {
+		t.Errorf("got status '%s' want 'publish'", post.Status)
+	}
+}
+
+func TestMicropubStatusRoundTrip(t *testing.T) {
+	for _, status := range []string{"publish", "scheduled", "draft"} {
+		post := &store.Post{Title: "Hello", Status: status, Published: time.UTC()}
+		source := micropubSource(post)["properties"].(map[string]interface{})
+
+		edited := &store.Post{Status: "publish"}
+		applyMicropubProperties(edited, micropubJsonProperties(source))
+		if edited.Status != status {
+			t.Errorf("status '%s' came back as '%s'", status, edited.Status)
+		}
+	}
+
+	// Leaving out the status keeps the current one.
+	post := &store.Post{Status: "scheduled"}
+	applyMicropubProperties(post, micropubFormProperties(map[string][]string{"name": {"Hello"}}))
+	if post.Status != "scheduled" {
+		t.Errorf("got status '%s' want 'scheduled'", post.Status)
+	}
+}
+
+func TestMicropubConfig(t *testing.T) {
+	config := &lwb.BlogConfig{BlogUrl: &http.URL{Scheme: "http", Host: "example.com"},
+		MediaPath: "static/media", MediaUrl: "/media"}
+	mh := MicropubHandler(&RenderContext{Config: config}, nil, StaticTokenVerifier{"secret": {"create"}})
+
+	for _, ct := range []struct {
+		mediaPath, endpoint string
+	}{
+		{"static/media", "http://example.com/media"},
+		{"", ""},
+	} {
+		config.MediaPath = ct.mediaPath
+
+		req, resp := newTestRequest(t, "http://example.com/micropub?q=config", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		mh.ServeWeb(req)
+		if resp.status != 200 {
+			t.Fatalf("q=config responded %d: %s", resp.status, resp.body.Bytes())
+		}
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(resp.body.Bytes(), &body); err != nil {
+			t.Fatalf("failed to decode '%s': %s", resp.body.Bytes(), err)
+		}
+		if endpoint, _ := body["media-endpoint"].(string); endpoint != ct.endpoint {
+			t.Errorf("media path '%s' gave media endpoint '%s' want '%s'", ct.mediaPath, endpoint, ct.endpoint)
+		}
+		if targets, ok := body["syndicate-to"].([]interface{}); !ok || len(targets) != 0 {
+			t.Errorf("media path '%s' gave syndication targets %v", ct.mediaPath, body["syndicate-to"])
+		}
+	}
+}
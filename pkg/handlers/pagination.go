@@ -0,0 +1,101 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/store"
+	"os"
+	"strconv"
+)
+
+// Pagination describes where a page of posts sits within an index or archive,
+// and is passed to templates as "pagination".
+type Pagination struct {
+	Page     int
+	NumPages int
+
+	// Links to the newer and older pages. Empty if there is no such page.
+	PreviousUrl string
+	NextUrl     string
+}
+
+// pageUrlFunc returns the url of the given page of an index or archive.
+type pageUrlFunc func(page int) string
+
+// queryPageUrl returns a pageUrlFunc that selects pages of path using the
+// "page" query parameter.
+func queryPageUrl(path string) pageUrlFunc {
+	return func(page int) string {
+		if page == 1 {
+			return path
+		}
+		return path + "?page=" + strconv.Itoa(page)
+	}
+}
+
+// paginate returns the posts on the page selected by the request's "page"
+// parameter, which may come from the path or the query. It fails if the page
+// number is invalid or out of range. If perPage isn't positive every post is
+// returned on a single page.
+func paginate(req *web.Request, posts []*store.Post, perPage int, fnUrl pageUrlFunc) (pagePosts []*store.Post, pagination *Pagination, ok bool) {
+	start, end, pagination, ok := pageBounds(req, len(posts), perPage, fnUrl)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return posts[start:end], pagination, true
+}
+
+// pageBounds is like paginate, but for numPosts posts that haven't been read
+// yet, returning where the selected page starts and ends instead.
+func pageBounds(req *web.Request, numPosts int, perPage int, fnUrl pageUrlFunc) (start, end int, pagination *Pagination, ok bool) {
+	page := 1
+	if s := req.Param.Get("page"); s != "" {
+		var err os.Error
+		if page, err = strconv.Atoi(s); err != nil || page < 1 {
+			return 0, 0, nil, false
+		}
+	}
+
+	if perPage <= 0 {
+		return 0, numPosts, nil, page == 1
+	}
+
+	numPages := (numPosts + perPage - 1) / perPage
+	if numPages == 0 {
+		numPages = 1
+	}
+	if page > numPages {
+		return 0, 0, nil, false
+	}
+
+	pagination = &Pagination{Page: page, NumPages: numPages}
+	if page > 1 {
+		pagination.PreviousUrl = fnUrl(page - 1)
+	}
+	if page < numPages {
+		pagination.NextUrl = fnUrl(page + 1)
+	}
+
+	end = page * perPage
+	if end > numPosts {
+		end = numPosts
+	}
+
+	return (page - 1) * perPage, end, pagination, true
+}
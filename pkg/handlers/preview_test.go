@@ -0,0 +1,43 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"github.com/stevela/lwb/lwb"
+	"testing"
+)
+
+func TestPreviewSignature(t *testing.T) {
+	config := &lwb.BlogConfig{PreviewSecret: "secret"}
+	sig := previewSignature(config, "uuid", 1000)
+
+	if !checkPreviewSignature(config, "uuid", 1000, sig) {
+		t.Errorf("valid signature rejected")
+	}
+	if checkPreviewSignature(config, "uuid", 2000, sig) {
+		t.Errorf("signature accepted with a different expiry")
+	}
+	if checkPreviewSignature(config, "other", 1000, sig) {
+		t.Errorf("signature accepted for a different post")
+	}
+	if checkPreviewSignature(&lwb.BlogConfig{PreviewSecret: "other"}, "uuid", 1000, sig) {
+		t.Errorf("signature accepted with a different secret")
+	}
+	if checkPreviewSignature(&lwb.BlogConfig{}, "uuid", 1000, previewSignature(&lwb.BlogConfig{}, "uuid", 1000)) {
+		t.Errorf("signature accepted with previews disabled")
+	}
+}
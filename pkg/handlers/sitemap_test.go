@@ -0,0 +1,119 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+// The urls in the fixture store's sitemap, in order, each followed by when it
+// was last modified.
+var sitemapUrls = []string{
+	"/", "2011-06-02T10:00:00Z",
+	"/page/about", "2011-04-02T10:00:00Z",
+	"/2011/06/second", "2011-06-02T10:00:00Z",
+	"/2011/05/first", "2011-05-03T10:00:00Z",
+	"/2011/", "2011-06-02T10:00:00Z",
+	"/2011/06/", "2011-06-02T10:00:00Z",
+	"/2011/05/", "2011-05-03T10:00:00Z",
+	"/tag/food/", "2011-06-02T10:00:00Z",
+	"/tag/go/", "2011-06-02T10:00:00Z",
+	"/category/misc/", "2011-06-02T10:00:00Z",
+	"/category/tech/", "2011-05-03T10:00:00Z",
+}
+
+// sitemapLines returns the outline of a sitemap holding urls, as listed in
+// sitemapUrls.
+func sitemapLines(urls []string) []string {
+	lines := []string{"urlset", "urlset@xmlns " + sitemapNamespace}
+	for i := 0; i < len(urls); i += 2 {
+		lines = append(lines, "urlset/url", "urlset/url/loc http://example.com"+urls[i],
+			"urlset/url/lastmod "+urls[i+1])
+	}
+
+	return lines
+}
+
+func checkOutline(t *testing.T, what string, got, want []string) {
+	if len(got) != len(want) {
+		t.Errorf("%s has %d lines want %d, got:\n%s", what, len(got), len(want), strings.Join(got, "\n"))
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s line %d = '%s' want '%s'", what, i, got[i], want[i])
+		}
+	}
+}
+
+func TestSitemap(t *testing.T) {
+	context, cleanup := newTestContext(t)
+	defer cleanup()
+
+	req, resp := newTestRequest(t, "http://example.com/sitemap.xml", nil)
+	SitemapHandler(context).ServeWeb(req)
+	if resp.status != 200 || resp.header.Get("Content-Type") != sitemapContentType {
+		t.Fatalf("SitemapHandler() responded %d with %v", resp.status, resp.header)
+	}
+	if s := resp.header.Get("Last-Modified"); s != "Thu, 02 Jun 2011 10:00:00 GMT" {
+		t.Errorf("Last-Modified = '%s'", s)
+	}
+	checkOutline(t, "the sitemap", xmlOutline(t, resp.body.Bytes()), sitemapLines(sitemapUrls))
+
+	// Split into parts of three urls, the sitemap becomes an index of them.
+	defer func(max int) { maxSitemapUrls = max }(maxSitemapUrls)
+	maxSitemapUrls = 3
+
+	req, resp = newTestRequest(t, "http://example.com/sitemap.xml", nil)
+	SitemapHandler(context).ServeWeb(req)
+	checkOutline(t, "the sitemap index", xmlOutline(t, resp.body.Bytes()), []string{
+		"sitemapindex",
+		"sitemapindex@xmlns " + sitemapNamespace,
+		"sitemapindex/sitemap",
+		"sitemapindex/sitemap/loc http://example.com/sitemap.xml?page=1",
+		"sitemapindex/sitemap/lastmod 2011-06-02T10:00:00Z",
+		"sitemapindex/sitemap",
+		"sitemapindex/sitemap/loc http://example.com/sitemap.xml?page=2",
+		"sitemapindex/sitemap/lastmod 2011-06-02T10:00:00Z",
+		"sitemapindex/sitemap",
+		"sitemapindex/sitemap/loc http://example.com/sitemap.xml?page=3",
+		"sitemapindex/sitemap/lastmod 2011-06-02T10:00:00Z",
+		"sitemapindex/sitemap",
+		"sitemapindex/sitemap/loc http://example.com/sitemap.xml?page=4",
+		"sitemapindex/sitemap/lastmod 2011-06-02T10:00:00Z",
+	})
+
+	// Each part holds the next three urls, and the last what's left.
+	for page, want := range map[string][]string{
+		"1": sitemapUrls[0:6],
+		"2": sitemapUrls[6:12],
+		"4": sitemapUrls[18:],
+	} {
+		req, resp = newTestRequest(t, "http://example.com/sitemap.xml?page="+page, nil)
+		SitemapHandler(context).ServeWeb(req)
+		checkOutline(t, "sitemap part "+page, xmlOutline(t, resp.body.Bytes()), sitemapLines(want))
+	}
+
+	for _, page := range []string{"0", "5", "x"} {
+		req, resp = newTestRequest(t, "http://example.com/sitemap.xml?page="+page, nil)
+		SitemapHandler(context).ServeWeb(req)
+		if resp.status != 404 {
+			t.Errorf("sitemap part '%s' responded %d", page, resp.status)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/store"
+	"testing"
+)
+
+func TestTagFeeds(t *testing.T) {
+	context, cleanup := newTestContext(t)
+	defer cleanup()
+
+	db := context.Db
+	tagLookup := func(key string) ([]*store.Post, bool) { return db.GetPostsByTag(key) }
+	categoryLookup := func(key string) ([]*store.Post, bool) { return db.GetPostsByCategory(key) }
+
+	// A tag's feed holds its published posts, newest first, and links to the
+	// tag's archive.
+	req, resp := newTestRequest(t, "http://example.com/tag/go/index.xml", web.Values{"tag": {"go"}})
+	TagRssFeedHandler(context, tagLookup).ServeWeb(req)
+	if resp.status != 200 || resp.header.Get("Content-Type") != rssContentType {
+		t.Fatalf("TagRssFeedHandler() responded %d with %v", resp.status, resp.header)
+	}
+	lines := xmlOutline(t, resp.body.Bytes())
+	checkLines(t, "the tag's RSS feed", lines, []string{
+		"rss@version 2.0",
+		"rss/channel/title Tests & Such - go",
+		"rss/channel/link http://example.com/tag/go/",
+		"rss/channel/link@rel self",
+		"rss/channel/link@href http://example.com/tag/go/index.xml",
+		"rss/channel/lastBuildDate Wed, 01 Jun 2011 10:00:00 UTC",
+		"rss/channel/item/title Fish & <Chips>",
+		"rss/channel/item/description <p>Salt &amp; vinegar.</p>",
+		"rss/channel/item/link http://example.com/2011/06/second",
+		"rss/channel/item/pubDate Wed, 01 Jun 2011 10:00:00 UTC",
+		"rss/channel/item/title First",
+		"rss/channel/item/link http://example.com/2011/05/first",
+	})
+	if hasLinePrefix(lines, "rss/channel/item/title Draft") {
+		t.Errorf("the tag's RSS feed includes the draft")
+	}
+
+	// A category's feed holds only the posts in the category.
+	req, resp = newTestRequest(t, "http://example.com/category/misc/atom.xml", web.Values{"tag": {"misc"}})
+	TagAtomFeedHandler(context, categoryLookup).ServeWeb(req)
+	if resp.status != 200 || resp.header.Get("Content-Type") != atomFeedContentType {
+		t.Fatalf("TagAtomFeedHandler() responded %d with %v", resp.status, resp.header)
+	}
+	if s := resp.header.Get("Last-Modified"); s != "Thu, 02 Jun 2011 10:00:00 GMT" {
+		t.Errorf("Last-Modified = '%s'", s)
+	}
+	lines = xmlOutline(t, resp.body.Bytes())
+	checkLines(t, "the category's Atom feed", lines, []string{
+		"feed/title Tests & Such - misc",
+		"feed/link@href http://example.com/category/misc/",
+		"feed/link@href http://example.com/category/misc/atom.xml",
+		"feed/id http://example.com/category/misc/atom.xml",
+		"feed/updated 2011-06-02T10:00:00Z",
+		"feed/entry/id urn:uuid:second",
+		"feed/entry/title Fish & <Chips>",
+	})
+	if hasLinePrefix(lines, "feed/entry/id urn:uuid:first") {
+		t.Errorf("the category's Atom feed includes a post from another category")
+	}
+
+	// Tags nobody has used have no feed.
+	req, resp = newTestRequest(t, "http://example.com/tag/none/index.xml", web.Values{"tag": {"none"}})
+	TagRssFeedHandler(context, tagLookup).ServeWeb(req)
+	if resp.status != 404 {
+		t.Errorf("TagRssFeedHandler() of an unused tag responded %d", resp.status)
+	}
+}
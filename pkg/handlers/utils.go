@@ -17,11 +17,25 @@ limitations under the License.
 package handlers
 
 import (
-	"github.com/stevela/lwb/store"
 	"bytes"
+	"encoding/base64"
+	"github.com/garyburd/twister/web"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/markdown"
+	"github.com/stevela/lwb/store"
+	"github.com/stevela/lwb/textile"
 	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// The largest request body accepted by the editing handlers, which needs to
+// allow for media uploads.
+const maxUploadLen = 16 * 1024 * 1024
+
 // RenderPost renders a single post.
 func renderPost(w io.Writer, context *RenderContext, post *store.Post, withFeedback bool) {
 	if context.UseCache {
@@ -62,6 +76,33 @@ func renderPost(w io.Writer, context *RenderContext, post *store.Post, withFeedb
 	w.Write(b)
 }
 
+// lastModified returns the most recent modification time of posts, or nil if
+// there are none.
+func lastModified(posts []*store.Post) (t *time.Time) {
+	for _, post := range posts {
+		if t == nil || post.LastModified.Seconds() > t.Seconds() {
+			t = post.LastModified
+		}
+	}
+
+	return
+}
+
+// formatBody renders the body of a post as HTML according to its format. Any
+// relative links are made absolute, so the result can be used outside of the
+// blog, e.g. in a feed.
+func formatBody(w io.Writer, context *RenderContext, post *store.Post) {
+	switch {
+	case post.IsFormatTextile():
+		textile.GetTextileFullLinkFormatter(context.Config.BlogUrl.String())(w, "", post.Body)
+	case post.IsFormatMarkdown():
+		markdown.GetMarkdownFullLinkFormatter(context.Config.BlogUrl.String())(w, "", post.Body)
+	case post.IsFormatConvertBreaks():
+		lwb.ConvertBreaksFormatter(w, "", post.Body)
+	default:
+		io.WriteString(w, post.Body)
+	}
+}
 
 func makeTemplateParams(context *RenderContext, content interface{}) map[string]interface{} {
 	return map[string]interface{}{
@@ -69,3 +110,83 @@ func makeTemplateParams(context *RenderContext, content interface{}) map[string]
 		"content": content,
 	}
 }
+
+// publishStatus returns the status of an edited post. Posts that aren't
+// drafts are scheduled if they're due in the future.
+func publishStatus(draft bool, published *time.Time) string {
+	switch {
+	case draft:
+		return "draft"
+	case published != nil && published.Seconds() > time.Seconds():
+		return "scheduled"
+	}
+
+	return "publish"
+}
+
+// saveMedia writes an uploaded file to the media directory and returns the name
+// it was saved under and the url it will be served from. Existing files are
+// never replaced: if the name is taken, a number is added to it, e.g.
+// "photo-2.jpg".
+func saveMedia(config *lwb.BlogConfig, name string, data []byte) (saved, url string, err os.Error) {
+	if config.MediaPath == "" {
+		return "", "", os.NewError("Media uploads are disabled.")
+	}
+
+	name = path.Clean(strings.TrimLeft(name, "/"))
+	if name == "." || strings.HasPrefix(name, "..") {
+		return "", "", os.NewError("Invalid media name: " + name)
+	}
+
+	if err = os.MkdirAll(path.Dir(path.Join(config.MediaPath, name)), 0755); err != nil {
+		return
+	}
+
+	ext := path.Ext(name)
+	saved = name
+	for n := 2; ; n += 1 {
+		var f *os.File
+		f, err = os.OpenFile(path.Join(config.MediaPath, saved), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if e, ok := err.(*os.PathError); ok && e.Error == os.EEXIST {
+			saved = name[:len(name)-len(ext)] + "-" + strconv.Itoa(n) + ext
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path.Join(config.MediaPath, saved))
+			return "", "", err
+		}
+
+		break
+	}
+
+	return saved, config.BlogUrl.String() + config.MediaUrl + "/" + saved, nil
+}
+
+// authorized checks the request's basic authentication credentials against the
+// editing credentials in the config, responding with a challenge if they are
+// missing or wrong. Editing is disabled if there is no password.
+func authorized(req *web.Request, config *lwb.BlogConfig) bool {
+	if config.RpcPassword != "" {
+		auth := req.Header.Get(web.HeaderAuthorization)
+		if strings.HasPrefix(auth, "Basic ") {
+			credentials, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[6:]))
+			if err == nil && string(credentials) == config.RpcUsername+":"+config.RpcPassword {
+				return true
+			}
+		}
+	}
+
+	req.Respond(web.StatusUnauthorized,
+		web.HeaderWWWAuthenticate, "Basic realm=\""+config.Title+"\"",
+		web.HeaderContentType, "text/plain").Write([]byte("Unauthorized."))
+
+	return false
+}
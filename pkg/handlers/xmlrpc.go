@@ -0,0 +1,383 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"xml"
+)
+
+// A minimal XML-RPC (http://www.xmlrpc.com/spec) codec. Values are decoded
+// into string, int, bool, float64, *time.Time, []byte, map[string]interface{}
+// and []interface{}.
+
+const rpcTimeFormat = "20060102T15:04:05"
+
+var rpcTimeFormats = []string{
+	rpcTimeFormat,
+	"20060102T15:04:05Z",
+	"20060102T15:04:05Z07:00",
+	"2006-01-02T15:04:05Z07:00",
+}
+
+// rpcFault is an XML-RPC fault response.
+type rpcFault struct {
+	Code    int
+	Message string
+}
+
+func (f *rpcFault) String() string {
+	return fmt.Sprintf("%d: %s", f.Code, f.Message)
+}
+
+type rpcDecoder struct {
+	p *xml.Parser
+}
+
+// decodeRpcCall parses an XML-RPC methodCall.
+func decodeRpcCall(r io.Reader) (method string, params []interface{}, err os.Error) {
+	d := &rpcDecoder{xml.NewParser(r)}
+
+	if err = d.expectStart("methodCall"); err != nil {
+		return
+	}
+	if err = d.expectStart("methodName"); err != nil {
+		return
+	}
+	if method, err = d.text(); err != nil {
+		return
+	}
+
+	for {
+		var t xml.Token
+		if t, err = d.next(); err != nil {
+			return
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "value" {
+				var v interface{}
+				if v, err = d.value(); err != nil {
+					return
+				}
+				params = append(params, v)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "methodCall" {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// next returns the next token, skipping whitespace, comments and processing
+// instructions.
+func (d *rpcDecoder) next() (xml.Token, os.Error) {
+	for {
+		t, err := d.p.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := t.(type) {
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) != 0 {
+				return xml.CopyToken(t), nil
+			}
+		case xml.StartElement, xml.EndElement:
+			return t, nil
+		}
+	}
+
+	panic("unreachable")
+}
+
+func (d *rpcDecoder) expectStart(name string) os.Error {
+	t, err := d.next()
+	if err != nil {
+		return err
+	}
+
+	if start, ok := t.(xml.StartElement); !ok || start.Name.Local != name {
+		return os.NewError("Expected <" + name + ">")
+	}
+
+	return nil
+}
+
+// text returns the character data up to the end of the current element.
+func (d *rpcDecoder) text() (string, os.Error) {
+	var buf bytes.Buffer
+	for {
+		t, err := d.p.Token()
+		if err != nil {
+			return "", err
+		}
+
+		switch t := t.(type) {
+		case xml.CharData:
+			buf.Write(t)
+		case xml.StartElement:
+			return "", os.NewError("Unexpected <" + t.Name.Local + ">")
+		case xml.EndElement:
+			return buf.String(), nil
+		}
+	}
+
+	panic("unreachable")
+}
+
+// value decodes the contents of a <value> element, whose start tag has
+// already been read.
+func (d *rpcDecoder) value() (v interface{}, err os.Error) {
+	var buf bytes.Buffer
+	for {
+		var t xml.Token
+		if t, err = d.p.Token(); err != nil {
+			return
+		}
+
+		switch t := t.(type) {
+		case xml.CharData:
+			buf.Write(t)
+		case xml.EndElement:
+			// An untyped value is a string.
+			return buf.String(), nil
+		case xml.StartElement:
+			if v, err = d.typedValue(t.Name.Local); err != nil {
+				return
+			}
+
+			// Consume </value>.
+			for {
+				var end xml.Token
+				if end, err = d.next(); err != nil {
+					return
+				}
+				if _, ok := end.(xml.EndElement); ok {
+					return
+				}
+			}
+		}
+	}
+
+	panic("unreachable")
+}
+
+func (d *rpcDecoder) typedValue(kind string) (v interface{}, err os.Error) {
+	switch kind {
+	case "struct":
+		return d.structValue()
+	case "array":
+		return d.arrayValue()
+	case "nil":
+		_, err = d.text()
+		return nil, err
+	}
+
+	s, err := d.text()
+	if err != nil {
+		return
+	}
+
+	switch kind {
+	case "string":
+		v = s
+	case "int", "i4":
+		v, err = strconv.Atoi(strings.TrimSpace(s))
+	case "boolean":
+		v = strings.TrimSpace(s) == "1"
+	case "double":
+		v, err = strconv.Atof64(strings.TrimSpace(s))
+	case "dateTime.iso8601":
+		for _, format := range rpcTimeFormats {
+			if v, err = time.Parse(format, strings.TrimSpace(s)); err == nil {
+				break
+			}
+		}
+	case "base64":
+		v, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+	default:
+		err = os.NewError("Unknown value type: " + kind)
+	}
+
+	return
+}
+
+func (d *rpcDecoder) structValue() (v interface{}, err os.Error) {
+	members := make(map[string]interface{})
+	for {
+		var t xml.Token
+		if t, err = d.next(); err != nil {
+			return
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "member" {
+				return nil, os.NewError("Expected <member>")
+			}
+
+			var name string
+			var value interface{}
+			if err = d.expectStart("name"); err != nil {
+				return
+			}
+			if name, err = d.text(); err != nil {
+				return
+			}
+			if err = d.expectStart("value"); err != nil {
+				return
+			}
+			if value, err = d.value(); err != nil {
+				return
+			}
+			members[name] = value
+
+			// Consume </member>.
+			if _, err = d.next(); err != nil {
+				return
+			}
+		case xml.EndElement:
+			return members, nil
+		}
+	}
+
+	panic("unreachable")
+}
+
+func (d *rpcDecoder) arrayValue() (v interface{}, err os.Error) {
+	var values []interface{}
+	if err = d.expectStart("data"); err != nil {
+		return
+	}
+
+	for {
+		var t xml.Token
+		if t, err = d.next(); err != nil {
+			return
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "value" {
+				return nil, os.NewError("Expected <value>")
+			}
+
+			var value interface{}
+			if value, err = d.value(); err != nil {
+				return
+			}
+			values = append(values, value)
+		case xml.EndElement:
+			// Consume </array>.
+			if _, err = d.next(); err != nil {
+				return
+			}
+			return values, nil
+		}
+	}
+
+	panic("unreachable")
+}
+
+// encodeRpcResponse writes an XML-RPC methodResponse holding either result or,
+// if fault is not nil, a fault.
+func encodeRpcResponse(w io.Writer, result interface{}, fault *rpcFault) {
+	io.WriteString(w, "<?xml version=\"1.0\"?>\n<methodResponse>")
+	if fault != nil {
+		io.WriteString(w, "<fault><value>")
+		encodeRpcValue(w, map[string]interface{}{
+			"faultCode":   fault.Code,
+			"faultString": fault.Message,
+		})
+		io.WriteString(w, "</value></fault>")
+	} else {
+		io.WriteString(w, "<params><param><value>")
+		encodeRpcValue(w, result)
+		io.WriteString(w, "</value></param></params>")
+	}
+	io.WriteString(w, "</methodResponse>\n")
+}
+
+func encodeRpcValue(w io.Writer, v interface{}) {
+	switch v := v.(type) {
+	case string:
+		io.WriteString(w, "<string>")
+		xml.Escape(w, []byte(v))
+		io.WriteString(w, "</string>")
+	case int:
+		fmt.Fprintf(w, "<int>%d</int>", v)
+	case bool:
+		if v {
+			io.WriteString(w, "<boolean>1</boolean>")
+		} else {
+			io.WriteString(w, "<boolean>0</boolean>")
+		}
+	case float64:
+		fmt.Fprintf(w, "<double>%v</double>", v)
+	case *time.Time:
+		fmt.Fprintf(w, "<dateTime.iso8601>%s</dateTime.iso8601>", v.Format(rpcTimeFormat))
+	case []byte:
+		fmt.Fprintf(w, "<base64>%s</base64>", base64.StdEncoding.EncodeToString(v))
+	case []string:
+		io.WriteString(w, "<array><data>")
+		for _, s := range v {
+			io.WriteString(w, "<value>")
+			encodeRpcValue(w, s)
+			io.WriteString(w, "</value>")
+		}
+		io.WriteString(w, "</data></array>")
+	case []interface{}:
+		io.WriteString(w, "<array><data>")
+		for _, value := range v {
+			io.WriteString(w, "<value>")
+			encodeRpcValue(w, value)
+			io.WriteString(w, "</value>")
+		}
+		io.WriteString(w, "</data></array>")
+	case map[string]interface{}:
+		var names []string
+		for name, _ := range v {
+			names = append(names, name)
+		}
+		sort.SortStrings(names)
+
+		io.WriteString(w, "<struct>")
+		for _, name := range names {
+			io.WriteString(w, "<member><name>")
+			xml.Escape(w, []byte(name))
+			io.WriteString(w, "</name><value>")
+			encodeRpcValue(w, v[name])
+			io.WriteString(w, "</value></member>")
+		}
+		io.WriteString(w, "</struct>")
+	default:
+		io.WriteString(w, "<nil/>")
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var rpcCallTests = []struct {
+	in     string
+	method string
+	params []interface{}
+}{
+	{"<?xml version=\"1.0\"?><methodCall><methodName>blogger.getUsersBlogs</methodName>" +
+		"<params><param><value><string>key</string></value></param>" +
+		"<param><value>admin</value></param></params></methodCall>",
+		"blogger.getUsersBlogs", []interface{}{"key", "admin"}},
+	{"<methodCall>\n  <methodName>metaWeblog.getRecentPosts</methodName>\n  <params>\n" +
+		"    <param><value><i4>10</i4></value></param>\n" +
+		"    <param><value><boolean>1</boolean></value></param>\n" +
+		"  </params>\n</methodCall>",
+		"metaWeblog.getRecentPosts", []interface{}{10, true}},
+	{"<methodCall><methodName>metaWeblog.newPost</methodName><params>" +
+		"<param><value><struct>" +
+		"<member><name>title</name><value><string>Hello &amp; goodbye</string></value></member>" +
+		"<member><name>categories</name><value><array><data>" +
+		"<value><string>tech</string></value><value>misc</value>" +
+		"</data></array></value></member>" +
+		"</struct></value></param>" +
+		"<param><value><base64>aGVsbG8=</base64></value></param>" +
+		"</params></methodCall>",
+		"metaWeblog.newPost", []interface{}{
+			map[string]interface{}{
+				"title":      "Hello & goodbye",
+				"categories": []interface{}{"tech", "misc"},
+			},
+			[]byte("hello"),
+		}},
+}
+
+func TestDecodeRpcCall(t *testing.T) {
+	for _, rt := range rpcCallTests {
+		method, params, err := decodeRpcCall(strings.NewReader(rt.in))
+		if err != nil {
+			t.Errorf("decodeRpcCall(%q) failed: %s", rt.in, err)
+			continue
+		}
+		if method != rt.method {
+			t.Errorf("decodeRpcCall(%q) method = '%s' want '%s'", rt.in, method, rt.method)
+		}
+		if !reflect.DeepEqual(params, rt.params) {
+			t.Errorf("decodeRpcCall(%q) params = %v want %v", rt.in, params, rt.params)
+		}
+	}
+}
+
+var rpcResponseTests = []struct {
+	result interface{}
+	fault  *rpcFault
+	out    string
+}{
+	{"a < b", nil, "<params><param><value><string>a &lt; b</string></value></param></params>"},
+	{true, nil, "<params><param><value><boolean>1</boolean></value></param></params>"},
+	{[]string{"a", "b"}, nil, "<params><param><value><array><data>" +
+		"<value><string>a</string></value><value><string>b</string></value>" +
+		"</data></array></value></param></params>"},
+	{nil, &rpcFault{403, "No"}, "<fault><value><struct>" +
+		"<member><name>faultCode</name><value><int>403</int></value></member>" +
+		"<member><name>faultString</name><value><string>No</string></value></member>" +
+		"</struct></value></fault>"},
+}
+
+func TestEncodeRpcResponse(t *testing.T) {
+	for _, rt := range rpcResponseTests {
+		var buf bytes.Buffer
+		encodeRpcResponse(&buf, rt.result, rt.fault)
+		want := "<?xml version=\"1.0\"?>\n<methodResponse>" + rt.out + "</methodResponse>\n"
+		if bs := buf.String(); bs != want {
+			t.Errorf("encodeRpcResponse(%v) = '%s' want '%s'", rt.result, bs, want)
+		}
+	}
+}
+
+func TestRpcStatusRoundTrip(t *testing.T) {
+	blogUrl := &http.URL{Scheme: "http", Host: "example.com"}
+	config := &lwb.BlogConfig{BlogUrl: blogUrl}
+
+	now := time.Seconds()
+	for _, st := range []struct {
+		status    string
+		published int64
+	}{
+		{"publish", now - 3600},
+		{"scheduled", now + 3600},
+		{"draft", now - 3600},
+		{"draft", now + 3600},
+	} {
+		published := time.SecondsToUTC(st.published)
+		post := &store.Post{Uuid: "hello", Title: "Hello", Body: "Hi", Status: st.status,
+			Published: published, LastModified: published, CanonicalBlogUrl: blogUrl}
+
+		// Send the post back with the publish flag set, as MarsEdit does.
+		var buf bytes.Buffer
+		buf.WriteString("<methodCall><methodName>metaWeblog.editPost</methodName><params>")
+		for _, param := range []interface{}{post.Uuid, "admin", "secret", rpcPostStruct(config, post), true} {
+			buf.WriteString("<param><value>")
+			encodeRpcValue(&buf, param)
+			buf.WriteString("</value></param>")
+		}
+		buf.WriteString("</params></methodCall>")
+
+		_, params, err := decodeRpcCall(&buf)
+		if err != nil {
+			t.Fatalf("decodeRpcCall() failed: %s", err)
+		}
+		content, publish, fault := rpcContent(params)
+		if fault != nil {
+			t.Fatalf("rpcContent() failed: %s", fault)
+		}
+
+		edited := post.Copy()
+		applyRpcContent(edited, content, publish)
+		if edited.Status != st.status {
+			t.Errorf("status '%s' came back as '%s'", st.status, edited.Status)
+		}
+		if edited.Published == nil || edited.Published.Seconds() != st.published {
+			t.Errorf("%s: published %v came back as %v", st.status, published, edited.Published)
+		}
+	}
+}
@@ -0,0 +1,493 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kv implements a simple embedded key/value database held in a single
+// file.
+//
+// The file is a log of batches of puts and deletes, which is only ever
+// appended to. The keys are kept in memory in sorted order, along with where
+// their values are in the file, so a lookup takes at most one read and keys
+// can be scanned in order, but values are only read when they're needed.
+// Batches are applied atomically: if the last batch in the file is incomplete,
+// e.g. after a crash, it is discarded when the file is opened.
+//
+// Only one process can have a database open at a time, as the keys in memory
+// would go out of date if another wrote to it. Open takes an exclusive lock on
+// the file, and fails with ErrLocked while another process holds it.
+package kv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ErrLocked is returned by Open when another process has the database open.
+var ErrLocked = os.NewError("Database is in use by another process")
+
+// The first bytes of a database file.
+const magic = "lwbkv1\n"
+
+// The layout of a record is:
+//
+//	crc32 of the rest of the record (4 bytes)
+//	flags (1 byte)
+//	key length (4 bytes)
+//	value length (4 bytes)
+//	key
+//	value
+//
+// All integers are little endian.
+const headerLen = 13
+
+const (
+	// The record deletes its key.
+	flagDelete = 1 << iota
+
+	// The record is the last one in its batch.
+	flagCommit
+)
+
+// location is where a value is held in the file.
+type location struct {
+	offset int64
+	length int
+}
+
+// DB is an open database. It is safe for concurrent use.
+type DB struct {
+	lock sync.RWMutex
+	name string
+	file *os.File
+
+	// The end of the last complete batch.
+	size int64
+
+	// The keys in sorted order, and where their values are.
+	keys   []string
+	values map[string]location
+
+	// The number of bytes taken by records that have been overwritten or
+	// deleted, which compaction would reclaim.
+	garbage int64
+}
+
+// Batch is a sequence of puts and deletes that are written atomically.
+type Batch struct {
+	ops []op
+}
+
+type op struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+// Put sets the value of key when the batch is written.
+func (b *Batch) Put(key string, value []byte) {
+	b.ops = append(b.ops, op{key, value, false})
+}
+
+// Delete removes key, if it exists, when the batch is written.
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, op{key, nil, true})
+}
+
+// Len returns the number of puts and deletes in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Open opens the database in the file name, creating it if it doesn't exist,
+// and locks it until it's closed.
+func Open(name string) (db *DB, err os.Error) {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+
+	// The lock is released when the file is closed.
+	if errno := syscall.Flock(file.Fd(), syscall.LOCK_EX|syscall.LOCK_NB); errno != 0 {
+		file.Close()
+		if errno == syscall.EWOULDBLOCK {
+			return nil, ErrLocked
+		}
+		return nil, os.NewSyscallError("flock", errno)
+	}
+
+	db = &DB{name: name, file: file}
+	if err = db.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return
+}
+
+// load reads the keys and the locations of their values from the file,
+// truncating any incomplete batch at the end of it.
+func (db *DB) load() os.Error {
+	db.keys = nil
+	db.values = make(map[string]location)
+	db.garbage = 0
+
+	fileInfo, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if fileInfo.Size == 0 {
+		if _, err = db.file.WriteAt([]byte(magic), 0); err != nil {
+			return err
+		}
+		db.size = int64(len(magic))
+		return db.file.Sync()
+	}
+
+	if _, err = db.file.Seek(0, 0); err != nil {
+		return err
+	}
+	r := bufio.NewReader(db.file)
+
+	b := make([]byte, len(magic))
+	if _, err = io.ReadFull(r, b); err != nil || string(b) != magic {
+		return os.NewError("Not a database: " + db.name)
+	}
+
+	// Records are applied once the rest of their batch has been read.
+	type pending struct {
+		key      string
+		location location
+		flags    byte
+	}
+	var batch []pending
+
+	offset := int64(len(magic))
+	db.size = offset
+	for {
+		header := make([]byte, headerLen)
+		if _, err = io.ReadFull(r, header); err != nil {
+			break
+		}
+
+		keyLen := binary.LittleEndian.Uint32(header[5:])
+		valueLen := binary.LittleEndian.Uint32(header[9:])
+		if int64(keyLen)+int64(valueLen) > fileInfo.Size-offset {
+			break
+		}
+
+		data := make([]byte, keyLen+valueLen)
+		if _, err = io.ReadFull(r, data); err != nil {
+			break
+		}
+
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(data)
+		if crc.Sum32() != binary.LittleEndian.Uint32(header) {
+			break
+		}
+
+		flags := header[4]
+		batch = append(batch, pending{
+			string(data[:keyLen]),
+			location{offset + headerLen + int64(keyLen), int(valueLen)},
+			flags,
+		})
+		offset += headerLen + int64(keyLen) + int64(valueLen)
+
+		if flags&flagCommit != 0 {
+			for _, p := range batch {
+				db.apply(p.key, p.location, p.flags&flagDelete != 0)
+			}
+			batch = nil
+			db.size = offset
+		}
+	}
+
+	if db.size < fileInfo.Size {
+		// The last batch wasn't written completely.
+		if err = db.file.Truncate(db.size); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// apply updates the keys in memory for a put or delete. The caller must hold
+// the lock.
+func (db *DB) apply(key string, loc location, remove bool) {
+	old, found := db.values[key]
+	if found {
+		db.garbage += headerLen + int64(len(key)+old.length)
+	}
+
+	if remove {
+		if found {
+			db.values[key] = location{}, false
+			i := sort.SearchStrings(db.keys, key)
+			db.keys = append(db.keys[:i], db.keys[i+1:]...)
+		}
+		db.garbage += headerLen + int64(len(key))
+		return
+	}
+
+	if !found {
+		i := sort.SearchStrings(db.keys, key)
+		db.keys = append(db.keys, "")
+		copy(db.keys[i+1:], db.keys[i:])
+		db.keys[i] = key
+	}
+	db.values[key] = loc
+}
+
+// Write appends a batch to the file and applies it. Nothing is applied if it
+// fails.
+func (db *DB) Write(b *Batch) os.Error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.file == nil {
+		return os.EINVAL
+	}
+
+	var buf []byte
+	locations := make([]location, len(b.ops))
+	for i, o := range b.ops {
+		var flags byte
+		if o.delete {
+			flags |= flagDelete
+		}
+		if i == len(b.ops)-1 {
+			flags |= flagCommit
+		}
+
+		record := make([]byte, headerLen+len(o.key)+len(o.value))
+		record[4] = flags
+		binary.LittleEndian.PutUint32(record[5:], uint32(len(o.key)))
+		binary.LittleEndian.PutUint32(record[9:], uint32(len(o.value)))
+		copy(record[headerLen:], o.key)
+		copy(record[headerLen+len(o.key):], o.value)
+		binary.LittleEndian.PutUint32(record, crc32.ChecksumIEEE(record[4:]))
+
+		locations[i] = location{db.size + int64(len(buf)+headerLen+len(o.key)), len(o.value)}
+		buf = append(buf, record...)
+	}
+
+	if _, err := db.file.WriteAt(buf, db.size); err != nil {
+		db.file.Truncate(db.size)
+		return err
+	}
+	if err := db.file.Sync(); err != nil {
+		db.file.Truncate(db.size)
+		return err
+	}
+
+	for i, o := range b.ops {
+		db.apply(o.key, locations[i], o.delete)
+	}
+	db.size += int64(len(buf))
+
+	return nil
+}
+
+// Put sets the value of a key.
+func (db *DB) Put(key string, value []byte) os.Error {
+	b := new(Batch)
+	b.Put(key, value)
+
+	return db.Write(b)
+}
+
+// Delete removes a key, if it exists.
+func (db *DB) Delete(key string) os.Error {
+	b := new(Batch)
+	b.Delete(key)
+
+	return db.Write(b)
+}
+
+// Get returns the value of a key.
+func (db *DB) Get(key string) (value []byte, found bool, err os.Error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	loc, found := db.values[key]
+	if !found {
+		return
+	}
+
+	value = make([]byte, loc.length)
+	if _, err = db.file.ReadAt(value, loc.offset); err != nil {
+		return nil, false, err
+	}
+
+	return
+}
+
+// Has returns whether a key exists.
+func (db *DB) Has(key string) bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	_, found := db.values[key]
+
+	return found
+}
+
+// Scan calls fn with each key from start up to, but not including, limit in
+// ascending order, until fn returns false. fn must not call the database.
+func (db *DB) Scan(start, limit string, fn func(key string) bool) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	for i := sort.SearchStrings(db.keys, start); i < len(db.keys) && db.keys[i] < limit; i += 1 {
+		if !fn(db.keys[i]) {
+			return
+		}
+	}
+}
+
+// ScanReverse calls fn with each key from start up to, but not including,
+// limit in descending order, until fn returns false. fn must not call the
+// database.
+func (db *DB) ScanReverse(start, limit string, fn func(key string) bool) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	for i := sort.SearchStrings(db.keys, limit) - 1; i >= 0 && db.keys[i] >= start; i -= 1 {
+		if !fn(db.keys[i]) {
+			return
+		}
+	}
+}
+
+// Keys returns the keys starting with prefix in ascending order.
+func (db *DB) Keys(prefix string) (keys []string) {
+	db.Scan(prefix, PrefixLimit(prefix), func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	return
+}
+
+// PrefixLimit returns the smallest key greater than every key starting with
+// prefix, for use as the limit of a scan, or "\xff" if there isn't one.
+func PrefixLimit(prefix string) string {
+	for i := len(prefix) - 1; i >= 0; i -= 1 {
+		if prefix[i] != 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1})
+		}
+	}
+
+	return strings.Repeat("\xff", len(prefix)+1)
+}
+
+// Garbage returns the number of bytes in the file taken by old values that
+// Compact would reclaim.
+func (db *DB) Garbage() int64 {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return db.garbage
+}
+
+// Compact rewrites the file without any of the old values. The new file is
+// locked before it replaces the old one, so the database stays locked
+// throughout.
+func (db *DB) Compact() (err os.Error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.file == nil {
+		return os.EINVAL
+	}
+
+	tmpName := db.name + ".compact"
+	tmp, err := Open(tmpName)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	if tmp.size != int64(len(magic)) {
+		// Left over from an earlier attempt.
+		if err = tmp.file.Truncate(0); err == nil {
+			err = tmp.load()
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	b := new(Batch)
+	for _, key := range db.keys {
+		loc := db.values[key]
+		value := make([]byte, loc.length)
+		if _, err = db.file.ReadAt(value, loc.offset); err != nil {
+			return
+		}
+		b.Put(key, value)
+	}
+	if err = tmp.Write(b); err != nil {
+		return
+	}
+
+	if err = os.Rename(tmpName, db.name); err != nil {
+		return
+	}
+
+	db.file.Close()
+	db.file, tmp.file = tmp.file, nil
+	db.size = tmp.size
+	db.keys = tmp.keys
+	db.values = tmp.values
+	db.garbage = 0
+
+	return
+}
+
+// Close closes the database.
+func (db *DB) Close() os.Error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.file == nil {
+		return os.EINVAL
+	}
+
+	err := db.file.Close()
+	db.file = nil
+
+	return err
+}
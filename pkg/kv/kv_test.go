@@ -0,0 +1,192 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kv
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func tempDB(t *testing.T) *DB {
+	f, err := ioutil.TempFile("", "kv_test")
+	if err != nil {
+		t.Fatalf("TempFile() failed: %s", err)
+	}
+	f.Close()
+
+	db, err := Open(f.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+
+	return db
+}
+
+func checkKeys(t *testing.T, db *DB, prefix, want string) {
+	if keys := strings.Join(db.Keys(prefix), ","); keys != want {
+		t.Errorf("Keys(%q) = '%s' want '%s'", prefix, keys, want)
+	}
+}
+
+func checkValue(t *testing.T, db *DB, key, want string) {
+	value, found, err := db.Get(key)
+	if err != nil || !found || string(value) != want {
+		t.Errorf("Get(%q) = '%s', %v, %v want '%s'", key, value, found, err, want)
+	}
+}
+
+func TestWriteAndReopen(t *testing.T) {
+	db := tempDB(t)
+	defer os.Remove(db.name)
+
+	b := new(Batch)
+	b.Put("b/2", []byte("two"))
+	b.Put("a", []byte("a"))
+	b.Put("b/1", []byte("one"))
+	b.Put("c", nil)
+	if err := db.Write(b); err != nil {
+		t.Fatalf("Write() failed: %s", err)
+	}
+	if err := db.Put("b/2", []byte("TWO")); err != nil {
+		t.Fatalf("Put() failed: %s", err)
+	}
+	if err := db.Delete("a"); err != nil {
+		t.Fatalf("Delete() failed: %s", err)
+	}
+
+	for i := 0; i < 3; i += 1 {
+		checkKeys(t, db, "", "b/1,b/2,c")
+		checkKeys(t, db, "b/", "b/1,b/2")
+		checkValue(t, db, "b/2", "TWO")
+		checkValue(t, db, "c", "")
+		if db.Has("a") {
+			t.Errorf("deleted key is present")
+		}
+
+		switch i {
+		case 0:
+			db.Close()
+			var err os.Error
+			if db, err = Open(db.name); err != nil {
+				t.Fatalf("Open() failed: %s", err)
+			}
+			if db.Garbage() == 0 {
+				t.Errorf("no garbage after overwriting")
+			}
+		case 1:
+			if err := db.Compact(); err != nil {
+				t.Fatalf("Compact() failed: %s", err)
+			}
+			if db.Garbage() != 0 {
+				t.Errorf("garbage after compacting")
+			}
+		}
+	}
+
+	db.Close()
+}
+
+func TestIncompleteBatch(t *testing.T) {
+	db := tempDB(t)
+	defer os.Remove(db.name)
+
+	db.Put("a", []byte("a"))
+	size := db.size
+
+	b := new(Batch)
+	b.Put("b", []byte("b"))
+	b.Put("c", []byte("c"))
+	db.Write(b)
+	db.Close()
+
+	// Lose the end of the last batch.
+	if err := os.Truncate(db.name, db.size-1); err != nil {
+		t.Fatalf("Truncate() failed: %s", err)
+	}
+
+	db, err := Open(db.name)
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+
+	checkKeys(t, db, "", "a")
+	if db.size != size {
+		t.Errorf("size = %d want %d", db.size, size)
+	}
+}
+
+func TestScan(t *testing.T) {
+	db := tempDB(t)
+	defer os.Remove(db.name)
+	defer db.Close()
+
+	b := new(Batch)
+	for _, key := range []string{"a", "b1", "b2", "b3", "c", "\xff"} {
+		b.Put(key, nil)
+	}
+	db.Write(b)
+
+	var keys []string
+	db.ScanReverse("b", PrefixLimit("b"), func(key string) bool {
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	if s := strings.Join(keys, ","); s != "b3,b2" {
+		t.Errorf("ScanReverse() = '%s' want 'b3,b2'", s)
+	}
+
+	keys = nil
+	db.Scan("b2", "\xff\xff", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if s := strings.Join(keys, ","); s != "b2,b3,c,\xff" {
+		t.Errorf("Scan() = %q", s)
+	}
+
+	if limit := PrefixLimit("a\xff"); limit != "b" {
+		t.Errorf("PrefixLimit(\"a\\xff\") = %q want \"b\"", limit)
+	}
+}
+
+func TestLocking(t *testing.T) {
+	db := tempDB(t)
+	defer os.Remove(db.name)
+
+	if _, err := Open(db.name); err != ErrLocked {
+		t.Errorf("Open() of an open database = %v want ErrLocked", err)
+	}
+
+	// Compacting replaces the file with one that is just as locked.
+	db.Put("a", []byte("a"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact() failed: %s", err)
+	}
+	if _, err := Open(db.name); err != ErrLocked {
+		t.Errorf("Open() after compacting = %v want ErrLocked", err)
+	}
+
+	db.Close()
+	db, err := Open(db.name)
+	if err != nil {
+		t.Fatalf("Open() after closing failed: %s", err)
+	}
+	db.Close()
+}
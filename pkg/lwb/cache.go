@@ -17,42 +17,233 @@ limitations under the License.
 package lwb
 
 import (
-	"bytes"
+	"compress/gzip"
+	"container/list"
+	"expvar"
 	"github.com/garyburd/twister/web"
-	"io"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 // PageCache is a simple interface that caches url -> rendered page.
 type PageCache interface {
-	Run(*web.Request, func(io.Writer) bool)
+	// Run responds to a request with the cached page, calling fnGenerate to
+	// generate it if need be. If fnGenerate returns false the page isn't
+	// found, and the request is answered with a 404 that isn't cached.
+	Run(req *web.Request, fnGenerate func(w *Page) bool)
+
+	// Invalidate discards the page at path, including every page of it when
+	// it is split across several.
+	Invalidate(path string)
+
+	// InvalidatePrefix discards all the pages whose paths start with prefix.
+	InvalidatePrefix(prefix string)
+
+	// Flush discards all cached pages.
+	Flush()
 }
 
-// Cache is an implementation of PageCache.
+// The limits of a cache created by NewCache.
+const (
+	DefaultCacheEntries = 1000
+	DefaultCacheBytes   = 32 << 20
+)
+
+// Counters for all the caches, exported through expvar.
+var (
+	cacheHits      = expvar.NewInt("cache_hits")
+	cacheMisses    = expvar.NewInt("cache_misses")
+	cacheEvictions = expvar.NewInt("cache_evictions")
+	cacheEntries   = expvar.NewInt("cache_entries")
+	cacheBytes     = expvar.NewInt("cache_bytes")
+)
+
+// Cache is an implementation of PageCache that keeps the most recently used
+// pages, up to a limit on their number and total size. It is safe to use from
+// multiple goroutines.
 type Cache struct {
-	items map[string][]byte
+	lock sync.Mutex
+
+	// The limits, where 0 means no limit.
+	maxEntries int
+	maxBytes   int64
+
+	// The total size of the cached pages.
+	size int64
+
+	// Counts invalidations, so that pages generated from data that has since
+	// changed aren't cached.
+	epoch int64
+
+	// The entries, most recently used first, and a map of key -> element of
+	// the list.
+	lru   *list.List
+	items map[string]*list.Element
 }
 
+// cacheEntry is a cached page.
+type cacheEntry struct {
+	key  string
+	page *Page
+}
+
+// NewCache creates a cache with the default limits.
 func NewCache() *Cache {
-	return &Cache{make(map[string][]byte)}
+	return NewLimitedCache(DefaultCacheEntries, DefaultCacheBytes)
+}
+
+// NewLimitedCache creates a cache that holds at most maxEntries pages, taking
+// at most maxBytes in total. A limit of 0 means no limit.
+func NewLimitedCache(maxEntries int, maxBytes int64) *Cache {
+	return &Cache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		items:      make(map[string]*list.Element),
+	}
 }
 
 // Run looks up the page in the cache and generates it if it does not exist,
-// placing it in the cache afterwards.
-func (c *Cache) Run(req *web.Request, fnGenerate func(w io.Writer) bool) {
-	cached, found := c.items[req.URL.String()]
+// placing it in the cache afterwards. Clients that already have the page are
+// answered with 304 Not Modified.
+func (c *Cache) Run(req *web.Request, fnGenerate func(w *Page) bool) {
+	key := cacheKey(req)
+	page, epoch, found := c.get(key)
 	if !found {
-		var buf = &bytes.Buffer{}
-		if !fnGenerate(buf) {
+		// Pages are generated without holding the lock, so the same page
+		// may occasionally be generated twice, and the page may be
+		// invalidated while it's being generated, in which case it's
+		// served but not cached.
+		page = &Page{}
+		if !fnGenerate(page) {
 			req.Error(web.StatusNotFound, os.NewError("Not Found."))
 			return
 		}
 
-		cached = buf.Bytes()
-		c.items[req.URL.String()] = cached
+		// Cached pages are compressed now, outside the lock, as they're
+		// likely to be served many times.
+		page.finish()
+		page.compress(gzip.BestCompression)
+		c.add(key, page, epoch)
+	}
+
+	page.respond(req)
+}
+
+// get returns the page cached under key, if any, marking it as recently used,
+// along with the current epoch.
+func (c *Cache) get(key string) (*Page, int64, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, found := c.items[key]
+	if !found {
+		cacheMisses.Add(1)
+		return nil, c.epoch, false
+	}
+
+	cacheHits.Add(1)
+	c.lru.MoveToFront(e)
+
+	return e.Value.(*cacheEntry).page, c.epoch, true
+}
+
+// add caches a page under key, evicting the least recently used pages if the
+// cache is over its limits. The page isn't cached if anything has been
+// invalidated since epoch, as it may be out of date, or if it's bigger than the
+// whole cache.
+func (c *Cache) add(key string, page *Page, epoch int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	size := page.size()
+	if epoch != c.epoch || (c.maxBytes > 0 && size > c.maxBytes) {
+		return
+	}
+
+	if e, found := c.items[key]; found {
+		c.remove(e)
+	}
+
+	c.items[key] = c.lru.PushFront(&cacheEntry{key, page})
+	c.size += size
+	cacheEntries.Add(1)
+	cacheBytes.Add(size)
+
+	for (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.size > c.maxBytes) {
+		c.remove(c.lru.Back())
+		cacheEvictions.Add(1)
+	}
+}
+
+// remove drops an entry. The caller must hold the lock.
+func (c *Cache) remove(e *list.Element) {
+	entry := e.Value.(*cacheEntry)
+	c.lru.Remove(e)
+	c.items[entry.key] = nil, false
+	c.size -= entry.page.size()
+	cacheEntries.Add(-1)
+	cacheBytes.Add(-entry.page.size())
+}
+
+// cacheKey returns the key a page is cached under. The rendered pages only
+// depend on the path and the page number, so other query parameters are ignored
+// rather than filling the cache with copies of the same page, and the page
+// number is normalized so that e.g. "?page=02" and "?page=2" share an entry.
+func cacheKey(req *web.Request) string {
+	key := req.URL.Path
+	if page := req.Param.Get("page"); page != "" {
+		if n, err := strconv.Atoi(page); err != nil {
+			key += "?page=" + page
+		} else if n != 1 {
+			key += "?page=" + strconv.Itoa(n)
+		}
+	}
+
+	return key
+}
+
+// Invalidate discards the page at path, including every page of it when it is
+// split across several.
+func (c *Cache) Invalidate(path string) {
+	c.removeMatching(func(key string) bool {
+		return key == path || strings.HasPrefix(key, path+"?page=")
+	})
+}
+
+// InvalidatePrefix discards all the pages whose paths start with prefix.
+func (c *Cache) InvalidatePrefix(prefix string) {
+	c.removeMatching(func(key string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+// removeMatching discards the pages whose keys match. Pages being generated
+// when it's called aren't cached, whether they match or not.
+func (c *Cache) removeMatching(match func(key string) bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.epoch += 1
+	for key, e := range c.items {
+		if match(key) {
+			c.remove(e)
+		}
 	}
+}
+
+// Flush discards all cached pages.
+func (c *Cache) Flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 
-	req.Respond(web.StatusOK, web.HeaderContentType, "text/html").Write(cached)
+	cacheEntries.Add(-int64(c.lru.Len()))
+	cacheBytes.Add(-c.size)
+
+	c.epoch += 1
+	c.size = 0
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
 }
 
 // DummyCache is a noop Cache.
@@ -63,11 +254,23 @@ func NewDummyCache() *DummyCache {
 }
 
 // Run simply runs the generator.
-func (c *DummyCache) Run(req *web.Request, fnGenerate func(w io.Writer) bool) {
-	var buf = &bytes.Buffer{}
-	if fnGenerate(buf) {
-		req.Respond(web.StatusOK, web.HeaderContentType, "text/html").Write(buf.Bytes())
+func (c *DummyCache) Run(req *web.Request, fnGenerate func(w *Page) bool) {
+	page := &Page{}
+	if fnGenerate(page) {
+		page.Serve(req)
 	} else {
 		req.Error(web.StatusNotFound, os.NewError("Not Found."))
 	}
 }
+
+// Invalidate does nothing.
+func (c *DummyCache) Invalidate(path string) {
+}
+
+// InvalidatePrefix does nothing.
+func (c *DummyCache) InvalidatePrefix(prefix string) {
+}
+
+// Flush does nothing.
+func (c *DummyCache) Flush() {
+}
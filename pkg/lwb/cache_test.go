@@ -0,0 +1,146 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lwb
+
+import (
+	"github.com/garyburd/twister/web"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cachedKeys returns the keys in the cache, most recently used first.
+func cachedKeys(c *Cache) string {
+	var keys []string
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*cacheEntry).key)
+	}
+
+	return strings.Join(keys, ",")
+}
+
+// testPage returns a page with the given body.
+func testPage(body string) *Page {
+	page := &Page{}
+	page.WriteString(body)
+
+	return page
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := NewLimitedCache(3, 10)
+	evictions := cacheEvictions.Value()
+
+	c.add("a", testPage("1"), c.epoch)
+	c.add("b", testPage("2"), c.epoch)
+	c.add("c", testPage("3"), c.epoch)
+	if _, _, found := c.get("a"); !found {
+		t.Errorf("get(\"a\") missed")
+	}
+	c.add("d", testPage("4"), c.epoch)
+	if keys := cachedKeys(c); keys != "d,a,c" {
+		t.Errorf("after the entry limit, keys = %s want d,a,c", keys)
+	}
+
+	c.add("e", testPage("123456789"), c.epoch)
+	if keys := cachedKeys(c); keys != "e,d" || c.size != 10 {
+		t.Errorf("after the size limit, keys = %s (%d bytes) want e,d (10 bytes)", keys, c.size)
+	}
+	if n := cacheEvictions.Value() - evictions; n != 3 {
+		t.Errorf("%d evictions want 3", n)
+	}
+
+	// Replacing an entry updates the size, and pages that won't fit aren't
+	// cached.
+	c.add("f", testPage("12345678901"), c.epoch)
+	c.add("d", testPage("45"), c.epoch)
+	c.add("e", testPage("6"), c.epoch)
+	if keys := cachedKeys(c); keys != "e,d" || c.size != 3 {
+		t.Errorf("keys = %s (%d bytes) want e,d (3 bytes)", keys, c.size)
+	}
+
+	c.Flush()
+	if _, _, found := c.get("d"); found || c.size != 0 || c.lru.Len() != 0 {
+		t.Errorf("Flush() left entries")
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	c := NewCache()
+	for _, key := range []string{"/", "/?page=2", "/2011/", "/2011/05/", "/2011/05/hello",
+		"/tag/go/", "/tag/go/index.xml", "/tag/go/?page=3", "/tag/golang/"} {
+		c.add(key, testPage(key), c.epoch)
+	}
+
+	c.Invalidate("/")
+	c.Invalidate("/2011/")
+	if keys := cachedKeys(c); keys != "/tag/golang/,/tag/go/?page=3,/tag/go/index.xml,/tag/go/,/2011/05/hello,/2011/05/" {
+		t.Errorf("after Invalidate(), keys = %s", keys)
+	}
+
+	c.InvalidatePrefix("/tag/go/")
+	if keys := cachedKeys(c); keys != "/tag/golang/,/2011/05/hello,/2011/05/" {
+		t.Errorf("after InvalidatePrefix(), keys = %s", keys)
+	}
+	if c.size != int64(len("/tag/golang/")+len("/2011/05/hello")+len("/2011/05/")) {
+		t.Errorf("size = %d after invalidating", c.size)
+	}
+}
+
+func TestCacheInvalidateWhileGenerating(t *testing.T) {
+	c := NewCache()
+
+	// A page generated before an invalidation isn't cached, as it may show
+	// what was invalidated.
+	_, epoch, _ := c.get("/")
+	c.Invalidate("/2011/05/hello")
+	c.add("/", testPage("stale"), epoch)
+	if keys := cachedKeys(c); keys != "" {
+		t.Errorf("after Invalidate(), keys = %s want none", keys)
+	}
+
+	_, epoch, _ = c.get("/")
+	c.Flush()
+	c.add("/", testPage("stale"), epoch)
+	if keys := cachedKeys(c); keys != "" {
+		t.Errorf("after Flush(), keys = %s want none", keys)
+	}
+
+	_, epoch, _ = c.get("/")
+	c.add("/", testPage("fresh"), epoch)
+	if keys := cachedKeys(c); keys != "/" {
+		t.Errorf("keys = %s want /", keys)
+	}
+}
+
+func TestCacheLastModified(t *testing.T) {
+	// Pages keep the date their generator gave them, whether or not they're
+	// cached, and cached copies are served with it too.
+	for _, cache := range []PageCache{NewCache(), NewDummyCache()} {
+		for i := 0; i < 2; i++ {
+			req, resp := newTestRequest(t, "/", web.Header{})
+			cache.Run(req, func(w *Page) bool {
+				w.LastModified = time.SecondsToUTC(1304400826)
+				w.WriteString("hello")
+				return true
+			})
+			if s := resp.header.Get(web.HeaderLastModified); s != "Tue, 03 May 2011 05:33:46 GMT" {
+				t.Errorf("%T: Last-Modified = '%s'", cache, s)
+			}
+		}
+	}
+}
@@ -26,12 +26,18 @@ type BlogConfig struct {
 	Title       string
 	Description string
 
+	// The time zone the archives go by and dates are shown in. If nil, each
+	// date stays in the zone it was written in.
+	TimeZone *Location
+
 	// Version number for versioned resources.
 	Version int
 
 	// Main Index.
-	NumMainIndexPosts int
-	MainIndexRegexp   string
+	NumMainIndexPosts   int
+	MainIndexRegexp     string
+	MainIndexPageRegexp string
+	MainIndexPageUrl    string // Printf format taking the page number.
 
 	// Sidebar.
 	NumRecentPosts int
@@ -43,11 +49,26 @@ type BlogConfig struct {
 	PostRegexp string
 
 	// Archives.
+	NumArchivePosts       int // Posts per page, or 0 for everything on one page.
 	MonthlyArchiveRegexp  string
 	YearlyArchiveRegexp   string
 	TagArchiveRegexp      string
 	CategoryArchiveRegexp string
 
+	// Feeds for individual tags and categories.
+	TagRssFeedRegexp       string
+	TagAtomFeedRegexp      string
+	CategoryRssFeedRegexp  string
+	CategoryAtomFeedRegexp string
+
+	// Search.
+	SearchUrl    string // What to use in rendered content.
+	SearchRegexp string
+
+	// Sitemap.
+	SitemapUrl    string // What to use in rendered content.
+	SitemapRegexp string
+
 	// Other content.
 	StaticRegexp string
 
@@ -56,9 +77,49 @@ type BlogConfig struct {
 	RssUrl          string // What to use in rendered content.
 	RssFeedRegexp   string
 
+	// Atom.
+	AtomUrl        string // What to use in rendered content.
+	AtomFeedRegexp string
+
+	// JSON Feed.
+	JsonFeedUrl    string // What to use in rendered content.
+	JsonFeedRegexp string
+
 	// Comments.
 	DisqusShortname string
 
+	// Remote editing (MetaWeblog XML-RPC and AtomPub). Disabled if RpcPassword
+	// is empty.
+	RpcRegexp   string
+	RpcUsername string
+	RpcPassword string
+
+	// AtomPub.
+	AtomPubUrl              string // The service document, with collections below it.
+	AtomPubServiceRegexp    string
+	AtomPubCollectionRegexp string
+	AtomPubMemberRegexp     string
+
+	// Micropub. Tokens are checked against the IndieAuth token endpoint.
+	MicropubRegexp        string
+	MicropubTokenEndpoint string
+
+	// Uploaded media. MediaPath is the directory uploads are written to and
+	// MediaUrl the path they are served from.
+	MediaPath string
+	MediaUrl  string
+
+	// Draft previews. Preview links are signed with PreviewSecret, and
+	// previews are disabled if it is empty.
+	PreviewUrl    string
+	PreviewRegexp string
+	PreviewSecret string
+
+	// Reloading the store, e.g. from a git hook. Requests must give
+	// ReloadSecret as the token, and reloading is disabled if it is empty.
+	ReloadRegexp string
+	ReloadSecret string
+
 	// Cache.
 	Cache PageCache
 }
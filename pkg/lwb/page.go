@@ -0,0 +1,216 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lwb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"fmt"
+	"github.com/garyburd/twister/web"
+	"http"
+	"strings"
+	"time"
+)
+
+// Pages smaller than this aren't worth compressing.
+const minGzipSize = 256
+
+// Page is a page generated for a PageCache. Generators write the body to it and
+// may set the status, content type and any other headers, all of which are
+// cached along with the body, e.g. to serve a feed or an error page.
+//
+// Pages are served compressed with gzip to clients that accept it. Cached pages
+// are compressed as well as possible once, when they're complete, while pages
+// that aren't cached are compressed quickly, and only for clients that accept
+// it. There's no brotli encoder in the standard library, so gzip is the only
+// encoding offered.
+type Page struct {
+	bytes.Buffer
+
+	// The status, or 0 for web.StatusOK.
+	Status int
+
+	// The content type, or "" for "text/html".
+	ContentType string
+
+	// Any other headers.
+	Header web.Header
+
+	// When the newest of the posts on the page was last modified, if known.
+	LastModified *time.Time
+
+	// A strong entity tag for the body, set once the page is complete, and the
+	// body compressed with gzip if that makes it smaller, set once it's
+	// compressed.
+	etag    string
+	gzipped []byte
+}
+
+// Serve responds to req with a page that isn't cached, or with 304 Not
+// Modified if the client already has it.
+func (p *Page) Serve(req *web.Request) {
+	p.finish()
+	if AcceptsEncoding(req.Header, "gzip") {
+		p.compress(gzip.DefaultCompression)
+	}
+	p.respond(req)
+}
+
+// finish computes the validators of a page once its body is complete.
+func (p *Page) finish() {
+	h := sha1.New()
+	h.Write(p.Bytes())
+	p.etag = fmt.Sprintf("\"%x\"", h.Sum())
+}
+
+// compress compresses the body of a complete page with gzip at the given
+// level, keeping the result if it's smaller.
+func (p *Page) compress(level int) {
+	p.gzipped = nil
+	if p.Len() < minGzipSize {
+		return
+	}
+
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return
+	}
+	gz.Write(p.Bytes())
+	if gz.Close() == nil && buf.Len() < p.Len() {
+		p.gzipped = buf.Bytes()
+	}
+}
+
+// size returns the memory taken by the bodies of a page.
+func (p *Page) size() int64 {
+	return int64(p.Len() + len(p.gzipped))
+}
+
+// respond writes the page as the response to req, compressed if the client
+// accepts it, or 304 Not Modified if the client already has it.
+func (p *Page) respond(req *web.Request) {
+	status := p.Status
+	if status == 0 {
+		status = web.StatusOK
+	}
+
+	body, etag := p.Bytes(), p.etag
+	gzipped := p.gzipped != nil && AcceptsEncoding(req.Header, "gzip")
+	if gzipped {
+		// Each encoding is a different representation, with its own tag.
+		body, etag = p.gzipped, etag[:len(etag)-1]+"-gzip\""
+	}
+
+	var header []string
+	if etag != "" {
+		header = append(header, web.HeaderETag, etag)
+	}
+	if p.Len() >= minGzipSize {
+		// Other clients may be sent the page compressed.
+		header = append(header, web.HeaderVary, web.HeaderAcceptEncoding)
+	}
+	if p.LastModified != nil {
+		header = append(header, web.HeaderLastModified, httpTime(p.LastModified))
+	}
+	for key, values := range p.Header {
+		for _, value := range values {
+			header = append(header, key, value)
+		}
+	}
+
+	if status == web.StatusOK && p.notModified(req.Method, req.Header, etag) {
+		req.Respond(web.StatusNotModified, header...)
+		return
+	}
+
+	contentType := p.ContentType
+	if contentType == "" {
+		contentType = "text/html"
+	}
+	header = append(header, web.HeaderContentType, contentType)
+	if gzipped {
+		header = append(header, web.HeaderContentEncoding, "gzip")
+	}
+
+	req.Respond(status, header...).Write(body)
+}
+
+// notModified returns whether the conditional headers of a request show that
+// the client already has the page, as tagged with etag. If-None-Match takes
+// precedence over If-Modified-Since, which is only good to the second.
+func (p *Page) notModified(method string, header web.Header, etag string) bool {
+	if method != "GET" && method != "HEAD" {
+		return false
+	}
+
+	if match := header.Get(web.HeaderIfNoneMatch); match != "" {
+		for _, tag := range strings.Split(match, ",", -1) {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || (etag != "" && (tag == etag || tag == "W/"+etag)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := header.Get(web.HeaderIfModifiedSince); since != "" && p.LastModified != nil {
+		t, err := time.Parse(http.TimeFormat, since)
+		return err == nil && p.LastModified.Seconds() <= t.Seconds()
+	}
+
+	return false
+}
+
+// httpTime formats a time for an HTTP header.
+func httpTime(t *time.Time) string {
+	return time.SecondsToUTC(t.Seconds()).Format(http.TimeFormat)
+}
+
+// AcceptsEncoding returns whether the Accept-Encoding header of a request allows
+// a content coding, e.g. "gzip", either by name or through "*".
+func AcceptsEncoding(header web.Header, coding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(header.Get(web.HeaderAcceptEncoding), ",", -1) {
+		name, params := part, ""
+		if i := strings.Index(part, ";"); i >= 0 {
+			name, params = part[:i], part[i+1:]
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case coding:
+			return !zeroQuality(params)
+		case "*":
+			wildcard = !zeroQuality(params)
+		}
+	}
+
+	return wildcard
+}
+
+// zeroQuality returns whether the parameters of an entry in an Accept-Encoding
+// header give it a quality of 0, which means it isn't acceptable.
+func zeroQuality(params string) bool {
+	params = strings.Replace(params, " ", "", -1)
+	if !strings.HasPrefix(params, "q=") {
+		return false
+	}
+
+	q := strings.TrimRight(params[len("q="):], "0")
+	return q == "" || q == "0."
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lwb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testResponder records the response to a request.
+type testResponder struct {
+	status int
+	header web.Header
+	body   bytes.Buffer
+}
+
+func (r *testResponder) Respond(status int, header web.Header) web.ResponseBody {
+	r.status = status
+	r.header = header
+	return r
+}
+
+func (r *testResponder) Write(p []byte) (int, os.Error) {
+	return r.body.Write(p)
+}
+
+func (r *testResponder) Flush() os.Error {
+	return nil
+}
+
+func (r *testResponder) Hijack() (net.Conn, []byte, os.Error) {
+	return nil, nil, os.NewError("Can't hijack a test request.")
+}
+
+// newTestRequest returns a GET request for path with the given headers, whose
+// response is recorded by the returned responder.
+func newTestRequest(t *testing.T, path string, header web.Header) (*web.Request, *testResponder) {
+	req, err := web.NewRequest("127.0.0.1:80", "GET", &http.URL{Path: path}, web.ProtocolVersion(1, 1), header)
+	if err != nil {
+		t.Fatalf("NewRequest(%s) failed: %s", path, err)
+	}
+
+	resp := new(testResponder)
+	req.Responder = resp
+
+	return req, resp
+}
+
+var notModifiedTests = []struct {
+	method string
+	header web.Header
+	want   bool
+}{
+	{"GET", web.Header{}, false},
+	{"GET", web.Header{web.HeaderIfNoneMatch: {etagOfHello}}, true},
+	{"HEAD", web.Header{web.HeaderIfNoneMatch: {"\"other\", " + etagOfHello}}, true},
+	{"GET", web.Header{web.HeaderIfNoneMatch: {"W/" + etagOfHello}}, true},
+	{"GET", web.Header{web.HeaderIfNoneMatch: {"*"}}, true},
+	{"GET", web.Header{web.HeaderIfNoneMatch: {"\"other\""}}, false},
+	{"POST", web.Header{web.HeaderIfNoneMatch: {etagOfHello}}, false},
+
+	// Last modified at 1304400826.
+	{"GET", web.Header{web.HeaderIfModifiedSince: {"Tue, 03 May 2011 05:33:46 GMT"}}, true},
+	{"GET", web.Header{web.HeaderIfModifiedSince: {"Wed, 04 May 2011 00:00:00 GMT"}}, true},
+	{"GET", web.Header{web.HeaderIfModifiedSince: {"Tue, 03 May 2011 05:33:45 GMT"}}, false},
+	{"GET", web.Header{web.HeaderIfModifiedSince: {"yesterday"}}, false},
+
+	// If-None-Match wins.
+	{"GET", web.Header{web.HeaderIfNoneMatch: {"\"other\""},
+		web.HeaderIfModifiedSince: {"Wed, 04 May 2011 00:00:00 GMT"}}, false},
+}
+
+// The entity tag of a page containing "hello".
+const etagOfHello = "\"aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d\""
+
+func TestPageNotModified(t *testing.T) {
+	page := testPage("hello")
+	page.LastModified = time.SecondsToUTC(1304400826)
+	page.finish()
+	if page.etag != etagOfHello {
+		t.Fatalf("etag = %s want %s", page.etag, etagOfHello)
+	}
+	if s := httpTime(page.LastModified); s != "Tue, 03 May 2011 05:33:46 GMT" {
+		t.Errorf("httpTime() = %s", s)
+	}
+
+	for i, nt := range notModifiedTests {
+		if got := page.notModified(nt.method, nt.header, page.etag); got != nt.want {
+			t.Errorf("%d: notModified(%s, %v) = %v want %v", i, nt.method, nt.header, got, nt.want)
+		}
+	}
+}
+
+func TestPageGzip(t *testing.T) {
+	small := testPage("hello")
+	small.compress(gzip.BestCompression)
+	if small.gzipped != nil {
+		t.Errorf("a small page was compressed")
+	}
+
+	body := strings.Repeat("<p>Hello, world.</p>\n", 100)
+	page := testPage(body)
+	page.compress(gzip.BestCompression)
+	if page.gzipped == nil || len(page.gzipped) >= len(body) {
+		t.Fatalf("page wasn't compressed")
+	}
+	if page.size() != int64(len(body)+len(page.gzipped)) {
+		t.Errorf("size() = %d", page.size())
+	}
+
+	gz, err := gzip.NewReader(bytes.NewBuffer(page.gzipped))
+	if err != nil {
+		t.Fatalf("NewReader() failed: %s", err)
+	}
+	if b, err := ioutil.ReadAll(gz); err != nil || string(b) != body {
+		t.Errorf("the compressed page doesn't match: %s", err)
+	}
+}
+
+func TestPageServeGzip(t *testing.T) {
+	body := strings.Repeat("<p>Hello, world.</p>\n", 100)
+	for _, accept := range []string{"", "gzip"} {
+		page := testPage(body)
+		req, resp := newTestRequest(t, "/", web.Header{web.HeaderAcceptEncoding: {accept}})
+		page.Serve(req)
+
+		// Pages that aren't cached are only compressed for clients that
+		// accept it.
+		encoding := resp.header.Get(web.HeaderContentEncoding)
+		if compressed := page.gzipped != nil; compressed != (accept == "gzip") || encoding != accept {
+			t.Errorf("Accept-Encoding '%s': compressed = %v, Content-Encoding = '%s'", accept, compressed, encoding)
+		}
+		if vary := resp.header.Get(web.HeaderVary); vary != web.HeaderAcceptEncoding {
+			t.Errorf("Accept-Encoding '%s': Vary = '%s'", accept, vary)
+		}
+		if accept == "" && resp.body.String() != body {
+			t.Errorf("the uncompressed body doesn't match")
+		}
+	}
+}
+
+var acceptsEncodingTests = []struct {
+	accept string
+	want   bool
+}{
+	{"", false},
+	{"gzip", true},
+	{"deflate, gzip, br", true},
+	{"GZIP;q=0.5", true},
+	{"gzip;q=0", false},
+	{"gzip; q=0.000, *", false},
+	{"*", true},
+	{"*;q=0", false},
+	{"deflate", false},
+	{"x-gzip", false},
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	for _, at := range acceptsEncodingTests {
+		header := web.Header{web.HeaderAcceptEncoding: {at.accept}}
+		if got := AcceptsEncoding(header, "gzip"); got != at.want {
+			t.Errorf("AcceptsEncoding(%q) = %v want %v", at.accept, got, at.want)
+		}
+	}
+}
@@ -0,0 +1,507 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lwb
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The directories searched for zoneinfo files, after $ZONEINFO.
+var zoneinfoDirs = []string{"/usr/share/zoneinfo", "/usr/share/lib/zoneinfo", "/usr/lib/locale/TZ"}
+
+const secondsPerDay = 24 * 60 * 60
+
+// zone is a period with a fixed offset from UTC, e.g. Pacific Daylight Time.
+type zone struct {
+	name   string
+	offset int // Seconds east of UTC.
+	isDst  bool
+}
+
+// transition is a change to the zone in effect.
+type transition struct {
+	when int64 // Seconds since the epoch.
+	zone int   // Index into zones.
+}
+
+// zoneRule is a POSIX TZ rule for switching between standard and daylight
+// saving time every year, e.g. "PST8PDT,M3.2.0,M11.1.0".
+type zoneRule struct {
+	std, dst   zone
+	start, end ruleDate
+}
+
+// ruleDate is the local time of year at which a zoneRule switches zones.
+type ruleDate struct {
+	kind  byte // 'J' for Julian days, 'M' for month rules, or 'D' for days.
+	day   int  // The day of the year, or of the week for month rules.
+	week  int
+	month int
+	time  int // Seconds after midnight.
+}
+
+// Location is a time zone, such as America/Los_Angeles, including the history
+// of its daylight saving time rules.
+type Location struct {
+	name        string
+	zones       []zone
+	transitions []transition
+
+	// The rule for times after the last transition, if any.
+	rule *zoneRule
+}
+
+// UTC is Coordinated Universal Time.
+var UTC = &Location{name: "UTC", zones: []zone{zone{"UTC", 0, false}}}
+
+// LoadLocation reads a time zone, e.g. "America/Los_Angeles", from the
+// system's zoneinfo files. "" and "UTC" both give UTC.
+func LoadLocation(name string) (*Location, os.Error) {
+	if name == "" || name == "UTC" {
+		return UTC, nil
+	}
+	if strings.HasPrefix(name, "/") || strings.Contains(name, "..") {
+		return nil, os.NewError("Invalid time zone: " + name)
+	}
+
+	dirs := zoneinfoDirs
+	if dir := os.Getenv("ZONEINFO"); dir != "" {
+		dirs = append([]string{dir}, dirs...)
+	}
+
+	for _, dir := range dirs {
+		if data, err := ioutil.ReadFile(path.Join(dir, name)); err == nil {
+			return parseZoneinfo(name, data)
+		}
+	}
+
+	return nil, os.NewError("Unknown time zone: " + name)
+}
+
+func (l *Location) String() string {
+	return l.name
+}
+
+// zoneData reads the big endian values in a zoneinfo file.
+type zoneData struct {
+	b   []byte
+	bad bool
+}
+
+func (d *zoneData) read(n int) []byte {
+	if n < 0 || len(d.b) < n {
+		d.bad = true
+		return make([]byte, n)
+	}
+
+	b := d.b[:n]
+	d.b = d.b[n:]
+
+	return b
+}
+
+func (d *zoneData) big32() int64 {
+	b := d.read(4)
+	return int64(int32(uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])))
+}
+
+func (d *zoneData) big64() int64 {
+	hi := d.big32()
+	lo := d.big32()
+	return hi<<32 | lo&0xffffffff
+}
+
+// parseZoneinfo parses a zoneinfo (TZif) file. Version 2 and later files
+// repeat the data with 64 bit times, followed by a POSIX TZ rule for times
+// after the last transition, which are used in preference to the original
+// data.
+func parseZoneinfo(name string, data []byte) (*Location, os.Error) {
+	d := &zoneData{b: data}
+	bad := os.NewError("Bad zoneinfo file for " + name)
+
+	// The header gives the magic, the version and the sizes of the sections.
+	header := func() (version byte, counts []int) {
+		if string(d.read(4)) != "TZif" {
+			d.bad = true
+		}
+		version = d.read(16)[0]
+		for i := 0; i < 6; i += 1 {
+			counts = append(counts, int(d.big32()))
+		}
+		return
+	}
+
+	const (
+		nUtc = iota
+		nStd
+		nLeap
+		nTime
+		nZone
+		nChar
+	)
+
+	timeSize := 4
+	version, n := header()
+	if d.bad {
+		return nil, bad
+	}
+	if version >= '2' {
+		// Skip to the 64 bit data.
+		d.read(n[nTime]*5 + n[nZone]*6 + n[nChar] + n[nLeap]*8 + n[nStd] + n[nUtc])
+		_, n = header()
+		timeSize = 8
+	}
+	if d.bad {
+		return nil, bad
+	}
+
+	l := &Location{name: name}
+
+	times := make([]int64, n[nTime])
+	for i := range times {
+		if timeSize == 8 {
+			times[i] = d.big64()
+		} else {
+			times[i] = d.big32()
+		}
+	}
+	indices := d.read(n[nTime])
+
+	abbrevIndices := make([]int, n[nZone])
+	for i := 0; i < n[nZone]; i += 1 {
+		offset := int(d.big32())
+		info := d.read(2)
+		l.zones = append(l.zones, zone{offset: offset, isDst: info[0] != 0})
+		abbrevIndices[i] = int(info[1])
+	}
+
+	abbrevs := d.read(n[nChar])
+	d.read(n[nLeap]*(timeSize+4) + n[nStd] + n[nUtc])
+	if d.bad || len(l.zones) == 0 {
+		return nil, bad
+	}
+
+	for i, j := range abbrevIndices {
+		if j >= len(abbrevs) {
+			return nil, bad
+		}
+		name := abbrevs[j:]
+		if end := strings.Index(string(name), "\x00"); end >= 0 {
+			name = name[:end]
+		}
+		l.zones[i].name = string(name)
+	}
+
+	for i, when := range times {
+		if int(indices[i]) >= len(l.zones) {
+			return nil, bad
+		}
+		l.transitions = append(l.transitions, transition{when, int(indices[i])})
+	}
+
+	// The footer is the rule surrounded by newlines.
+	if timeSize == 8 && len(d.b) > 2 && d.b[0] == '\n' {
+		if end := strings.Index(string(d.b[1:]), "\n"); end > 0 {
+			l.rule = parseZoneRule(string(d.b[1 : end+1]))
+		}
+	}
+
+	return l, nil
+}
+
+// parseZoneRule parses a POSIX TZ rule, returning nil if it can't be parsed.
+func parseZoneRule(s string) *zoneRule {
+	r := new(zoneRule)
+
+	var ok bool
+	if r.std.name, s, ok = ruleName(s); !ok {
+		return nil
+	}
+	if r.std.offset, s, ok = ruleOffset(s); !ok {
+		return nil
+	}
+	// POSIX offsets are west of UTC.
+	r.std.offset = -r.std.offset
+
+	if s == "" {
+		// No daylight saving time.
+		r.dst = r.std
+		return r
+	}
+
+	if r.dst.name, s, ok = ruleName(s); !ok {
+		return nil
+	}
+	r.dst.isDst = true
+	r.dst.offset = r.std.offset + 60*60
+	if s != "" && s[0] != ',' {
+		if r.dst.offset, s, ok = ruleOffset(s); !ok {
+			return nil
+		}
+		r.dst.offset = -r.dst.offset
+	}
+
+	if s == "" {
+		// The default rules are the US ones.
+		s = ",M3.2.0,M11.1.0"
+	}
+	if s[0] != ',' {
+		return nil
+	}
+	if r.start, s, ok = ruleDateTime(s[1:]); !ok || s == "" || s[0] != ',' {
+		return nil
+	}
+	if r.end, s, ok = ruleDateTime(s[1:]); !ok || s != "" {
+		return nil
+	}
+
+	return r
+}
+
+// ruleName parses a zone name, e.g. "PST" or "<+0530>".
+func ruleName(s string) (name, rest string, ok bool) {
+	if strings.HasPrefix(s, "<") {
+		end := strings.Index(s, ">")
+		if end < 0 {
+			return
+		}
+		return s[1:end], s[end+1:], true
+	}
+
+	end := 0
+	for end < len(s) && (s[end] >= 'a' && s[end] <= 'z' || s[end] >= 'A' && s[end] <= 'Z') {
+		end += 1
+	}
+	if end < 3 {
+		return
+	}
+
+	return s[:end], s[end:], true
+}
+
+// ruleNumber parses a decimal number.
+func ruleNumber(s string) (n int, rest string, ok bool) {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end += 1
+	}
+	if end == 0 {
+		return
+	}
+
+	n, err := strconv.Atoi(s[:end])
+
+	return n, s[end:], err == nil
+}
+
+// ruleOffset parses a signed [+-]hh[:mm[:ss]] offset, in seconds.
+func ruleOffset(s string) (offset int, rest string, ok bool) {
+	sign := 1
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		if s[0] == '-' {
+			sign = -1
+		}
+		s = s[1:]
+	}
+
+	for _, unit := range []int{60 * 60, 60, 1} {
+		var n int
+		if n, s, ok = ruleNumber(s); !ok {
+			return
+		}
+		offset += n * unit
+		if unit == 1 || s == "" || s[0] != ':' {
+			break
+		}
+		s = s[1:]
+	}
+
+	return sign * offset, s, true
+}
+
+// ruleDateTime parses a date, e.g. "M3.2.0", "J60" or "59", optionally
+// followed by a time, e.g. "/2" (the default).
+func ruleDateTime(s string) (d ruleDate, rest string, ok bool) {
+	d.time = 2 * 60 * 60
+
+	switch {
+	case strings.HasPrefix(s, "J"):
+		d.kind = 'J'
+		if d.day, s, ok = ruleNumber(s[1:]); !ok || d.day < 1 || d.day > 365 {
+			return d, s, false
+		}
+	case strings.HasPrefix(s, "M"):
+		d.kind = 'M'
+		if d.month, s, ok = ruleNumber(s[1:]); !ok || d.month < 1 || d.month > 12 || !strings.HasPrefix(s, ".") {
+			return d, s, false
+		}
+		if d.week, s, ok = ruleNumber(s[1:]); !ok || d.week < 1 || d.week > 5 || !strings.HasPrefix(s, ".") {
+			return d, s, false
+		}
+		if d.day, s, ok = ruleNumber(s[1:]); !ok || d.day > 6 {
+			return d, s, false
+		}
+	default:
+		d.kind = 'D'
+		if d.day, s, ok = ruleNumber(s); !ok || d.day > 365 {
+			return d, s, false
+		}
+	}
+
+	if strings.HasPrefix(s, "/") {
+		if d.time, s, ok = ruleOffset(s[1:]); !ok {
+			return d, s, false
+		}
+	}
+
+	return d, s, true
+}
+
+// dayOf returns the day of the given year and month, counting from 1, in
+// seconds since the epoch.
+func dayOf(year int64, month, day int) int64 {
+	if month > 12 {
+		year, month = year+1, month-12
+	}
+
+	return (&time.Time{Year: year, Month: month, Day: day}).Seconds()
+}
+
+// isLeap returns whether year is a leap year.
+func isLeap(year int64) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+// at returns when the date falls in the given year, as local seconds since
+// the epoch, i.e. ignoring the offset from UTC.
+func (d ruleDate) at(year int64) int64 {
+	var day int64
+	switch d.kind {
+	case 'J':
+		// Julian days never count February 29.
+		day = dayOf(year, 1, 1) + int64(d.day-1)*secondsPerDay
+		if isLeap(year) && d.day >= 60 {
+			day += secondsPerDay
+		}
+	case 'D':
+		day = dayOf(year, 1, 1) + int64(d.day)*secondsPerDay
+	default:
+		// The given day of the week in the given week of the month, where
+		// week 5 is the last one. The epoch was a Thursday.
+		first := dayOf(year, d.month, 1)
+		weekday := int((first/secondsPerDay + 4) % 7)
+		if weekday < 0 {
+			weekday += 7
+		}
+		day = first + int64((d.day-weekday+7)%7+(d.week-1)*7)*secondsPerDay
+		for d.week == 5 && day >= dayOf(year, d.month+1, 1) {
+			day -= 7 * secondsPerDay
+		}
+	}
+
+	return day + int64(d.time)
+}
+
+// lookup returns the zone in effect at sec seconds since the epoch.
+func (r *zoneRule) lookup(sec int64) zone {
+	if !r.dst.isDst {
+		return r.std
+	}
+
+	year := time.SecondsToUTC(sec + int64(r.std.offset)).Year
+	start := r.start.at(year) - int64(r.std.offset)
+	end := r.end.at(year) - int64(r.dst.offset)
+
+	if start < end {
+		if sec >= start && sec < end {
+			return r.dst
+		}
+	} else if sec >= start || sec < end {
+		// Southern hemisphere.
+		return r.dst
+	}
+
+	return r.std
+}
+
+// lookup returns the zone in effect at sec seconds since the epoch.
+func (l *Location) lookup(sec int64) zone {
+	if len(l.transitions) == 0 || sec < l.transitions[0].when {
+		if len(l.transitions) == 0 && l.rule != nil {
+			return l.rule.lookup(sec)
+		}
+
+		// Use the first standard time zone.
+		for _, z := range l.zones {
+			if !z.isDst {
+				return z
+			}
+		}
+		return l.zones[0]
+	}
+
+	last := l.transitions[len(l.transitions)-1]
+	if sec >= last.when {
+		if l.rule != nil {
+			return l.rule.lookup(sec)
+		}
+		return l.zones[last.zone]
+	}
+
+	// Find the last transition at or before sec.
+	lo, hi := 0, len(l.transitions)-1
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if l.transitions[mid].when <= sec {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return l.zones[l.transitions[lo].zone]
+}
+
+// Time returns the local time in the location at sec seconds since the epoch.
+// A nil location is UTC.
+func (l *Location) Time(sec int64) *time.Time {
+	if l == nil {
+		return time.SecondsToUTC(sec)
+	}
+
+	z := l.lookup(sec)
+	t := time.SecondsToUTC(sec + int64(z.offset))
+	t.ZoneOffset = z.offset
+	t.Zone = z.name
+
+	return t
+}
+
+// In returns the same instant as t, in the location. A nil location returns
+// t as it is.
+func (l *Location) In(t *time.Time) *time.Time {
+	if l == nil || t == nil {
+		return t
+	}
+
+	return l.Time(t.Seconds())
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lwb
+
+import (
+	"testing"
+	"time"
+)
+
+var zoneTests = []struct {
+	seconds int64
+	zone    string
+	offset  int
+	local   string
+}{
+	// Before and after the switch to daylight saving time on 2011-03-13.
+	{1300010399, "PST", -8 * 3600, "2011-03-13 01:59:59"},
+	{1300010400, "PDT", -7 * 3600, "2011-03-13 03:00:00"},
+	{1304400826, "PDT", -7 * 3600, "2011-05-02 22:33:46"},
+	{1293840000, "PST", -8 * 3600, "2010-12-31 16:00:00"},
+
+	// After the last transition in the file, from the rule.
+	{1909080000, "PDT", -7 * 3600, "2030-06-30 13:00:00"},
+	{1922300000, "PST", -8 * 3600, "2030-11-30 12:13:20"},
+}
+
+const localFormat = "2006-01-02 15:04:05"
+
+func TestLoadLocation(t *testing.T) {
+	l, err := LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Logf("LoadLocation() failed, skipping: %s", err)
+		return
+	}
+
+	for _, zt := range zoneTests {
+		tm := l.Time(zt.seconds)
+		if tm.Zone != zt.zone || tm.ZoneOffset != zt.offset || tm.Format(localFormat) != zt.local {
+			t.Errorf("Time(%d) = %s %s %d want %s %s %d", zt.seconds,
+				tm.Format(localFormat), tm.Zone, tm.ZoneOffset, zt.local, zt.zone, zt.offset)
+		}
+		if tm.Seconds() != zt.seconds {
+			t.Errorf("Time(%d).Seconds() = %d", zt.seconds, tm.Seconds())
+		}
+	}
+
+	if _, err := LoadLocation("../etc/passwd"); err == nil {
+		t.Errorf("LoadLocation() accepted a relative path")
+	}
+}
+
+var ruleTests = []struct {
+	rule    string
+	seconds int64
+	zone    string
+}{
+	{"PST8PDT,M3.2.0,M11.1.0", 1909080000, "PDT"},
+	{"PST8PDT,M3.2.0,M11.1.0", 1922300000, "PST"},
+	{"PST8PDT", 1909080000, "PDT"},
+	{"<+0530>-5:30", 1909080000, "+0530"},
+	{"AEST-10AEDT,M10.1.0,M4.1.0/3", 1909080000, "AEST"},
+	{"AEST-10AEDT,M10.1.0,M4.1.0/3", 1922300000, "AEDT"},
+	{"CET-1CEST,J60,J300", 1909080000, "CEST"},
+	{"GMT0BST,M3.5.0/1,M10.5.0", 1922300000, "GMT"},
+}
+
+func TestZoneRule(t *testing.T) {
+	for _, rt := range ruleTests {
+		r := parseZoneRule(rt.rule)
+		if r == nil {
+			t.Errorf("parseZoneRule(%q) failed", rt.rule)
+			continue
+		}
+		if z := r.lookup(rt.seconds); z.name != rt.zone {
+			t.Errorf("%q at %d = %s want %s", rt.rule, rt.seconds, z.name, rt.zone)
+		}
+	}
+
+	for _, bad := range []string{"", "P8", "PST8PDT,M13.1.0,M11.1.0", "PST8PDT,M3.2.0"} {
+		if parseZoneRule(bad) != nil {
+			t.Errorf("parseZoneRule(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestNilLocation(t *testing.T) {
+	var l *Location
+	tm := time.SecondsToUTC(1304400826)
+	if l.In(tm) != tm {
+		t.Errorf("nil location changed the time")
+	}
+	if s := l.Time(1304400826).Format(localFormat); s != "2011-05-03 05:33:46" {
+		t.Errorf("Time() in a nil location = %s", s)
+	}
+}
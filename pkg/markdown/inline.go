@@ -0,0 +1,687 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package markdown
+
+import (
+	"bytes"
+	"strings"
+)
+
+// inline is a piece of rendered inline content. Runs of * and _ are kept as
+// delimiters until emphasis has been resolved.
+type inline struct {
+	html string
+
+	// Delimiter runs.
+	delim     byte
+	count     int
+	origCount int
+	canOpen   bool
+	canClose  bool
+
+	// Tags added by emphasis that starts or ends at this delimiter run.
+	openTags  string
+	closeTags string
+}
+
+func isAlnum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isPunct(c byte) bool {
+	return c < 0x80 && c > ' ' && c != 0x7f && !isAlnum(c)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// escapeHtml escapes text for use in html content or attribute values.
+func escapeHtml(text string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(text); i += 1 {
+		switch text[i] {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '>':
+			buf.WriteString("&gt;")
+		case '"':
+			buf.WriteString("&quot;")
+		default:
+			buf.WriteByte(text[i])
+		}
+	}
+
+	return buf.String()
+}
+
+// unescapeString removes backslash escapes.
+func unescapeString(text string) string {
+	if strings.Index(text, "\\") < 0 {
+		return text
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(text); i += 1 {
+		if text[i] == '\\' && i+1 < len(text) && isPunct(text[i+1]) {
+			i += 1
+		}
+		buf.WriteByte(text[i])
+	}
+
+	return buf.String()
+}
+
+// escapeUrl percent encodes the characters that aren't allowed in urls,
+// leaving any existing escapes alone.
+func escapeUrl(url string) string {
+	const hex = "0123456789ABCDEF"
+
+	var buf bytes.Buffer
+	for i := 0; i < len(url); i += 1 {
+		c := url[i]
+		if isAlnum(c) || strings.Index("-._~:/?#@!$&'()*+,;=%", url[i:i+1]) >= 0 {
+			buf.WriteByte(c)
+		} else {
+			buf.WriteByte('%')
+			buf.WriteByte(hex[c>>4])
+			buf.WriteByte(hex[c&15])
+		}
+	}
+
+	return buf.String()
+}
+
+// normalizeLabel returns the key for a link label, which is matched case
+// insensitively and ignoring differences in white space.
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// resolveUrl makes a link absolute if needed.
+func (p *parser) resolveUrl(url string) string {
+	if p.rootUrl != "" && strings.HasPrefix(url, "/") && !strings.HasPrefix(url, "//") {
+		url = p.rootUrl + url
+	}
+
+	return escapeHtml(escapeUrl(url))
+}
+
+// renderInline renders the inline content of a paragraph, heading or table
+// cell.
+func (p *parser) renderInline(text string) string {
+	var items []*inline
+	var buf bytes.Buffer
+
+	// flush turns the text gathered so far into an item.
+	flush := func() {
+		if buf.Len() > 0 {
+			items = append(items, &inline{html: buf.String()})
+			buf.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && text[i+1] == '\n':
+			buf.WriteString("<br />\n")
+			i += 2
+			i += indentation(text[i:])
+		case c == '\\' && i+1 < len(text) && isPunct(text[i+1]):
+			buf.WriteString(escapeHtml(text[i+1 : i+2]))
+			i += 2
+		case c == '`':
+			html, n := codeSpan(text[i:])
+			buf.WriteString(html)
+			i += n
+		case c == '*' || c == '_':
+			flush()
+			n := 1
+			for i+n < len(text) && text[i+n] == c {
+				n += 1
+			}
+			items = append(items, newDelimiter(text, i, n))
+			i += n
+		case c == '!' && i+1 < len(text) && text[i+1] == '[':
+			if html, n := p.link(text, i+1, true); n > 0 {
+				buf.WriteString(html)
+				i += n + 1
+			} else {
+				buf.WriteString("!")
+				i += 1
+			}
+		case c == '[':
+			if html, n := p.link(text, i, false); n > 0 {
+				buf.WriteString(html)
+				i += n
+			} else {
+				buf.WriteString("[")
+				i += 1
+			}
+		case c == '<':
+			if html, n := p.autolink(text[i:]); n > 0 {
+				buf.WriteString(html)
+				i += n
+			} else if n := htmlTag(text[i:]); n > 0 {
+				buf.WriteString(text[i : i+n])
+				i += n
+			} else {
+				buf.WriteString("&lt;")
+				i += 1
+			}
+		case c == '&':
+			if n := entity(text[i:]); n > 0 {
+				buf.WriteString(text[i : i+n])
+				i += n
+			} else {
+				buf.WriteString("&amp;")
+				i += 1
+			}
+		case c == '\n':
+			// Two or more trailing spaces make a hard line break.
+			line := buf.String()
+			trimmed := strings.TrimRight(line, " ")
+			buf.Reset()
+			buf.WriteString(trimmed)
+			if len(line)-len(trimmed) >= 2 {
+				buf.WriteString("<br />")
+			}
+			buf.WriteString("\n")
+			i += 1
+			i += indentation(text[i:])
+		default:
+			buf.WriteString(escapeHtml(text[i : i+1]))
+			i += 1
+		}
+	}
+	flush()
+
+	processEmphasis(items)
+
+	var out bytes.Buffer
+	for _, item := range items {
+		if item.delim != 0 {
+			out.WriteString(item.closeTags)
+			out.WriteString(strings.Repeat(string(item.delim), item.count))
+			out.WriteString(item.openTags)
+		} else {
+			out.WriteString(item.html)
+		}
+	}
+
+	return out.String()
+}
+
+// newDelimiter returns a delimiter run of n characters at text[i], working out
+// whether it can open or close emphasis.
+func newDelimiter(text string, i, n int) *inline {
+	before, after := byte(' '), byte(' ')
+	if i > 0 {
+		before = text[i-1]
+	}
+	if i+n < len(text) {
+		after = text[i+n]
+	}
+
+	leftFlanking := !isSpace(after) && (!isPunct(after) || isSpace(before) || isPunct(before))
+	rightFlanking := !isSpace(before) && (!isPunct(before) || isSpace(after) || isPunct(after))
+
+	d := &inline{delim: text[i], count: n, origCount: n}
+	if d.delim == '*' {
+		d.canOpen = leftFlanking
+		d.canClose = rightFlanking
+	} else {
+		d.canOpen = leftFlanking && (!rightFlanking || isPunct(before))
+		d.canClose = rightFlanking && (!leftFlanking || isPunct(after))
+	}
+
+	return d
+}
+
+// processEmphasis matches up delimiter runs, turning them into em and strong
+// tags.
+func processEmphasis(items []*inline) {
+	for closer := 0; closer < len(items); closer += 1 {
+		c := items[closer]
+		if c.delim == 0 || !c.canClose {
+			continue
+		}
+
+		for c.count > 0 {
+			// Look back for the nearest matching opener.
+			opener := -1
+			for j := closer - 1; j >= 0; j -= 1 {
+				o := items[j]
+				if o.delim != c.delim || !o.canOpen || o.count == 0 {
+					continue
+				}
+
+				// A run that can both open and close can't match if the
+				// lengths add up to a multiple of three.
+				if (o.canClose || c.canOpen) && (o.origCount+c.origCount)%3 == 0 &&
+					!(o.origCount%3 == 0 && c.origCount%3 == 0) {
+					continue
+				}
+
+				opener = j
+				break
+			}
+
+			if opener < 0 {
+				break
+			}
+
+			o := items[opener]
+			use := 1
+			tag := "em"
+			if o.count >= 2 && c.count >= 2 {
+				use = 2
+				tag = "strong"
+			}
+
+			o.count -= use
+			c.count -= use
+			o.openTags = "<" + tag + ">" + o.openTags
+			c.closeTags = c.closeTags + "</" + tag + ">"
+
+			// Delimiters in between can no longer match.
+			for j := opener + 1; j < closer; j += 1 {
+				if items[j].delim != 0 {
+					items[j].canOpen = false
+					items[j].canClose = false
+				}
+			}
+		}
+	}
+}
+
+// codeSpan renders the code span at the start of text, returning the html and
+// the length of the span. A run of backticks without a matching run is
+// literal.
+func codeSpan(text string) (string, int) {
+	n := 0
+	for n < len(text) && text[n] == '`' {
+		n += 1
+	}
+
+	for i := n; i < len(text); {
+		if text[i] != '`' {
+			i += 1
+			continue
+		}
+
+		m := 0
+		for i+m < len(text) && text[i+m] == '`' {
+			m += 1
+		}
+
+		if m == n {
+			code := strings.Replace(text[n:i], "\n", " ", -1)
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+				code = code[1 : len(code)-1]
+			}
+
+			return "<code>" + escapeHtml(code) + "</code>", i + m
+		}
+
+		i += m
+	}
+
+	return text[:n], n
+}
+
+// entity returns the length of the html entity at the start of text, or 0.
+func entity(text string) int {
+	end := strings.Index(text, ";")
+	if end < 2 || end > 32 {
+		return 0
+	}
+
+	name := text[1:end]
+	if name[0] == '#' {
+		name = name[1:]
+		if len(name) > 0 && (name[0] == 'x' || name[0] == 'X') {
+			name = name[1:]
+			if name == "" || strings.Trim(name, "0123456789abcdefABCDEF") != "" {
+				return 0
+			}
+		} else if name == "" || strings.Trim(name, "0123456789") != "" {
+			return 0
+		}
+	} else {
+		for i := 0; i < len(name); i += 1 {
+			if !isAlnum(name[i]) {
+				return 0
+			}
+		}
+	}
+
+	return end + 1
+}
+
+// autolink renders an autolink like <http://example.com> or
+// <foo@example.com> at the start of text.
+func (p *parser) autolink(text string) (string, int) {
+	end := strings.Index(text, ">")
+	if end < 0 {
+		return "", 0
+	}
+
+	target := text[1:end]
+	if target == "" || strings.IndexAny(target, " <\n") >= 0 {
+		return "", 0
+	}
+
+	// An absolute uri has a scheme of 2 to 32 characters.
+	if colon := strings.Index(target, ":"); colon >= 2 && colon <= 32 && isAlnum(target[0]) {
+		scheme := target[:colon]
+		if strings.Trim(strings.ToLower(scheme), "abcdefghijklmnopqrstuvwxyz0123456789+.-") == "" {
+			return "<a href=\"" + p.resolveUrl(target) + "\">" + escapeHtml(target) + "</a>", end + 1
+		}
+	}
+
+	if at := strings.Index(target, "@"); at > 0 && at < len(target)-1 && strings.Index(target[at+1:], ".") > 0 {
+		return "<a href=\"mailto:" + escapeHtml(escapeUrl(target)) + "\">" + escapeHtml(target) + "</a>", end + 1
+	}
+
+	return "", 0
+}
+
+// htmlTag returns the length of the raw html tag, comment or declaration at the
+// start of text, or 0.
+func htmlTag(text string) int {
+	if strings.HasPrefix(text, "<!--") {
+		if end := strings.Index(text[4:], "-->"); end >= 0 {
+			return end + 7
+		}
+		return 0
+	}
+
+	i := 1
+	closing := i < len(text) && text[i] == '/'
+	if closing {
+		i += 1
+	}
+
+	// Tag name.
+	start := i
+	for i < len(text) && (isAlnum(text[i]) || (i > start && text[i] == '-')) {
+		i += 1
+	}
+	if i == start || !((text[start] >= 'a' && text[start] <= 'z') || (text[start] >= 'A' && text[start] <= 'Z')) {
+		return 0
+	}
+
+	// Attributes.
+	for !closing {
+		spaces := i
+		for i < len(text) && isSpace(text[i]) {
+			i += 1
+		}
+		if i >= len(text) || text[i] == '>' || text[i] == '/' || i == spaces {
+			break
+		}
+
+		start = i
+		for i < len(text) && (isAlnum(text[i]) || strings.Index("_:.-", text[i:i+1]) >= 0) {
+			i += 1
+		}
+		if i == start {
+			return 0
+		}
+
+		// An optional value.
+		j := i
+		for j < len(text) && isSpace(text[j]) {
+			j += 1
+		}
+		if j < len(text) && text[j] == '=' {
+			j += 1
+			for j < len(text) && isSpace(text[j]) {
+				j += 1
+			}
+			if j >= len(text) {
+				return 0
+			}
+
+			if text[j] == '"' || text[j] == '\'' {
+				end := strings.Index(text[j+1:], text[j:j+1])
+				if end < 0 {
+					return 0
+				}
+				j += end + 2
+			} else {
+				start = j
+				for j < len(text) && !isSpace(text[j]) && strings.Index("\"'=<>`", text[j:j+1]) < 0 {
+					j += 1
+				}
+				if j == start {
+					return 0
+				}
+			}
+			i = j
+		}
+	}
+
+	for i < len(text) && isSpace(text[i]) {
+		i += 1
+	}
+	if !closing && i < len(text) && text[i] == '/' {
+		i += 1
+	}
+	if i >= len(text) || text[i] != '>' {
+		return 0
+	}
+
+	return i + 1
+}
+
+// linkLabelEnd returns the index of the ] that closes the [ at text[start],
+// skipping over escapes, code spans and nested brackets, or -1.
+func linkLabelEnd(text string, start int) int {
+	depth := 0
+	for i := start; i < len(text); i += 1 {
+		switch text[i] {
+		case '\\':
+			i += 1
+		case '`':
+			if _, n := codeSpan(text[i:]); n > 0 {
+				i += n - 1
+			}
+		case '[':
+			depth += 1
+		case ']':
+			depth -= 1
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// linkDestination parses a link destination at the start of text, returning
+// it and its length.
+func linkDestination(text string) (string, int) {
+	if strings.HasPrefix(text, "<") {
+		for i := 1; i < len(text); i += 1 {
+			switch text[i] {
+			case '\\':
+				i += 1
+			case '\n', '<':
+				return "", 0
+			case '>':
+				return unescapeString(text[1:i]), i + 1
+			}
+		}
+		return "", 0
+	}
+
+	depth := 0
+	i := 0
+	for ; i < len(text); i += 1 {
+		c := text[i]
+		if c == '\\' && i+1 < len(text) && isPunct(text[i+1]) {
+			i += 1
+		} else if c == '(' {
+			depth += 1
+		} else if c == ')' {
+			if depth == 0 {
+				break
+			}
+			depth -= 1
+		} else if c <= ' ' {
+			break
+		}
+	}
+
+	if depth != 0 {
+		return "", 0
+	}
+
+	return unescapeString(text[:i]), i
+}
+
+// linkTitle parses a link title in quotes or parentheses at the start of text,
+// returning it and its length.
+func linkTitle(text string) (string, int) {
+	if text == "" {
+		return "", 0
+	}
+
+	closing := text[0]
+	switch closing {
+	case '"', '\'':
+	case '(':
+		closing = ')'
+	default:
+		return "", 0
+	}
+
+	for i := 1; i < len(text); i += 1 {
+		switch text[i] {
+		case '\\':
+			i += 1
+		case closing:
+			return unescapeString(text[1:i]), i + 1
+		}
+	}
+
+	return "", 0
+}
+
+// link renders the link or image whose text starts with the [ at text[start],
+// returning the html and the length of the link from the [.
+func (p *parser) link(text string, start int, image bool) (string, int) {
+	end := linkLabelEnd(text, start)
+	if end < 0 {
+		return "", 0
+	}
+
+	label := text[start+1 : end]
+	rest := text[end+1:]
+
+	var url, title string
+	n := 0
+	found := false
+	if strings.HasPrefix(rest, "(") {
+		// Inline link.
+		i := 1 + len(rest[1:]) - len(strings.TrimLeft(rest[1:], " \n"))
+		dest, m := linkDestination(rest[i:])
+		if m > 0 || strings.HasPrefix(rest[i:], "<>") || (i < len(rest) && rest[i] == ')') {
+			if m == 0 && strings.HasPrefix(rest[i:], "<>") {
+				m = 2
+			}
+			i += m
+			j := i + len(rest[i:]) - len(strings.TrimLeft(rest[i:], " \n"))
+			if j > i {
+				if t, m := linkTitle(rest[j:]); m > 0 {
+					title = t
+					j += m
+					j += len(rest[j:]) - len(strings.TrimLeft(rest[j:], " \n"))
+				}
+			}
+
+			if j < len(rest) && rest[j] == ')' {
+				url = dest
+				n = j + 1
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		// Reference link: [text][label], [label][] or [label].
+		key := normalizeLabel(label)
+		if strings.HasPrefix(rest, "[") {
+			if refEnd := strings.Index(rest, "]"); refEnd >= 0 {
+				if refEnd > 1 {
+					key = normalizeLabel(rest[1:refEnd])
+				}
+				n = refEnd + 1
+			}
+		}
+
+		ref, ok := p.refs[key]
+		if !ok {
+			return "", 0
+		}
+
+		url, title = ref.url, ref.title
+	}
+
+	content := p.renderInline(label)
+
+	var buf bytes.Buffer
+	if image {
+		buf.WriteString("<img src=\"" + p.resolveUrl(url) + "\" alt=\"" + escapeHtml(stripTags(content)) + "\"")
+		if title != "" {
+			buf.WriteString(" title=\"" + escapeHtml(title) + "\"")
+		}
+		buf.WriteString(" />")
+	} else {
+		buf.WriteString("<a href=\"" + p.resolveUrl(url) + "\"")
+		if title != "" {
+			buf.WriteString(" title=\"" + escapeHtml(title) + "\"")
+		}
+		buf.WriteString(">" + content + "</a>")
+	}
+
+	return buf.String(), end - start + 1 + n
+}
+
+// stripTags removes the tags from rendered html, for image descriptions.
+func stripTags(html string) string {
+	var buf bytes.Buffer
+	inTag := false
+	for i := 0; i < len(html); i += 1 {
+		switch {
+		case html[i] == '<':
+			inTag = true
+		case html[i] == '>' && inTag:
+			inTag = false
+		case !inTag:
+			buf.WriteByte(html[i])
+		}
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,921 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package markdown renders Markdown (http://commonmark.org) as HTML. It
+// follows the CommonMark spec for the common constructs, along with the GitHub
+// table extension.
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The kinds of block.
+const (
+	blockParagraph = iota
+	blockHeading
+	blockRule
+	blockCode
+	blockHtml
+	blockQuote
+	blockList
+	blockItem
+	blockTable
+)
+
+// block is a node in the block structure of a document.
+type block struct {
+	kind int
+
+	// Whether a blank line came before this block within its parent.
+	blankBefore bool
+
+	// The text of a paragraph, heading or code block, or the raw html.
+	text string
+
+	// The heading level.
+	level int
+
+	// The info string of a fenced code block.
+	info string
+
+	// The children of a block quote, list or list item.
+	children []*block
+
+	// Lists.
+	ordered bool
+	start   int
+	tight   bool
+
+	// Tables.
+	align []string
+	rows  [][]string
+}
+
+// linkRef is the target of a link reference definition.
+type linkRef struct {
+	url   string
+	title string
+}
+
+// parser holds the state used while converting a document.
+type parser struct {
+	refs    map[string]linkRef
+	rootUrl string
+}
+
+// Render writes src as HTML. If rootUrl isn't empty, it's prepended to any
+// links that start with a /.
+func Render(w io.Writer, src []byte, rootUrl string) {
+	p := &parser{refs: make(map[string]linkRef), rootUrl: rootUrl}
+	blocks := p.parseBlocks(splitLines(string(src)))
+
+	out := &htmlWriter{w: w}
+	p.renderBlocks(out, blocks, false)
+}
+
+// MarkdownFormatter formats arbitrary values as Markdown.
+func MarkdownFormatter(w io.Writer, format string, value ...interface{}) {
+	formatter(w, format, "", value...)
+}
+
+// GetMarkdownFullLinkFormatter returns a formatter that formats arbitrary
+// values as Markdown. Its only difference to MarkdownFormatter is that any
+// relative links are converted to absolute links.
+func GetMarkdownFullLinkFormatter(root_url string) func(io.Writer, string, ...interface{}) {
+	return func(w io.Writer, format string, value ...interface{}) {
+		formatter(w, format, root_url, value...)
+	}
+}
+
+func formatter(w io.Writer, format string, root_url string, value ...interface{}) {
+	ok := false
+	var b []byte
+	if len(value) == 1 {
+		b, ok = value[0].([]byte)
+	}
+	if !ok {
+		var buf bytes.Buffer
+		fmt.Fprint(&buf, value...)
+		b = buf.Bytes()
+	}
+
+	Render(w, b, root_url)
+}
+
+// splitLines splits text into lines, expanding tabs to the next multiple of
+// four columns.
+func splitLines(text string) (lines []string) {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return
+	}
+
+	for start := 0; start <= len(text); {
+		end := strings.Index(text[start:], "\n")
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += start
+		}
+
+		lines = append(lines, expandTabs(text[start:end]))
+		start = end + 1
+	}
+
+	return
+}
+
+func expandTabs(line string) string {
+	if strings.Index(line, "\t") < 0 {
+		return line
+	}
+
+	var buf bytes.Buffer
+	column := 0
+	for i := 0; i < len(line); i += 1 {
+		if line[i] == '\t' {
+			n := 4 - column%4
+			buf.WriteString(strings.Repeat(" ", n))
+			column += n
+		} else {
+			buf.WriteByte(line[i])
+			column += 1
+		}
+	}
+
+	return buf.String()
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// indentation returns the number of leading spaces in line.
+func indentation(line string) int {
+	n := 0
+	for n < len(line) && line[n] == ' ' {
+		n += 1
+	}
+
+	return n
+}
+
+// unindent removes up to n leading spaces from line.
+func unindent(line string, n int) string {
+	i := 0
+	for i < n && i < len(line) && line[i] == ' ' {
+		i += 1
+	}
+
+	return line[i:]
+}
+
+// isRule returns whether line is a thematic break.
+func isRule(line string) bool {
+	if indentation(line) > 3 {
+		return false
+	}
+
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || (line[0] != '*' && line[0] != '-' && line[0] != '_') {
+		return false
+	}
+
+	count := 0
+	for i := 0; i < len(line); i += 1 {
+		switch line[i] {
+		case line[0]:
+			count += 1
+		case ' ':
+		default:
+			return false
+		}
+	}
+
+	return count >= 3
+}
+
+// atxHeading returns the level and text of an ATX heading, or 0 if line isn't
+// one.
+func atxHeading(line string) (level int, text string) {
+	if indentation(line) > 3 {
+		return 0, ""
+	}
+
+	line = strings.TrimLeft(line, " ")
+	for level < len(line) && line[level] == '#' {
+		level += 1
+	}
+	if level == 0 || level > 6 || (level < len(line) && line[level] != ' ') {
+		return 0, ""
+	}
+
+	text = strings.TrimSpace(line[level:])
+
+	// Remove any closing sequence.
+	end := len(text)
+	for end > 0 && text[end-1] == '#' {
+		end -= 1
+	}
+	if end == 0 {
+		text = ""
+	} else if text[end-1] == ' ' {
+		text = strings.TrimSpace(text[:end])
+	}
+
+	return level, text
+}
+
+// setextUnderline returns the heading level underlined by line, or 0.
+func setextUnderline(line string) int {
+	if indentation(line) > 3 {
+		return 0
+	}
+
+	line = strings.TrimSpace(line)
+	if line == "" || strings.Trim(line, "=") == "" {
+		if line == "" {
+			return 0
+		}
+		return 1
+	}
+	if strings.Trim(line, "-") == "" {
+		return 2
+	}
+
+	return 0
+}
+
+// codeFence returns the fence and info string if line opens a fenced code
+// block.
+func codeFence(line string) (fence string, info string, indent int) {
+	indent = indentation(line)
+	if indent > 3 {
+		return "", "", 0
+	}
+
+	line = line[indent:]
+	n := 0
+	for n < len(line) && (line[n] == '`' || line[n] == '~') && line[n] == line[0] {
+		n += 1
+	}
+	if n < 3 {
+		return "", "", 0
+	}
+
+	info = strings.TrimSpace(line[n:])
+	if line[0] == '`' && strings.Index(info, "`") >= 0 {
+		return "", "", 0
+	}
+
+	return line[:n], info, indent
+}
+
+// isClosingFence returns whether line closes a code block opened with fence.
+func isClosingFence(line, fence string) bool {
+	if indentation(line) > 3 {
+		return false
+	}
+
+	line = strings.TrimSpace(line)
+
+	return strings.HasPrefix(line, fence) && strings.Trim(line, fence[:1]) == ""
+}
+
+// listMarker parses a list item marker at the start of line. It returns the
+// width of the marker and the spaces after it that make up the indentation of
+// the item's content, or 0 if line doesn't start a list item.
+func listMarker(line string) (width int, ordered bool, delimiter byte, start int) {
+	indent := indentation(line)
+	if indent > 3 {
+		return 0, false, 0, 0
+	}
+
+	i := indent
+	if i < len(line) && (line[i] == '-' || line[i] == '+' || line[i] == '*') {
+		delimiter = line[i]
+		i += 1
+	} else {
+		digits := 0
+		for i < len(line) && line[i] >= '0' && line[i] <= '9' && digits < 9 {
+			i += 1
+			digits += 1
+		}
+		if digits == 0 || i >= len(line) || (line[i] != '.' && line[i] != ')') {
+			return 0, false, 0, 0
+		}
+
+		start, _ = strconv.Atoi(line[indent:i])
+		ordered = true
+		delimiter = line[i]
+		i += 1
+	}
+
+	if i < len(line) && line[i] != ' ' {
+		return 0, false, 0, 0
+	}
+
+	// The content starts after the spaces following the marker, unless there
+	// are too many of them, in which case it is indented code.
+	spaces := indentation(line[i:])
+	if spaces == 0 || spaces > 4 || i+spaces == len(line) {
+		spaces = 1
+	}
+
+	return i + spaces, ordered, delimiter, start
+}
+
+// The tags that start an html block that ends at a blank line.
+var htmlBlockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "base": true,
+	"basefont": true, "blockquote": true, "body": true, "caption": true,
+	"center": true, "col": true, "colgroup": true, "dd": true, "details": true,
+	"dialog": true, "dir": true, "div": true, "dl": true, "dt": true,
+	"fieldset": true, "figcaption": true, "figure": true, "footer": true,
+	"form": true, "frame": true, "frameset": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "head": true,
+	"header": true, "hr": true, "html": true, "iframe": true, "legend": true,
+	"li": true, "link": true, "main": true, "menu": true, "menuitem": true,
+	"nav": true, "noframes": true, "ol": true, "optgroup": true,
+	"option": true, "p": true, "param": true, "section": true,
+	"source": true, "summary": true, "table": true, "tbody": true,
+	"td": true, "tfoot": true, "th": true, "thead": true, "title": true,
+	"tr": true, "track": true, "ul": true,
+}
+
+// The tags that start an html block that ends at the matching closing tag.
+var htmlRawTags = []string{"script", "pre", "style", "textarea"}
+
+// htmlBlockStart returns the text that ends an html block starting at line,
+// "" if it ends at a blank line, or ok = false if line doesn't start one. Html
+// blocks made up of a single arbitrary tag can't interrupt a paragraph.
+func htmlBlockStart(line string, inParagraph bool) (end string, ok bool) {
+	if indentation(line) > 3 {
+		return "", false
+	}
+
+	line = strings.TrimLeft(line, " ")
+	if !strings.HasPrefix(line, "<") {
+		return "", false
+	}
+
+	lower := strings.ToLower(line)
+	for _, tag := range htmlRawTags {
+		if strings.HasPrefix(lower, "<"+tag) {
+			rest := lower[len(tag)+1:]
+			if rest == "" || rest[0] == ' ' || rest[0] == '>' {
+				return "</" + tag + ">", true
+			}
+		}
+	}
+
+	if strings.HasPrefix(line, "<!--") {
+		return "-->", true
+	}
+
+	name := lower[1:]
+	if strings.HasPrefix(name, "/") {
+		name = name[1:]
+	}
+	n := 0
+	for n < len(name) && isAlnum(name[n]) {
+		n += 1
+	}
+	if n > 0 && htmlBlockTags[name[:n]] {
+		rest := name[n:]
+		if rest == "" || rest[0] == ' ' || rest[0] == '>' || strings.HasPrefix(rest, "/>") {
+			return "", true
+		}
+	}
+
+	if !inParagraph {
+		if tagLen := htmlTag(line); tagLen > 0 && isBlank(line[tagLen:]) && !strings.HasPrefix(line, "<!") {
+			return "", true
+		}
+	}
+
+	return "", false
+}
+
+// interruptsParagraph returns whether line starts a block that ends a
+// paragraph.
+func interruptsParagraph(line string) bool {
+	if isRule(line) {
+		return true
+	}
+	if level, _ := atxHeading(line); level > 0 {
+		return true
+	}
+	if fence, _, _ := codeFence(line); fence != "" {
+		return true
+	}
+	if indentation(line) <= 3 && strings.HasPrefix(strings.TrimLeft(line, " "), ">") {
+		return true
+	}
+	if _, ok := htmlBlockStart(line, true); ok {
+		return true
+	}
+
+	// Only lists starting with 1 and that aren't empty can interrupt.
+	if width, ordered, _, start := listMarker(line); width > 0 && !isBlank(line[width-1:]) {
+		return !ordered || start == 1
+	}
+
+	return false
+}
+
+// parseBlocks parses lines into a sequence of blocks.
+func (p *parser) parseBlocks(lines []string) (blocks []*block) {
+	blank := false
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if isBlank(line) {
+			blank = true
+			i += 1
+			continue
+		}
+
+		var b *block
+		b, i = p.parseBlock(lines, i)
+		if b != nil {
+			b.blankBefore = blank && len(blocks) > 0
+			blocks = append(blocks, b)
+		}
+		blank = false
+	}
+
+	return
+}
+
+// parseBlock parses the block starting at lines[i], returning it and the index
+// of the line following it. The block may be nil if the lines only held link
+// reference definitions.
+func (p *parser) parseBlock(lines []string, i int) (*block, int) {
+	line := lines[i]
+	indent := indentation(line)
+
+	// Indented code.
+	if indent >= 4 {
+		var code []string
+		end := i
+		for ; i < len(lines) && (isBlank(lines[i]) || indentation(lines[i]) >= 4); i += 1 {
+			code = append(code, unindent(lines[i], 4))
+			if !isBlank(lines[i]) {
+				end = i
+			}
+		}
+
+		code = code[:len(code)-(i-end-1)]
+
+		return &block{kind: blockCode, text: strings.Join(code, "\n") + "\n"}, end + 1
+	}
+
+	// Fenced code.
+	if fence, info, fenceIndent := codeFence(line); fence != "" {
+		var code []string
+		for i += 1; i < len(lines) && !isClosingFence(lines[i], fence); i += 1 {
+			code = append(code, unindent(lines[i], fenceIndent))
+		}
+
+		text := strings.Join(code, "\n")
+		if len(code) > 0 {
+			text += "\n"
+		}
+
+		return &block{kind: blockCode, text: text, info: unescapeString(info)}, i + 1
+	}
+
+	if isRule(line) {
+		return &block{kind: blockRule}, i + 1
+	}
+
+	if level, text := atxHeading(line); level > 0 {
+		return &block{kind: blockHeading, level: level, text: text}, i + 1
+	}
+
+	// Block quotes.
+	if indent <= 3 && line[indent] == '>' {
+		var quoted []string
+		lazy := false
+		for ; i < len(lines); i += 1 {
+			line = lines[i]
+			indent = indentation(line)
+			if indent <= 3 && indent < len(line) && line[indent] == '>' {
+				line = line[indent+1:]
+				if strings.HasPrefix(line, " ") {
+					line = line[1:]
+				}
+				quoted = append(quoted, line)
+
+				// Only paragraphs have lazy continuation lines.
+				fence, _, _ := codeFence(line)
+				lazy = !isBlank(line) && indentation(line) < 4 && fence == "" && !isRule(line)
+			} else if lazy && !isBlank(line) && !interruptsParagraph(line) {
+				quoted = append(quoted, line)
+			} else {
+				break
+			}
+		}
+
+		return &block{kind: blockQuote, children: p.parseBlocks(quoted)}, i
+	}
+
+	// Html.
+	if end, ok := htmlBlockStart(line, false); ok {
+		var html []string
+		for ; i < len(lines); i += 1 {
+			if end == "" && isBlank(lines[i]) {
+				break
+			}
+
+			html = append(html, lines[i])
+			if end != "" && strings.Index(strings.ToLower(lines[i]), end) >= 0 {
+				i += 1
+				break
+			}
+		}
+
+		return &block{kind: blockHtml, text: strings.Join(html, "\n")}, i
+	}
+
+	if width, _, _, _ := listMarker(line); width > 0 {
+		return p.parseList(lines, i)
+	}
+
+	if i+1 < len(lines) {
+		if header := splitTableRow(line); len(header) > 0 {
+			if align := tableAlignment(lines[i+1]); len(align) == len(header) {
+				return p.parseTable(lines, i, header, align)
+			}
+		}
+	}
+
+	return p.parseParagraph(lines, i)
+}
+
+// parseList parses a list starting at lines[i].
+func (p *parser) parseList(lines []string, i int) (*block, int) {
+	_, ordered, delimiter, start := listMarker(lines[i])
+	list := &block{kind: blockList, ordered: ordered, start: start, tight: true}
+
+	for i < len(lines) {
+		width, itemOrdered, itemDelimiter, _ := listMarker(lines[i])
+		if width == 0 || itemOrdered != ordered || itemDelimiter != delimiter || isRule(lines[i]) {
+			break
+		}
+
+		// Gather the lines of the item, removing its indentation.
+		item := []string{""}
+		if width < len(lines[i]) {
+			item[0] = lines[i][width:]
+		}
+		lazy := !isBlank(item[0])
+		if !lazy && len(item[0]) == 0 && i+1 < len(lines) && isBlank(lines[i+1]) {
+			// An item can begin with at most one blank line.
+			i += 1
+		} else {
+			for i += 1; i < len(lines); i += 1 {
+				line := lines[i]
+				if isBlank(line) {
+					item = append(item, "")
+					lazy = false
+				} else if indentation(line) >= width {
+					item = append(item, line[width:])
+					lazy = true
+				} else if w, _, _, _ := listMarker(line); w > 0 {
+					break
+				} else if lazy && !interruptsParagraph(line) && !isRule(line) {
+					item = append(item, line)
+				} else {
+					break
+				}
+			}
+		}
+
+		// Trailing blank lines separate this item from the next.
+		blankAfter := false
+		for len(item) > 1 && isBlank(item[len(item)-1]) {
+			item = item[:len(item)-1]
+			blankAfter = true
+		}
+
+		children := p.parseBlocks(item)
+		for _, child := range children {
+			if child.blankBefore {
+				list.tight = false
+			}
+		}
+
+		if blankAfter && i < len(lines) {
+			if width, _, _, _ := listMarker(lines[i]); width > 0 {
+				list.tight = false
+			}
+		}
+
+		list.children = append(list.children, &block{kind: blockItem, children: children})
+	}
+
+	return list, i
+}
+
+// parseParagraph parses a paragraph starting at lines[i], which may turn out
+// to be a setext heading or a run of link reference definitions.
+func (p *parser) parseParagraph(lines []string, i int) (*block, int) {
+	text := []string{strings.TrimLeft(lines[i], " ")}
+	for i += 1; i < len(lines); i += 1 {
+		line := lines[i]
+		if level := setextUnderline(line); level > 0 {
+			if content := p.parseLinkRefs(strings.Join(text, "\n")); content != "" {
+				return &block{kind: blockHeading, level: level, text: strings.TrimSpace(content)}, i + 1
+			}
+		}
+
+		if isBlank(line) || interruptsParagraph(line) {
+			break
+		}
+
+		text = append(text, strings.TrimLeft(line, " "))
+	}
+
+	content := p.parseLinkRefs(strings.Join(text, "\n"))
+	if content == "" {
+		return nil, i
+	}
+
+	return &block{kind: blockParagraph, text: strings.TrimRight(content, " ")}, i
+}
+
+// parseLinkRefs consumes any link reference definitions at the start of a
+// paragraph, returning the rest of it.
+func (p *parser) parseLinkRefs(text string) string {
+	for strings.HasPrefix(text, "[") {
+		end := strings.Index(text, "]:")
+		if end < 0 {
+			break
+		}
+
+		label := normalizeLabel(text[1:end])
+		if label == "" || strings.Index(text[1:end], "[") >= 0 {
+			break
+		}
+
+		rest := strings.TrimLeft(text[end+2:], " \n")
+		url, n := linkDestination(rest)
+		if n == 0 && !strings.HasPrefix(rest, "<>") {
+			break
+		}
+		rest = rest[n:]
+
+		// An optional title, which must be followed by the end of the line.
+		title := ""
+		afterUrl := rest
+		trimmed := strings.TrimLeft(rest, " \n")
+		if len(trimmed) < len(rest) {
+			if t, m := linkTitle(trimmed); m > 0 {
+				if lineEnd := restOfLine(trimmed[m:]); isBlank(lineEnd) {
+					title = t
+					rest = trimmed[m+len(lineEnd):]
+				}
+			}
+		}
+		if rest == afterUrl {
+			lineEnd := restOfLine(rest)
+			if !isBlank(lineEnd) {
+				break
+			}
+			rest = rest[len(lineEnd):]
+		}
+
+		if _, found := p.refs[label]; !found {
+			p.refs[label] = linkRef{url, title}
+		}
+		text = strings.TrimLeft(rest, "\n")
+	}
+
+	return text
+}
+
+// restOfLine returns text up to and including the next newline.
+func restOfLine(text string) string {
+	if end := strings.Index(text, "\n"); end >= 0 {
+		return text[:end+1]
+	}
+
+	return text
+}
+
+// parseTable parses a table with the given header starting at lines[i].
+func (p *parser) parseTable(lines []string, i int, header, align []string) (*block, int) {
+	table := &block{kind: blockTable, align: align, rows: [][]string{header}}
+	for i += 2; i < len(lines); i += 1 {
+		line := lines[i]
+		if isBlank(line) || interruptsParagraph(line) {
+			break
+		}
+
+		cells := splitTableRow(line)
+		row := make([]string, len(header))
+		copy(row, cells)
+		table.rows = append(table.rows, row)
+	}
+
+	return table, i
+}
+
+// splitTableRow splits a table row into trimmed cells, or returns nil if line
+// isn't a row.
+func splitTableRow(line string) (cells []string) {
+	if strings.Index(line, "|") < 0 || indentation(line) > 3 {
+		return nil
+	}
+
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "|") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+
+	var cell bytes.Buffer
+	for i := 0; i < len(line); i += 1 {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i += 1
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// tableAlignment parses a table delimiter row, returning the alignment of each
+// column, or nil if line isn't a delimiter row.
+func tableAlignment(line string) (align []string) {
+	cells := splitTableRow(line)
+	for _, cell := range cells {
+		left := strings.HasPrefix(cell, ":")
+		right := strings.HasSuffix(cell, ":")
+		if strings.Trim(cell, ":") == "" || strings.Trim(cell, ":-") != "" {
+			return nil
+		}
+
+		switch {
+		case left && right:
+			align = append(align, "center")
+		case left:
+			align = append(align, "left")
+		case right:
+			align = append(align, "right")
+		default:
+			align = append(align, "")
+		}
+	}
+
+	return
+}
+
+// htmlWriter tracks whether the output is at the start of a line, so that
+// blocks can start on a new line without adding blank lines.
+type htmlWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (hw *htmlWriter) WriteString(s string) {
+	if len(s) > 0 {
+		io.WriteString(hw.w, s)
+		hw.last = s[len(s)-1]
+	}
+}
+
+// cr starts a new line unless already at the start of one.
+func (hw *htmlWriter) cr() {
+	if hw.last != 0 && hw.last != '\n' {
+		hw.WriteString("\n")
+	}
+}
+
+func (p *parser) renderBlocks(out *htmlWriter, blocks []*block, tight bool) {
+	for _, b := range blocks {
+		p.renderBlock(out, b, tight)
+	}
+}
+
+func (p *parser) renderBlock(out *htmlWriter, b *block, tight bool) {
+	switch b.kind {
+	case blockParagraph:
+		if tight {
+			out.WriteString(p.renderInline(b.text))
+		} else {
+			out.cr()
+			out.WriteString("<p>" + p.renderInline(b.text) + "</p>")
+			out.cr()
+		}
+	case blockHeading:
+		tag := "h" + strconv.Itoa(b.level)
+		out.cr()
+		out.WriteString("<" + tag + ">" + p.renderInline(b.text) + "</" + tag + ">")
+		out.cr()
+	case blockRule:
+		out.cr()
+		out.WriteString("<hr />")
+		out.cr()
+	case blockCode:
+		out.cr()
+		out.WriteString("<pre><code")
+		if b.info != "" {
+			language := strings.Fields(b.info)[0]
+			out.WriteString(" class=\"language-" + escapeHtml(language) + "\"")
+		}
+		out.WriteString(">" + escapeHtml(b.text) + "</code></pre>")
+		out.cr()
+	case blockHtml:
+		out.cr()
+		out.WriteString(b.text)
+		out.cr()
+	case blockQuote:
+		out.cr()
+		out.WriteString("<blockquote>\n")
+		p.renderBlocks(out, b.children, false)
+		out.cr()
+		out.WriteString("</blockquote>")
+		out.cr()
+	case blockList:
+		tag := "ul"
+		out.cr()
+		if b.ordered {
+			tag = "ol"
+			if b.start != 1 {
+				out.WriteString("<ol start=\"" + strconv.Itoa(b.start) + "\">\n")
+			} else {
+				out.WriteString("<ol>\n")
+			}
+		} else {
+			out.WriteString("<ul>\n")
+		}
+		for _, item := range b.children {
+			out.WriteString("<li>")
+			p.renderBlocks(out, item.children, b.tight)
+			out.WriteString("</li>")
+			out.cr()
+		}
+		out.WriteString("</" + tag + ">")
+		out.cr()
+	case blockTable:
+		out.cr()
+		out.WriteString("<table>\n<thead>\n")
+		for i, row := range b.rows {
+			cell := "td"
+			if i == 0 {
+				cell = "th"
+			} else if i == 1 {
+				out.WriteString("<tbody>\n")
+			}
+
+			out.WriteString("<tr>\n")
+			for j, text := range row {
+				out.WriteString("<" + cell)
+				if b.align[j] != "" {
+					out.WriteString(" align=\"" + b.align[j] + "\"")
+				}
+				out.WriteString(">" + p.renderInline(text) + "</" + cell + ">\n")
+			}
+			out.WriteString("</tr>\n")
+
+			if i == 0 {
+				out.WriteString("</thead>\n")
+			}
+		}
+		if len(b.rows) > 1 {
+			out.WriteString("</tbody>\n")
+		}
+		out.WriteString("</table>")
+		out.cr()
+	}
+}
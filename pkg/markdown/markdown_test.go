@@ -0,0 +1,200 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package markdown
+
+import (
+	"bytes"
+	"testing"
+)
+
+var blockTests = []struct {
+	in  string
+	out string
+}{
+	{"", ""},
+	{"Hello", "<p>Hello</p>\n"},
+	{"Hello\nthere", "<p>Hello\nthere</p>\n"},
+	{"Hello\n\nthere", "<p>Hello</p>\n<p>there</p>\n"},
+	{"  Hello  ", "<p>Hello</p>\n"},
+
+	// Headings.
+	{"# Hello", "<h1>Hello</h1>\n"},
+	{"### Hello ###", "<h3>Hello</h3>\n"},
+	{"####### Hello", "<p>####### Hello</p>\n"},
+	{"#Hello", "<p>#Hello</p>\n"},
+	{"# Hello #world", "<h1>Hello #world</h1>\n"},
+	{"Hello\n=====", "<h1>Hello</h1>\n"},
+	{"Hello\nthere\n---", "<h2>Hello\nthere</h2>\n"},
+
+	// Thematic breaks.
+	{"***", "<hr />\n"},
+	{"- - -", "<hr />\n"},
+	{"Hello\n\n___\n", "<p>Hello</p>\n<hr />\n"},
+	{"--", "<p>--</p>\n"},
+
+	// Code.
+	{"    code\n    more", "<pre><code>code\nmore\n</code></pre>\n"},
+	{"    a\n\n    b\n\n", "<pre><code>a\n\nb\n</code></pre>\n"},
+	{"Hello\n    there", "<p>Hello\nthere</p>\n"},
+	{"```\n<a> & b\n```", "<pre><code>&lt;a&gt; &amp; b\n</code></pre>\n"},
+	{"```go\nfunc main() {}\n```", "<pre><code class=\"language-go\">func main() {}\n</code></pre>\n"},
+	{"~~~~\ncode\n~~~\n~~~~", "<pre><code>code\n~~~\n</code></pre>\n"},
+	{"```\nunclosed", "<pre><code>unclosed\n</code></pre>\n"},
+	{"  ```\n  indented\n   more\n  ```", "<pre><code>indented\n more\n</code></pre>\n"},
+	{"Hello\n```\ncode\n```", "<p>Hello</p>\n<pre><code>code\n</code></pre>\n"},
+
+	// Block quotes.
+	{"> Hello", "<blockquote>\n<p>Hello</p>\n</blockquote>\n"},
+	{"> Hello\nthere", "<blockquote>\n<p>Hello\nthere</p>\n</blockquote>\n"},
+	{"> # Hi\n> there", "<blockquote>\n<h1>Hi</h1>\n<p>there</p>\n</blockquote>\n"},
+	{"> a\n>\n> b", "<blockquote>\n<p>a</p>\n<p>b</p>\n</blockquote>\n"},
+	{"> a\n\n> b", "<blockquote>\n<p>a</p>\n</blockquote>\n<blockquote>\n<p>b</p>\n</blockquote>\n"},
+	{"> > nested", "<blockquote>\n<blockquote>\n<p>nested</p>\n</blockquote>\n</blockquote>\n"},
+
+	// Lists.
+	{"- a\n- b", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n"},
+	{"- a\n\n- b", "<ul>\n<li>\n<p>a</p>\n</li>\n<li>\n<p>b</p>\n</li>\n</ul>\n"},
+	{"1. a\n2. b", "<ol>\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+	{"3) a\n4) b", "<ol start=\"3\">\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+	{"- a\n  - b\n- c", "<ul>\n<li>a\n<ul>\n<li>b</li>\n</ul>\n</li>\n<li>c</li>\n</ul>\n"},
+	{"- a\n+ b", "<ul>\n<li>a</li>\n</ul>\n<ul>\n<li>b</li>\n</ul>\n"},
+	{"- a\n\n  more\n- b", "<ul>\n<li>\n<p>a</p>\n<p>more</p>\n</li>\n<li>\n<p>b</p>\n</li>\n</ul>\n"},
+	{"- a\nlazy", "<ul>\n<li>a\nlazy</li>\n</ul>\n"},
+	{"-\n  foo\n-\n  ```\n  bar\n  ```", "<ul>\n<li>foo</li>\n<li>\n<pre><code>bar\n</code></pre>\n</li>\n</ul>\n"},
+	{"- a\n-\n- c", "<ul>\n<li>a</li>\n<li></li>\n<li>c</li>\n</ul>\n"},
+	{"Hello\n2. not a list", "<p>Hello\n2. not a list</p>\n"},
+	{"Hello\n- a list", "<p>Hello</p>\n<ul>\n<li>a list</li>\n</ul>\n"},
+	{"- ```\n  code\n  ```", "<ul>\n<li>\n<pre><code>code\n</code></pre>\n</li>\n</ul>\n"},
+	{"* * *\n- a", "<hr />\n<ul>\n<li>a</li>\n</ul>\n"},
+	{"1.  a\n\n    b", "<ol>\n<li>\n<p>a</p>\n<p>b</p>\n</li>\n</ol>\n"},
+
+	// Html.
+	{"<div>\n*hi*\n</div>", "<div>\n*hi*\n</div>\n"},
+	{"<div>\n\n*hi*\n\n</div>", "<div>\n<p><em>hi</em></p>\n</div>\n"},
+	{"<!-- comment\n\nstill -->\nafter", "<!-- comment\n\nstill -->\n<p>after</p>\n"},
+	{"<script>\nvar x;\n\n</script>", "<script>\nvar x;\n\n</script>\n"},
+	{"<span>inline</span> text", "<p><span>inline</span> text</p>\n"},
+
+	// Tables.
+	{"| a | b |\n|---|:-:|\n| 1 | 2 |\n| 3 |",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th align=\"center\">b</th>\n</tr>\n</thead>\n<tbody>\n" +
+			"<tr>\n<td>1</td>\n<td align=\"center\">2</td>\n</tr>\n<tr>\n<td>3</td>\n<td align=\"center\"></td>\n</tr>\n</tbody>\n</table>\n"},
+	{"a | b\n:-- | --:", "<table>\n<thead>\n<tr>\n<th align=\"left\">a</th>\n<th align=\"right\">b</th>\n</tr>\n</thead>\n</table>\n"},
+	{"| a | b |\n|---|\n", "<p>| a | b |\n|---|</p>\n"},
+	{"| `a\\|b` | *c* |\n|-|-|", "<table>\n<thead>\n<tr>\n<th><code>a|b</code></th>\n<th><em>c</em></th>\n</tr>\n</thead>\n</table>\n"},
+
+	// Link reference definitions.
+	{"[foo]: /url \"title\"\n\n[foo]", "<p><a href=\"/url\" title=\"title\">foo</a></p>\n"},
+	{"[Foo Bar]:\n<my url>\n'title'\n\n[foo bar][]", "<p><a href=\"my%20url\" title=\"title\">foo bar</a></p>\n"},
+	{"[foo]: /url\nbar\n===\n[foo]", "<h1>bar</h1>\n<p><a href=\"/url\">foo</a></p>\n"},
+	{"[foo]: /url 'title\n\n[foo]", "<p>[foo]: /url 'title</p>\n<p>[foo]</p>\n"},
+}
+
+var inlineTests = []struct {
+	in  string
+	out string
+}{
+	// Escapes and entities.
+	{"\\*not em\\*", "<p>*not em*</p>\n"},
+	{"a & b < c", "<p>a &amp; b &lt; c</p>\n"},
+	{"&copy; &#169; &#xA9; &nope", "<p>&copy; &#169; &#xA9; &amp;nope</p>\n"},
+	{"\"quoted\"", "<p>&quot;quoted&quot;</p>\n"},
+
+	// Line breaks.
+	{"a  \nb", "<p>a<br />\nb</p>\n"},
+	{"a\\\nb", "<p>a<br />\nb</p>\n"},
+	{"a \nb", "<p>a\nb</p>\n"},
+
+	// Code spans.
+	{"`code`", "<p><code>code</code></p>\n"},
+	{"`` a ` b ``", "<p><code>a ` b</code></p>\n"},
+	{"`<a>`", "<p><code>&lt;a&gt;</code></p>\n"},
+	{"`unclosed", "<p>`unclosed</p>\n"},
+	{"`*a*`", "<p><code>*a*</code></p>\n"},
+
+	// Emphasis.
+	{"*em*", "<p><em>em</em></p>\n"},
+	{"_em_", "<p><em>em</em></p>\n"},
+	{"**strong**", "<p><strong>strong</strong></p>\n"},
+	{"__strong__", "<p><strong>strong</strong></p>\n"},
+	{"***both***", "<p><em><strong>both</strong></em></p>\n"},
+	{"*a **b** c*", "<p><em>a <strong>b</strong> c</em></p>\n"},
+	{"a * b * c", "<p>a * b * c</p>\n"},
+	{"snake_case_name", "<p>snake_case_name</p>\n"},
+	{"in*ter*word", "<p>in<em>ter</em>word</p>\n"},
+	{"**foo*", "<p>*<em>foo</em></p>\n"},
+	{"*foo**", "<p><em>foo</em>*</p>\n"},
+	{"*foo**bar**baz*", "<p><em>foo<strong>bar</strong>baz</em></p>\n"},
+	{"*(*foo*)*", "<p><em>(<em>foo</em>)</em></p>\n"},
+	{"*a _b* c_", "<p><em>a _b</em> c_</p>\n"},
+
+	// Links.
+	{"[link](/url)", "<p><a href=\"/url\">link</a></p>\n"},
+	{"[link](/url \"title\")", "<p><a href=\"/url\" title=\"title\">link</a></p>\n"},
+	{"[link](<a b>)", "<p><a href=\"a%20b\">link</a></p>\n"},
+	{"[link]()", "<p><a href=\"\">link</a></p>\n"},
+	{"[a *b*](/c?d=1&e=2)", "<p><a href=\"/c?d=1&amp;e=2\">a <em>b</em></a></p>\n"},
+	{"[link](/url(with)parens)", "<p><a href=\"/url(with)parens\">link</a></p>\n"},
+	{"[not a link]", "<p>[not a link]</p>\n"},
+	{"[a] (b)", "<p>[a] (b)</p>\n"},
+	{"[a [nested] b](/u)", "<p><a href=\"/u\">a [nested] b</a></p>\n"},
+	{"![alt *text*](/img.png \"t\")", "<p><img src=\"/img.png\" alt=\"alt text\" title=\"t\" /></p>\n"},
+	{"[x]\n\n[x]: /u", "<p><a href=\"/u\">x</a></p>\n"},
+	{"[text][X]\n\n[x]: /u", "<p><a href=\"/u\">text</a></p>\n"},
+	{"<http://example.com/a?b=c&d>", "<p><a href=\"http://example.com/a?b=c&amp;d\">http://example.com/a?b=c&amp;d</a></p>\n"},
+	{"<foo@example.com>", "<p><a href=\"mailto:foo@example.com\">foo@example.com</a></p>\n"},
+	{"a <3 b", "<p>a &lt;3 b</p>\n"},
+	{"a <b class=\"x\">c</b> <br/>", "<p>a <b class=\"x\">c</b> <br/></p>\n"},
+	{"a <!-- hi --> there", "<p>a <!-- hi --> there</p>\n"},
+}
+
+func transform(in, rootUrl string) string {
+	var buf bytes.Buffer
+	Render(&buf, []byte(in), rootUrl)
+	return buf.String()
+}
+
+func TestBlocks(t *testing.T) {
+	for _, bt := range blockTests {
+		if out := transform(bt.in, ""); out != bt.out {
+			t.Errorf("transform(%q) = %q want %q", bt.in, out, bt.out)
+		}
+	}
+}
+
+func TestInlines(t *testing.T) {
+	for _, it := range inlineTests {
+		if out := transform(it.in, ""); out != it.out {
+			t.Errorf("transform(%q) = %q want %q", it.in, out, it.out)
+		}
+	}
+}
+
+func TestFullLinks(t *testing.T) {
+	in := "[a](/a) [b](http://b.com/) ![c](/c.png) [d](//d.com/) [e](e)"
+	out := "<p><a href=\"http://example.com/a\">a</a> <a href=\"http://b.com/\">b</a> " +
+		"<img src=\"http://example.com/c.png\" alt=\"c\" /> <a href=\"//d.com/\">d</a> <a href=\"e\">e</a></p>\n"
+	if s := transform(in, "http://example.com"); s != out {
+		t.Errorf("transform(%q) = %q want %q", in, s, out)
+	}
+
+	var buf bytes.Buffer
+	GetMarkdownFullLinkFormatter("http://example.com")(&buf, "", "[a](/a)")
+	if s := buf.String(); s != "<p><a href=\"http://example.com/a\">a</a></p>\n" {
+		t.Errorf("GetMarkdownFullLinkFormatter() = %q", s)
+	}
+}
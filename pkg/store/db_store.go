@@ -0,0 +1,693 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"flag"
+	"fmt"
+	"github.com/stevela/lwb/kv"
+	"github.com/stevela/lwb/lwb"
+	"json"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var flagDbPath *string = flag.String("db", "lwb.db", "Path to the database store")
+
+// The prefixes of the keys in the database. Only published and scheduled
+// items are indexed; drafts just have a post key.
+const (
+	// post/<uuid> holds a post or page as JSON.
+	postPrefix = "post/"
+
+	// path/<path> and page/<path> hold the uuid of a post or page.
+	pathPrefix = "path/"
+	pagePrefix = "page/"
+
+	// date/<time>/<uuid> holds the path and title of a post, separated by a
+	// newline, in order of publication.
+	datePrefix = "date/"
+
+	// month/<yyyy>/<mm>/<time>/<uuid> indexes posts by the year and month they
+	// were published.
+	monthPrefix = "month/"
+
+	// tag/<tag>\x00<time>/<uuid> and category/<category>\x00<time>/<uuid>
+	// index posts by tag and category.
+	tagPrefix      = "tag/"
+	categoryPrefix = "category/"
+
+	// due/<time>/<uuid> indexes posts and pages by the time they appear.
+	duePrefix = "due/"
+)
+
+// The width of the times in keys.
+const timeKeyLen = 20
+
+// dbStore is a store held in a kv database, so that only the keys, and not
+// the posts themselves, are kept in memory. The archives are all queries on
+// ordered ranges of keys; full text search is the exception, as it has to
+// read every post.
+type dbStore struct {
+	db *kv.DB
+
+	config       *lwb.BlogConfig
+	postLoadHook func(*Post)
+
+	// Serializes edits.
+	writeLock sync.Mutex
+
+	// Guards listeners and timer.
+	lock      sync.Mutex
+	listeners []func()
+
+	// Fires when the next scheduled item is due.
+	timer *time.Timer
+}
+
+// NewDbStore opens the database store given by the -db flag, creating it if it
+// doesn't exist. Only one process can have the database open at a time, so it
+// fails with kv.ErrLocked while e.g. the blog is running. If not nil,
+// postLoadHook can be used to make modifications to the post after it has been
+// loaded.
+func NewDbStore(config *lwb.BlogConfig, postLoadHook func(*Post)) (ds *dbStore, err os.Error) {
+	db, err := kv.Open(*flagDbPath)
+	if err != nil {
+		return
+	}
+
+	ds = &dbStore{
+		db:           db,
+		config:       config,
+		postLoadHook: postLoadHook,
+	}
+	ds.schedule()
+
+	return
+}
+
+// timeKey formats seconds since the epoch so that they sort in order.
+func timeKey(seconds int64) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+
+	return fmt.Sprintf("%0*d", timeKeyLen, seconds)
+}
+
+// splitTimeKey splits the <time>/<uuid> at the end of an index key.
+func splitTimeKey(rest string) (seconds int64, uuid string) {
+	if len(rest) < timeKeyLen+1 {
+		return
+	}
+
+	seconds, _ = strconv.Atoi64(rest[:timeKeyLen])
+	uuid = rest[timeKeyLen+1:]
+
+	return
+}
+
+// indexEntries returns the index keys and values for an item.
+func indexEntries(item *Post) map[string]string {
+	entries := make(map[string]string)
+	if !item.IsPublished() && !item.IsScheduled() {
+		return entries
+	}
+
+	suffix := timeKey(item.Published.Seconds()) + "/" + item.Uuid
+	entries[duePrefix+suffix] = ""
+
+	if item.IsPage() {
+		entries[pathPrefix+item.Path] = item.Uuid
+		entries[pagePrefix+item.Path] = item.Uuid
+		return entries
+	}
+
+	entries[pathPrefix+item.Path] = item.Uuid
+	entries[datePrefix+suffix] = item.Path + "\n" + item.Title
+	entries[fmt.Sprintf("%s%04d/%02d/%s", monthPrefix, item.Published.Year, item.Published.Month, suffix)] = ""
+	for _, tag := range item.Tags {
+		entries[tagPrefix+tag+"\x00"+suffix] = ""
+	}
+	for _, category := range item.Categories {
+		entries[categoryPrefix+category+"\x00"+suffix] = ""
+	}
+
+	return entries
+}
+
+// putItem adds writing item, which replaces old if old is not nil, to a batch.
+func putItem(b *kv.Batch, item, old *Post) os.Error {
+	record := postRecord(item)
+	record["body"] = item.Body
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	entries := indexEntries(item)
+	if old != nil {
+		for key, _ := range indexEntries(old) {
+			if _, found := entries[key]; !found {
+				b.Delete(key)
+			}
+		}
+	}
+
+	b.Put(postPrefix+item.Uuid, data)
+	for key, value := range entries {
+		b.Put(key, []byte(value))
+	}
+
+	return nil
+}
+
+// get reads a post or page, whatever its status, from the database.
+func (ds *dbStore) get(uuid string) (*Post, bool) {
+	data, found, err := ds.db.Get(postPrefix + uuid)
+	if !found {
+		if err != nil {
+			log.Println("Failed to read post " + uuid + ": " + err.String())
+		}
+		return nil, false
+	}
+
+	item := new(Post)
+	if err = json.Unmarshal(data, item); err == nil {
+		if err = parseDates(item); err == nil {
+			err = preparePost(item, ds.config, ds.postLoadHook)
+		}
+	}
+	if err != nil {
+		log.Println("Failed to load post " + uuid + ": " + err.String())
+		return nil, false
+	}
+
+	return item, true
+}
+
+// getVisible reads the posts or pages in the index keys that are visible now.
+// Each key ends in <time>/<uuid>.
+func (ds *dbStore) getVisible(keys []string, prefixLen func(key string) int) (posts []*Post) {
+	now := time.Seconds()
+	for _, key := range keys {
+		if seconds, uuid := splitTimeKey(key[prefixLen(key):]); seconds <= now {
+			if post, found := ds.get(uuid); found && post.IsVisibleAt(now) {
+				posts = append(posts, post)
+			}
+		}
+	}
+
+	return
+}
+
+// scanNewest returns up to limit keys starting with prefix, newest first, after
+// skipping the offset newest. For this to work, prefix must be followed by the
+// <time> part of the keys.
+func (ds *dbStore) scanNewest(prefix string, offset, limit int) (keys []string) {
+	ds.db.ScanReverse(prefix, prefix+timeKey(time.Seconds()+1), func(key string) bool {
+		if offset > 0 {
+			offset -= 1
+			return true
+		}
+		keys = append(keys, key)
+		return len(keys) < limit
+	})
+
+	return
+}
+
+// fixedPrefix returns a prefixLen function for index keys with a fixed prefix.
+func fixedPrefix(n int) func(string) int {
+	return func(string) int { return n }
+}
+
+// taggedPrefix is the prefixLen function for tag and category index keys.
+func taggedPrefix(key string) int {
+	return strings.Index(key, "\x00") + 1
+}
+
+// GetRecentPosts returns the most recent numPosts posts.
+func (ds *dbStore) GetRecentPosts(numPosts int) []*Post {
+	if numPosts <= 0 {
+		return nil
+	}
+
+	return ds.getVisible(ds.scanNewest(datePrefix, 0, numPosts), fixedPrefix(len(datePrefix)))
+}
+
+// NumPosts returns the number of published posts.
+func (ds *dbStore) NumPosts() (n int) {
+	ds.db.ScanReverse(datePrefix, datePrefix+timeKey(time.Seconds()+1), func(key string) bool {
+		n += 1
+		return true
+	})
+
+	return
+}
+
+// GetPostsPage returns up to n posts, newest first, after skipping the offset
+// newest. Only the keys of the skipped posts are read.
+func (ds *dbStore) GetPostsPage(offset, n int) []*Post {
+	if offset < 0 || n <= 0 {
+		return nil
+	}
+
+	return ds.getVisible(ds.scanNewest(datePrefix, offset, n), fixedPrefix(len(datePrefix)))
+}
+
+// GetPage returns a page with the given name.
+func (ds *dbStore) GetPage(name string) (*Post, bool) {
+	uuid, found, _ := ds.db.Get(pagePrefix + name)
+	if !found {
+		return nil, false
+	}
+
+	post, found := ds.get(string(uuid))
+	if !found || !post.IsVisibleAt(time.Seconds()) {
+		return nil, false
+	}
+
+	return post, true
+}
+
+// GetPages returns all the published pages, ordered by path.
+func (ds *dbStore) GetPages() (pages []*Post) {
+	for _, key := range ds.db.Keys(pagePrefix) {
+		if page, found := ds.GetPage(key[len(pagePrefix):]); found {
+			pages = append(pages, page)
+		}
+	}
+
+	return
+}
+
+// GetPostByPath returns a post given a date based path, along with the paths
+// and titles of the posts either side of it.
+func (ds *dbStore) GetPostByPath(path string) (*Post, bool) {
+	uuid, found, _ := ds.db.Get(pathPrefix + path)
+	if !found {
+		return nil, false
+	}
+
+	post, found := ds.get(string(uuid))
+	if !found || !post.IsPost() || !post.IsVisibleAt(time.Seconds()) {
+		return nil, false
+	}
+
+	// Posts are ordered newest first, so the previous post is the newer one.
+	key := datePrefix + timeKey(post.Published.Seconds()) + "/" + post.Uuid
+	var previous, next string
+	ds.db.Scan(key+"\x00", datePrefix+timeKey(time.Seconds()+1), func(k string) bool {
+		previous = k
+		return false
+	})
+	ds.db.ScanReverse(datePrefix, key, func(k string) bool {
+		next = k
+		return false
+	})
+
+	if previous != "" {
+		post.PreviousPath, post.PreviousTitle = ds.pathAndTitle(previous)
+	}
+	if next != "" {
+		post.NextPath, post.NextTitle = ds.pathAndTitle(next)
+	}
+
+	return post, true
+}
+
+// pathAndTitle returns the path and title held in a date index key.
+func (ds *dbStore) pathAndTitle(key string) (path, title string) {
+	value, _, _ := ds.db.Get(key)
+	if i := strings.Index(string(value), "\n"); i >= 0 {
+		path, title = string(value[:i]), string(value[i+1:])
+	}
+
+	return
+}
+
+// GetPostByUuid returns a post or page, published or not, given its uuid.
+func (ds *dbStore) GetPostByUuid(uuid string) (*Post, bool) {
+	return ds.get(uuid)
+}
+
+// GetAllPosts returns every post and page, including drafts and items that are
+// scheduled for later.
+func (ds *dbStore) GetAllPosts() (posts []*Post) {
+	for _, key := range ds.db.Keys(postPrefix) {
+		if post, found := ds.get(key[len(postPrefix):]); found {
+			posts = append(posts, post)
+		}
+	}
+
+	return
+}
+
+// GetPostsByYear returns all the posts for a given year.
+func (ds *dbStore) GetPostsByYear(year int) ([]*Post, bool) {
+	prefix := fmt.Sprintf("%s%04d/", monthPrefix, year)
+	posts := ds.getVisible(ds.scanTimes(prefix, len(prefix)+3), fixedPrefix(len(prefix)+3))
+
+	return posts, len(posts) > 0
+}
+
+// GetPostsByYearMonth returns all the posts for a given year and month.
+func (ds *dbStore) GetPostsByYearMonth(year, month int) ([]*Post, bool) {
+	if month < 1 || month > numMonthsPerYear {
+		return nil, false
+	}
+
+	prefix := fmt.Sprintf("%s%04d/%02d/", monthPrefix, year, month)
+	posts := ds.getVisible(ds.scanTimes(prefix, len(prefix)), fixedPrefix(len(prefix)))
+
+	return posts, len(posts) > 0
+}
+
+// GetPostsByTag returns all the posts for a given tag.
+func (ds *dbStore) GetPostsByTag(tag string) ([]*Post, bool) {
+	prefix := tagPrefix + tag + "\x00"
+	posts := ds.getVisible(ds.scanNewest(prefix, 0, maxInt), taggedPrefix)
+
+	return posts, len(posts) > 0
+}
+
+// GetPostsByCategory returns all the posts for a given category.
+func (ds *dbStore) GetPostsByCategory(category string) ([]*Post, bool) {
+	prefix := categoryPrefix + category + "\x00"
+	posts := ds.getVisible(ds.scanNewest(prefix, 0, maxInt), taggedPrefix)
+
+	return posts, len(posts) > 0
+}
+
+// The largest int, for unlimited scans.
+const maxInt = int(^uint(0) >> 1)
+
+// scanTimes returns the keys starting with prefix whose <time>, which starts
+// at offset timeAt, has passed, newest first.
+func (ds *dbStore) scanTimes(prefix string, timeAt int) (keys []string) {
+	now := time.Seconds()
+	ds.db.ScanReverse(prefix, kv.PrefixLimit(prefix), func(key string) bool {
+		if seconds, _ := splitTimeKey(key[timeAt:]); seconds <= now {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	return
+}
+
+// scanNames returns the distinct names, e.g. tags, in index keys of the form
+// <prefix><name>\x00<time>/<uuid> for the posts that are visible now.
+func (ds *dbStore) scanNames(prefix string) (names []string) {
+	var keys []string
+	now := time.Seconds()
+	ds.db.Scan(prefix, kv.PrefixLimit(prefix), func(key string) bool {
+		if seconds, _ := splitTimeKey(key[taggedPrefix(key):]); seconds <= now {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	for _, key := range keys {
+		name := key[len(prefix) : taggedPrefix(key)-1]
+		if len(names) == 0 || names[len(names)-1] != name {
+			names = append(names, name)
+		}
+	}
+
+	return
+}
+
+// GetTags returns all the tags.
+func (ds *dbStore) GetTags() []string {
+	return ds.scanNames(tagPrefix)
+}
+
+// GetCategories returns all the categories.
+func (ds *dbStore) GetCategories() []string {
+	return ds.scanNames(categoryPrefix)
+}
+
+// GetArchives returns the yearly archives.
+func (ds *dbStore) GetArchives() (archives Archives) {
+	// Each key starts month/<yyyy>/<mm>/.
+	const timeAt = len(monthPrefix) + 8
+
+	last := ""
+	for _, key := range ds.scanTimes(monthPrefix, timeAt) {
+		if month := key[:timeAt]; month != last {
+			y, _ := strconv.Atoi(month[len(monthPrefix) : len(monthPrefix)+4])
+			m, _ := strconv.Atoi(month[len(monthPrefix)+5 : len(monthPrefix)+7])
+			archives = append(archives, newArchive(y, m-1))
+			last = month
+		}
+	}
+
+	sort.Sort(archives)
+
+	return
+}
+
+// Search returns the published posts and pages matching a query, best matches
+// first. Unlike the other queries, it has to read every post.
+func (ds *dbStore) Search(query string) []*Post {
+	items := ds.getVisible(ds.scanTimes(duePrefix, len(duePrefix)), fixedPrefix(len(duePrefix)))
+
+	return newSearchIndex(items).search(query)
+}
+
+// SavePost creates a new post or page, assigning it a uuid if it doesn't
+// already have one. Missing fields are given sensible defaults.
+func (ds *dbStore) SavePost(post *Post) (err os.Error) {
+	ds.writeLock.Lock()
+	defer ds.writeLock.Unlock()
+
+	item := post.Copy()
+	if item.Uuid != "" && ds.db.Has(postPrefix+item.Uuid) {
+		return os.NewError("Post already exists: " + item.Uuid)
+	}
+	if err = setDefaults(item); err != nil {
+		return
+	}
+
+	if err = ds.write(item, nil); err != nil {
+		return
+	}
+	*post = *item
+
+	return
+}
+
+// UpdatePost replaces the post or page with the same uuid.
+func (ds *dbStore) UpdatePost(post *Post) os.Error {
+	ds.writeLock.Lock()
+	defer ds.writeLock.Unlock()
+
+	return ds.update(post.Copy())
+}
+
+// SetPostStatus changes the status ("publish", "scheduled" or "draft") of a
+// post or page.
+func (ds *dbStore) SetPostStatus(uuid, status string) os.Error {
+	ds.writeLock.Lock()
+	defer ds.writeLock.Unlock()
+
+	item, found := ds.get(uuid)
+	if !found {
+		return os.NewError("No such post: " + uuid)
+	}
+	item.Status = status
+
+	return ds.update(item)
+}
+
+// DeletePost removes the post or page with the given uuid.
+func (ds *dbStore) DeletePost(uuid string) os.Error {
+	ds.writeLock.Lock()
+	defer ds.writeLock.Unlock()
+
+	old, found := ds.get(uuid)
+	if !found {
+		return os.NewError("No such post: " + uuid)
+	}
+
+	b := new(kv.Batch)
+	b.Delete(postPrefix + uuid)
+	for key, _ := range indexEntries(old) {
+		b.Delete(key)
+	}
+	if err := ds.db.Write(b); err != nil {
+		return err
+	}
+
+	ds.schedule()
+
+	return nil
+}
+
+// update writes an edited item. The caller must hold the write lock.
+func (ds *dbStore) update(item *Post) os.Error {
+	old, found := ds.get(item.Uuid)
+	if !found {
+		return os.NewError("No such post: " + item.Uuid)
+	}
+
+	if item.Published == nil {
+		item.Published = old.Published
+	}
+
+	return ds.write(item, old)
+}
+
+// write brings the database up to date with item, which replaces old if old is
+// not nil, and reschedules the timer.
+func (ds *dbStore) write(item, old *Post) (err os.Error) {
+	item.LastModified = time.LocalTime()
+	item.LastModifiedDate = item.LastModified.Format(timeFormat)
+	item.PublishedDate = item.Published.Format(timeFormat)
+
+	if err = preparePost(item, ds.config, ds.postLoadHook); err != nil {
+		return
+	}
+
+	b := new(kv.Batch)
+	if err = putItem(b, item, old); err != nil {
+		return
+	}
+	if err = ds.db.Write(b); err != nil {
+		return
+	}
+
+	ds.schedule()
+
+	return
+}
+
+// Import copies every post and page from src, replacing any with the same
+// uuid, and returns how many there were. Their dates are kept as they are.
+func (ds *dbStore) Import(src Exporter) (n int, err os.Error) {
+	ds.writeLock.Lock()
+	defer ds.writeLock.Unlock()
+
+	b := new(kv.Batch)
+	for _, item := range src.GetAllPosts() {
+		item = item.Copy()
+		item.LastModifiedDate = item.LastModified.Format(timeFormat)
+		item.PublishedDate = item.Published.Format(timeFormat)
+		if err = preparePost(item, ds.config, nil); err != nil {
+			return
+		}
+
+		old, _ := ds.get(item.Uuid)
+		if err = putItem(b, item, old); err != nil {
+			return
+		}
+		n += 1
+	}
+
+	if err = ds.db.Write(b); err != nil {
+		return 0, err
+	}
+
+	ds.changed()
+
+	return
+}
+
+// Compact rewrites the database file without any old versions of posts.
+func (ds *dbStore) Compact() os.Error {
+	ds.writeLock.Lock()
+	defer ds.writeLock.Unlock()
+
+	return ds.db.Compact()
+}
+
+// Close closes the database.
+func (ds *dbStore) Close() os.Error {
+	ds.lock.Lock()
+	if ds.timer != nil {
+		ds.timer.Stop()
+		ds.timer = nil
+	}
+	ds.lock.Unlock()
+
+	return ds.db.Close()
+}
+
+// OnReload registers fn to be called whenever the store is reloaded or a
+// scheduled post is published.
+func (ds *dbStore) OnReload(fn func()) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	ds.listeners = append(ds.listeners, fn)
+}
+
+// Reload notifies the listeners. The database is locked while the store has
+// it open, so nothing else can have changed it and there's nothing to reread.
+func (ds *dbStore) Reload() os.Error {
+	ds.writeLock.Lock()
+	defer ds.writeLock.Unlock()
+
+	ds.changed()
+
+	return nil
+}
+
+// Watch does nothing, as no other process can change the database while the
+// store has it open. Edits made through the store are visible immediately.
+func (ds *dbStore) Watch(intervalNs int64) {
+}
+
+// changed reschedules the timer and notifies the listeners.
+func (ds *dbStore) changed() {
+	listeners := ds.schedule()
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+// schedule arranges for the listeners to be notified when the next scheduled
+// item is due, and returns the listeners.
+func (ds *dbStore) schedule() []func() {
+	now := time.Seconds()
+	var due int64
+	ds.db.Scan(duePrefix+timeKey(now+1), kv.PrefixLimit(duePrefix), func(key string) bool {
+		due, _ = splitTimeKey(key[len(duePrefix):])
+		return false
+	})
+
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	if ds.timer != nil {
+		ds.timer.Stop()
+		ds.timer = nil
+	}
+	if due != 0 {
+		ds.timer = time.AfterFunc((due-now)*1e9, func() { ds.changed() })
+	}
+
+	return ds.listeners
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"github.com/stevela/lwb/lwb"
+	"http"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDbStore(t *testing.T) *dbStore {
+	f, err := ioutil.TempFile("", "db_store_test")
+	if err != nil {
+		t.Fatalf("TempFile() failed: %s", err)
+	}
+	f.Close()
+	*flagDbPath = f.Name()
+
+	ds, err := NewDbStore(&lwb.BlogConfig{BlogUrl: &http.URL{Scheme: "http", Host: "example.com"}}, nil)
+	if err != nil {
+		t.Fatalf("NewDbStore() failed: %s", err)
+	}
+
+	return ds
+}
+
+func titles(posts []*Post) string {
+	var titles []string
+	for _, post := range posts {
+		titles = append(titles, post.Title)
+	}
+
+	return strings.Join(titles, ",")
+}
+
+func TestDbStore(t *testing.T) {
+	ds := newTestDbStore(t)
+	defer os.Remove(*flagDbPath)
+
+	now := time.Seconds()
+	for _, post := range []*Post{
+		&Post{Title: "old", Status: "publish", Tags: []string{"go"}, Published: time.SecondsToUTC(now - 3600)},
+		&Post{Title: "new", Status: "publish", Tags: []string{"go", "web"}, Published: time.SecondsToUTC(now - 60)},
+		&Post{Title: "draft", Tags: []string{"draft"}, Published: time.SecondsToUTC(now - 30)},
+		&Post{Title: "later", Status: "scheduled", Tags: []string{"later"}, Published: time.SecondsToUTC(now + 3600)},
+		&Post{Title: "about", Type: "page", Status: "publish", Published: time.SecondsToUTC(0)},
+	} {
+		if err := ds.SavePost(post); err != nil {
+			t.Fatalf("SavePost() failed: %s", err)
+		}
+	}
+
+	if s := titles(ds.GetRecentPosts(10)); s != "new,old" {
+		t.Errorf("GetRecentPosts() = '%s'", s)
+	}
+	if s := titles(ds.GetPostsPage(1, 10)); s != "old" || ds.NumPosts() != 2 {
+		t.Errorf("GetPostsPage(1, 10) = '%s' of %d posts", s, ds.NumPosts())
+	}
+	if n := len(ds.GetAllPosts()); n != 5 {
+		t.Errorf("GetAllPosts() returned %d items want 5", n)
+	}
+	if s := strings.Join(ds.GetTags(), ","); s != "go,web" {
+		t.Errorf("GetTags() = '%s'", s)
+	}
+	if posts, _ := ds.GetPostsByTag("go"); titles(posts) != "new,old" {
+		t.Errorf("GetPostsByTag(\"go\") = '%s'", titles(posts))
+	}
+	if _, found := ds.GetPostsByTag("later"); found {
+		t.Errorf("scheduled post is visible early")
+	}
+	if page, found := ds.GetPage("/page/about"); !found || page.Title != "about" {
+		t.Errorf("GetPage() = %v, %v", page, found)
+	}
+
+	recent := ds.GetRecentPosts(1)
+	post, found := ds.GetPostByPath(recent[0].Path)
+	if !found || post.NextTitle != "old" || post.PreviousPath != "" {
+		t.Errorf("GetPostByPath() = %v, %v", post, found)
+	}
+
+	year := time.SecondsToUTC(now - 60).Year
+	if posts, _ := ds.GetPostsByYear(int(year)); len(posts) == 0 || posts[0].Title != "new" {
+		t.Errorf("GetPostsByYear() = '%s'", titles(posts))
+	}
+	if archives := ds.GetArchives(); len(archives) == 0 || archives[0].Year != int(year) {
+		t.Errorf("GetArchives() = %v", archives)
+	}
+	if s := titles(ds.Search("new")); s != "new" {
+		t.Errorf("Search(\"new\") = '%s'", s)
+	}
+
+	// Edits replace the old index entries.
+	edited := post.Copy()
+	edited.Tags = []string{"edited"}
+	if err := ds.UpdatePost(edited); err != nil {
+		t.Fatalf("UpdatePost() failed: %s", err)
+	}
+	if s := strings.Join(ds.GetTags(), ","); s != "edited,go" {
+		t.Errorf("GetTags() after edit = '%s'", s)
+	}
+	if err := ds.SetPostStatus(post.Uuid, "draft"); err != nil {
+		t.Fatalf("SetPostStatus() failed: %s", err)
+	}
+	if s := titles(ds.GetRecentPosts(10)); s != "old" {
+		t.Errorf("GetRecentPosts() after unpublishing = '%s'", s)
+	}
+	if err := ds.DeletePost(post.Uuid); err != nil {
+		t.Fatalf("DeletePost() failed: %s", err)
+	}
+	if _, found := ds.GetPostByUuid(post.Uuid); found {
+		t.Errorf("deleted post is present")
+	}
+
+	// Everything is still there after reopening.
+	ds.Close()
+	ds, err := NewDbStore(ds.config, nil)
+	if err != nil {
+		t.Fatalf("NewDbStore() failed: %s", err)
+	}
+	defer ds.Close()
+
+	if s := titles(ds.GetRecentPosts(10)); s != "old" {
+		t.Errorf("GetRecentPosts() after reopening = '%s'", s)
+	}
+}
+
+func TestDbStoreImport(t *testing.T) {
+	ds := newTestDbStore(t)
+	defer os.Remove(*flagDbPath)
+	defer ds.Close()
+
+	now := time.Seconds()
+	published := time.SecondsToUTC(now - 3600)
+	src := new(indexedStore)
+	src.setIndex(newPostIndex([]*Post{
+		&Post{Uuid: "a", Type: "post", Status: "publish", Title: "a", Basename: "a",
+			Published: published, LastModified: published},
+		&Post{Uuid: "b", Type: "post", Status: "draft", Title: "b", Basename: "b",
+			Published: published, LastModified: published},
+	}))
+
+	if n, err := ds.Import(src); n != 2 || err != nil {
+		t.Fatalf("Import() = %d, %v", n, err)
+	}
+
+	if s := titles(ds.GetRecentPosts(10)); s != "a" {
+		t.Errorf("GetRecentPosts() = '%s'", s)
+	}
+	post, found := ds.GetPostByUuid("b")
+	if !found || post.LastModified.Seconds() != published.Seconds() {
+		t.Errorf("GetPostByUuid(\"b\") = %v, %v", post, found)
+	}
+}
@@ -0,0 +1,312 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// frontMatter holds the fields from the header of a text file. Each value is
+// either a string or a []string. Keys are lower case.
+//
+// Only the parts of YAML and TOML needed to describe a post are understood:
+// scalar values, which may be quoted, and lists of them. YAML lists can be
+// written either as [a, b] or as one "- item" per line.
+type frontMatter map[string]interface{}
+
+// The lines that start and end the front matter.
+const (
+	yamlDelimiter = "---"
+	tomlDelimiter = "+++"
+)
+
+// splitFrontMatter separates a text file into its front matter and body.
+func splitFrontMatter(data string) (fm frontMatter, body string, err os.Error) {
+	data = strings.Replace(data, "\r\n", "\n", -1)
+
+	end := strings.Index(data, "\n")
+	if end < 0 {
+		return nil, "", os.NewError("Missing front matter")
+	}
+
+	delimiter := strings.TrimSpace(data[:end])
+	if delimiter != yamlDelimiter && delimiter != tomlDelimiter {
+		return nil, "", os.NewError("Missing front matter")
+	}
+
+	lines := strings.Split(data[end+1:], "\n", -1)
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line != delimiter && !(delimiter == yamlDelimiter && line == "...") {
+			continue
+		}
+
+		header := lines[:i]
+		body = strings.Join(lines[i+1:], "\n")
+		if delimiter == yamlDelimiter {
+			fm, err = parseYaml(header)
+		} else {
+			fm, err = parseToml(header)
+		}
+
+		return
+	}
+
+	return nil, "", os.NewError("Unterminated front matter")
+}
+
+// parseYaml parses YAML front matter.
+func parseYaml(lines []string) (frontMatter, os.Error) {
+	fm := make(frontMatter)
+
+	// The key of a list whose items follow on separate lines.
+	listKey := ""
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if listKey != "" && (trimmed == "-" || strings.HasPrefix(trimmed, "- ")) {
+			value, err := parseScalar(strings.TrimSpace(trimmed[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+2, err)
+			}
+			fm[listKey] = append(fm[listKey].([]string), value)
+			continue
+		}
+		listKey = ""
+
+		colon := strings.Index(line, ":")
+		if colon < 0 || line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: expected key: value", i+2)
+		}
+
+		key := strings.ToLower(strings.TrimSpace(line[:colon]))
+		value, err := parseValue(strings.TrimSpace(line[colon+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+2, err)
+		}
+
+		if value == "" {
+			// The items of a list may follow.
+			listKey = key
+			fm[key] = []string{}
+		} else {
+			fm[key] = value
+		}
+	}
+
+	return fm, nil
+}
+
+// parseToml parses TOML front matter.
+func parseToml(lines []string) (frontMatter, os.Error) {
+	fm := make(frontMatter)
+
+	for i := 0; i < len(lines); i += 1 {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		equals := strings.Index(line, "=")
+		if equals < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", i+2)
+		}
+
+		key := strings.ToLower(strings.Trim(strings.TrimSpace(line[:equals]), "\"'"))
+		text := strings.TrimSpace(line[equals+1:])
+
+		// Arrays may span several lines.
+		start := i
+		for strings.HasPrefix(text, "[") && !strings.HasSuffix(stripComment(text), "]") && i+1 < len(lines) {
+			i += 1
+			text += " " + strings.TrimSpace(lines[i])
+		}
+
+		value, err := parseValue(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", start+2, err)
+		}
+
+		fm[key] = value
+	}
+
+	return fm, nil
+}
+
+// parseValue parses a scalar or a [a, b] list.
+func parseValue(text string) (interface{}, os.Error) {
+	if !strings.HasPrefix(text, "[") {
+		return parseScalar(text)
+	}
+
+	text = stripComment(text)
+	if !strings.HasSuffix(text, "]") {
+		return nil, os.NewError("unterminated list")
+	}
+
+	items := []string{}
+	for _, item := range splitList(text[1 : len(text)-1]) {
+		value, err := parseScalar(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+
+	return items, nil
+}
+
+// splitList splits the items of a list at commas that aren't quoted. A
+// trailing comma is allowed.
+func splitList(text string) (items []string) {
+	var quote byte
+	start := 0
+	for i := 0; i < len(text); i += 1 {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i += 1
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, text[start:i])
+			start = i + 1
+		}
+	}
+
+	if last := text[start:]; strings.TrimSpace(last) != "" {
+		items = append(items, last)
+	}
+
+	return
+}
+
+// parseScalar parses a single, possibly quoted, value.
+func parseScalar(text string) (string, os.Error) {
+	if text == "" {
+		return "", nil
+	}
+
+	switch text[0] {
+	case '\'':
+		// Quotes are doubled to escape them.
+		end := 1
+		for ; end < len(text); end += 1 {
+			if text[end] == '\'' {
+				if end+1 < len(text) && text[end+1] == '\'' {
+					end += 1
+				} else {
+					break
+				}
+			}
+		}
+		if end == len(text) || stripComment(text[end+1:]) != "" {
+			return "", os.NewError("bad quoted value: " + text)
+		}
+		return strings.Replace(text[1:end], "''", "'", -1), nil
+
+	case '"':
+		value := make([]byte, 0, len(text))
+		for i := 1; i < len(text); i += 1 {
+			c := text[i]
+			if c == '"' {
+				if stripComment(text[i+1:]) != "" {
+					break
+				}
+				return string(value), nil
+			}
+
+			if c == '\\' && i+1 < len(text) {
+				i += 1
+				switch text[i] {
+				case 'n':
+					c = '\n'
+				case 't':
+					c = '\t'
+				default:
+					c = text[i]
+				}
+			}
+			value = append(value, c)
+		}
+		return "", os.NewError("bad quoted value: " + text)
+	}
+
+	return stripComment(text), nil
+}
+
+// stripComment removes a trailing # comment, ignoring any # that is quoted or
+// is part of a word. Only quotes at the start of a value count.
+func stripComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i += 1 {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexAny(text[i-1:i], " \t[,") >= 0):
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			text = text[:i]
+		}
+	}
+
+	return strings.TrimSpace(text)
+}
+
+// getString returns the string value of key, or "" if it isn't set.
+func (fm frontMatter) getString(key string) (string, os.Error) {
+	switch value := fm[key].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	}
+
+	return "", os.NewError("Expected a single value for " + key)
+}
+
+// getList returns the list value of key. A single value is treated as a comma
+// separated list.
+func (fm frontMatter) getList(key string) (list []string) {
+	switch value := fm[key].(type) {
+	case []string:
+		for _, item := range value {
+			if item != "" {
+				list = append(list, item)
+			}
+		}
+	case string:
+		for _, item := range strings.Split(value, ",", -1) {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return
+}
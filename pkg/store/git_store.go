@@ -0,0 +1,380 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"exec"
+	"flag"
+	"fmt"
+	"github.com/stevela/lwb/lwb"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var flagGitRepo *string = flag.String("git_repo", ".", "Path to the git repository of the git store")
+var flagGitPath *string = flag.String("git_path", "", "Path to the posts within the git repository")
+var flagGitRef *string = flag.String("git_ref", "", "The git ref to read posts from (empty for the working tree)")
+
+// gitStore is a read only store of posts and pages kept in a directory of a
+// git repository, either as .post, .page and .body files like the json store
+// or as text files with front matter like the text store. The posts are read
+// from a ref, e.g. a branch, or from the working tree, and the history of each
+// one is available from the repository.
+type gitStore struct {
+	indexedStore
+
+	// The repository, the directory of the posts within it and the ref to
+	// read them from, or "" for the working tree.
+	repo string
+	dir  string
+	ref  string
+
+	config       *lwb.BlogConfig
+	postLoadHook func(*Post)
+
+	// Serializes reloads.
+	reloadLock sync.Mutex
+
+	// The commit the posts were read from, and a map of uuid -> the paths of
+	// the files each item was read from, relative to the repository. Guarded
+	// by the indexedStore lock.
+	commit string
+	files  map[string][]string
+}
+
+// NewGitStore creates a new store from the repository given by the -git_repo,
+// -git_path and -git_ref flags. If not nil, postLoadHook can be used to make
+// modifications to the post after it has been loaded. If any posts can't be
+// loaded, a strict store fails with a *LoadError, while a lenient one skips
+// them.
+func NewGitStore(config *lwb.BlogConfig, postLoadHook func(*Post)) (gs *gitStore, err os.Error) {
+	gs = &gitStore{
+		repo:         *flagGitRepo,
+		dir:          *flagGitPath,
+		ref:          *flagGitRef,
+		config:       config,
+		postLoadHook: postLoadHook,
+	}
+
+	items, commit, files, problems, err := gs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	gs.commit, gs.files, gs.problems = commit, files, problems
+	gs.setIndex(newPostIndex(items))
+
+	return
+}
+
+// git runs git in the repository with the given input and returns its output.
+func (gs *gitStore) git(input []byte, args ...string) ([]byte, os.Error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = gs.repo
+	if input != nil {
+		cmd.Stdin = bytes.NewBuffer(input)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed: %s: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+// resolve returns the commit that ref refers to.
+func (gs *gitStore) resolve(ref string) (string, os.Error) {
+	out, err := gs.git(nil, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// readCommit reads the files in the store's directory at a commit.
+func (gs *gitStore) readCommit(commit string) (map[string][]byte, os.Error) {
+	out, err := gs.git(nil, "ls-tree", "-z", "--name-only", commit+":"+gs.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	var input bytes.Buffer
+	for _, name := range strings.Split(string(out), "\x00", -1) {
+		if isStoreFile(name) {
+			names = append(names, name)
+			fmt.Fprintf(&input, "%s:%s\n", commit, path.Join(gs.dir, name))
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	// Read them all with one git process.
+	if out, err = gs.git(input.Bytes(), "cat-file", "--batch"); err != nil {
+		return nil, err
+	}
+
+	contents := make(map[string][]byte)
+	r := bufio.NewReader(bytes.NewBuffer(out))
+	for _, name := range names {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		// Each object is "<sha> <type> <size>\n<contents>\n".
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return nil, os.NewError("Bad object for " + name + ": " + header)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, size+1)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		if fields[1] == "blob" {
+			contents[name] = data[:size]
+		}
+	}
+
+	return contents, nil
+}
+
+// readWorkingTree reads the files in the store's directory in the working
+// tree.
+func (gs *gitStore) readWorkingTree() (map[string][]byte, os.Error) {
+	dir := path.Join(gs.repo, gs.dir)
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := make(map[string][]byte)
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsRegular() || !isStoreFile(fileInfo.Name) {
+			continue
+		}
+
+		if contents[fileInfo.Name], err = ioutil.ReadFile(path.Join(dir, fileInfo.Name)); err != nil {
+			return nil, err
+		}
+	}
+
+	return contents, nil
+}
+
+// isStoreFile returns whether a file holds a post, page or body.
+func isStoreFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	ext := path.Ext(name)
+	_, isText := textFormats[ext]
+
+	return isText || ext == postSuffix || ext == pageSuffix || ext == bodySuffix
+}
+
+// load reads all the posts and pages, returning them along with the commit they
+// were read from, the files each one was read from and the problems with any
+// files that had to be skipped.
+func (gs *gitStore) load() (items []*Post, commit string, files map[string][]string, problems []*LoadProblem, err os.Error) {
+	var contents map[string][]byte
+	if gs.ref == "" {
+		if commit, err = gs.resolve("HEAD"); err == nil {
+			contents, err = gs.readWorkingTree()
+		}
+	} else {
+		if commit, err = gs.resolve(gs.ref); err == nil {
+			contents, err = gs.readCommit(commit)
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	readFile := func(name string) ([]byte, os.Error) {
+		if data, found := contents[name]; found {
+			return data, nil
+		}
+		return nil, os.ENOENT
+	}
+
+	// Load in a fixed order, like a directory listing.
+	var names []string
+	for name, _ := range contents {
+		names = append(names, name)
+	}
+	sort.SortStrings(names)
+
+	report := newLoadReport(path.Join(gs.repo, gs.dir))
+	files = make(map[string][]string)
+	for _, name := range names {
+		var item *Post
+		switch ext := path.Ext(name); {
+		case ext == postSuffix || ext == pageSuffix:
+			item, err = parseJsonItem(name, contents[name], readFile)
+		case ext != bodySuffix:
+			item, err = parseTextItem(name, contents[name])
+		default:
+			continue
+		}
+		if err == nil {
+			err = preparePost(item, gs.config, gs.postLoadHook)
+		}
+		if err != nil {
+			report.add(name, err)
+			continue
+		}
+
+		files[item.Uuid] = append(files[item.Uuid], path.Join(gs.dir, name))
+		if body := item.file + bodySuffix; contents[body] != nil {
+			files[item.Uuid] = append(files[item.Uuid], path.Join(gs.dir, body))
+		}
+
+		items = append(items, item)
+	}
+
+	return items, commit, files, report.problems, report.err()
+}
+
+// Reload rereads the posts from the repository and swaps in the new contents.
+// When reading from a ref, it picks up any commits made to it since. The
+// existing contents are kept if the store fails to load.
+func (gs *gitStore) Reload() os.Error {
+	gs.reloadLock.Lock()
+	defer gs.reloadLock.Unlock()
+
+	items, commit, files, problems, err := gs.load()
+	if err != nil {
+		return err
+	}
+
+	idx := newPostIndex(items)
+
+	gs.lock.Lock()
+	gs.commit, gs.files, gs.problems = commit, files, problems
+	gs.lock.Unlock()
+
+	gs.swap(idx)
+
+	return nil
+}
+
+// signature returns a string that changes whenever the store's ref, or in the
+// case of the working tree the files in its directory, change.
+func (gs *gitStore) signature() string {
+	if gs.ref != "" {
+		commit, _ := gs.resolve(gs.ref)
+		return commit
+	}
+
+	commit, _ := gs.resolve("HEAD")
+	suffixes := []string{postSuffix, pageSuffix, bodySuffix}
+	for ext, _ := range textFormats {
+		suffixes = append(suffixes, ext)
+	}
+
+	return commit + "\n" + dirSignature(path.Join(gs.repo, gs.dir), suffixes...)
+}
+
+// Watch checks the repository every intervalNs nanoseconds and reloads the
+// store when anything in it changes.
+func (gs *gitStore) Watch(intervalNs int64) {
+	watchStore(intervalNs, func() string { return gs.signature() }, func() os.Error { return gs.Reload() })
+}
+
+// Commit returns the commit the posts were last read from. When reading from
+// the working tree, it's the commit checked out at the time.
+func (gs *gitStore) Commit() string {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+
+	return gs.commit
+}
+
+// The format of the header of each commit in the output of git log. The
+// commits are separated by \x01 and their fields by \x00.
+const gitLogFormat = "--format=%x01%H%x00%an <%ae>%x00%at%x00%s%x00"
+
+// GetPostHistory returns the commits that changed the files of a post or page,
+// newest first, up to the commit the store was read from.
+func (gs *gitStore) GetPostHistory(uuid string) ([]*Revision, os.Error) {
+	gs.lock.RLock()
+	commit, files := gs.commit, gs.files[uuid]
+	gs.lock.RUnlock()
+
+	if files == nil {
+		return nil, os.NewError("No such post: " + uuid)
+	}
+
+	args := append([]string{"log", "-p", "--no-color", gitLogFormat, commit, "--"}, files...)
+	out, err := gs.git(nil, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGitLog(string(out))
+}
+
+// parseGitLog parses the output of git log in gitLogFormat.
+func parseGitLog(log string) (revisions []*Revision, err os.Error) {
+	for _, entry := range strings.Split(log, "\x01", -1) {
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.Split(entry, "\x00", 5)
+		if len(fields) != 5 {
+			return nil, os.NewError("Bad git log entry: " + entry)
+		}
+
+		seconds, err := strconv.Atoi64(fields[2])
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, &Revision{
+			Commit:  fields[0],
+			Author:  fields[1],
+			Date:    time.SecondsToLocalTime(seconds),
+			Subject: fields[3],
+			Diff:    strings.TrimLeft(fields[4], "\n"),
+		})
+	}
+
+	return
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"exec"
+	"github.com/stevela/lwb/lwb"
+	"http"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const testGitPost = `---
+title: %s
+date: 2011-05-03T06:33:46Z
+---
+Hello.
+`
+
+func runGit(t *testing.T, repo string, args ...string) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repo
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s failed: %s: %s", args[0], err, out)
+	}
+}
+
+// newTestRepo creates a repository with a post that's been committed twice,
+// and then edited in the working tree.
+func newTestRepo(t *testing.T) (repo string) {
+	repo, err := ioutil.TempDir("", "git_store_test")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %s", err)
+	}
+
+	git := func(args ...string) { runGit(t, repo, args...) }
+	write := func(title string) {
+		data := strings.Replace(testGitPost, "%s", title, 1)
+		if err := ioutil.WriteFile(path.Join(repo, "posts", "hello.md"), []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %s", err)
+		}
+	}
+
+	git("init", "-q")
+	git("config", "user.name", "Test")
+	git("config", "user.email", "test@example.com")
+	if err = os.Mkdir(path.Join(repo, "posts"), 0755); err != nil {
+		t.Fatalf("Mkdir() failed: %s", err)
+	}
+
+	write("First")
+	git("add", "posts")
+	git("commit", "-q", "-m", "Add hello")
+	write("Second")
+	git("commit", "-q", "-a", "-m", "Retitle hello")
+	git("tag", "second")
+	write("Third")
+
+	return
+}
+
+func newTestGitStore(t *testing.T, repo, ref string) *gitStore {
+	*flagGitRepo, *flagGitPath, *flagGitRef = repo, "posts", ref
+
+	gs, err := NewGitStore(&lwb.BlogConfig{BlogUrl: &http.URL{Scheme: "http", Host: "example.com"}}, nil)
+	if err != nil {
+		t.Fatalf("NewGitStore() failed: %s", err)
+	}
+
+	return gs
+}
+
+func TestGitStore(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Logf("git not found, skipping")
+		return
+	}
+
+	repo := newTestRepo(t)
+	defer os.RemoveAll(repo)
+
+	for _, test := range []struct {
+		ref   string
+		title string
+	}{
+		{"", "Third"},
+		{"second", "Second"},
+		{"HEAD~1", "First"},
+	} {
+		gs := newTestGitStore(t, repo, test.ref)
+		if s := titles(gs.GetRecentPosts(10)); s != test.title {
+			t.Errorf("GetRecentPosts() at '%s' = '%s' want '%s'", test.ref, s, test.title)
+		}
+	}
+
+	gs := newTestGitStore(t, repo, "")
+	post := gs.GetRecentPosts(1)[0]
+	history, err := gs.GetPostHistory(post.Uuid)
+	if err != nil {
+		t.Fatalf("GetPostHistory() failed: %s", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("GetPostHistory() returned %d revisions want 2", len(history))
+	}
+	if r := history[0]; r.Subject != "Retitle hello" || r.Author != "Test <test@example.com>" ||
+		!strings.Contains(r.Diff, "+title: Second") || len(r.Commit) != 40 || r.Date == nil {
+		t.Errorf("GetPostHistory()[0] = %v", r)
+	}
+	if r := history[1]; r.Subject != "Add hello" || !strings.Contains(r.Diff, "+title: First") {
+		t.Errorf("GetPostHistory()[1] = %v", r)
+	}
+	if _, err := gs.GetPostHistory("missing"); err == nil {
+		t.Errorf("GetPostHistory() of a missing post succeeded")
+	}
+
+	// Reloading picks up new commits.
+	gs = newTestGitStore(t, repo, "HEAD")
+	runGit(t, repo, "commit", "-q", "-a", "-m", "Retitle hello again")
+	if err := gs.Reload(); err != nil {
+		t.Fatalf("Reload() failed: %s", err)
+	}
+	if s := titles(gs.GetRecentPosts(10)); s != "Third" {
+		t.Errorf("GetRecentPosts() after reload = '%s'", s)
+	}
+	if history, _ := gs.GetPostHistory(post.Uuid); len(history) != 3 {
+		t.Errorf("GetPostHistory() after reload returned %d revisions want 3", len(history))
+	}
+}
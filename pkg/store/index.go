@@ -0,0 +1,457 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const numMonthsPerYear = 12
+
+var monthNames = [...]string{"January", "February", "March", "April",
+	"May", "June", "July", "August", "September", "October", "November", "December"}
+
+type monthlyPosts struct {
+	byMonth [numMonthsPerYear][]*Post
+}
+
+// postIndex is an immutable snapshot of the contents of a store. Stores build
+// a new index whenever their contents change and swap it in atomically.
+type postIndex struct {
+	// Everything in the store, including drafts.
+	items []*Post
+
+	// A map of uuid -> post or page, including drafts.
+	postsByUuid map[string]*Post
+
+	// The pages in the store.
+	pages map[string]*Post
+
+	// The posts in the store.
+	posts []*Post
+
+	// A map of path -> post.
+	postsByPath map[string]*Post
+
+	// A map of year -> array of posts by month.
+	postsByYear map[int]*monthlyPosts
+
+	// A map of tag -> array of posts.
+	postsByTag map[string][]*Post
+
+	// A map of category -> array of posts.
+	postsByCategory map[string][]*Post
+
+	// A full text index of the published posts and pages.
+	search *searchIndex
+
+	// When the next scheduled item is due to appear, in seconds since the
+	// epoch, or 0 if nothing is scheduled.
+	nextPublish int64
+}
+
+// sort.Interface
+func (idx *postIndex) Len() int {
+	return len(idx.posts)
+}
+
+func (idx *postIndex) Less(i, j int) bool {
+	// Reverse sort.
+	return idx.posts[i].Published.Seconds() > idx.posts[j].Published.Seconds()
+}
+
+func (idx *postIndex) Swap(i, j int) {
+	tmp := idx.posts[i]
+	idx.posts[i] = idx.posts[j]
+	idx.posts[j] = tmp
+}
+
+// newPostIndex builds an index from the loaded posts and pages, computing
+// navigation and the date, tag and category archives. Only items that are
+// visible now appear in the archives; published or scheduled items with a
+// future date are held back until their time arrives.
+func newPostIndex(items []*Post) (idx *postIndex) {
+	now := time.Seconds()
+	idx = &postIndex{
+		items:           items,
+		postsByUuid:     make(map[string]*Post),
+		pages:           make(map[string]*Post),
+		postsByPath:     make(map[string]*Post),
+		postsByYear:     make(map[int]*monthlyPosts),
+		postsByTag:      make(map[string][]*Post),
+		postsByCategory: make(map[string][]*Post),
+	}
+
+	var visible []*Post
+	for _, item := range items {
+		idx.postsByUuid[item.Uuid] = item
+		if !item.IsVisibleAt(now) {
+			if item.IsPublished() || item.IsScheduled() {
+				if due := item.Published.Seconds(); idx.nextPublish == 0 || due < idx.nextPublish {
+					idx.nextPublish = due
+				}
+			}
+			continue
+		}
+
+		visible = append(visible, item)
+
+		if item.IsPage() {
+			idx.pages[item.Path] = item
+		} else {
+			idx.posts = append(idx.posts, item)
+			idx.postsByPath[item.Path] = item
+		}
+	}
+
+	sort.Sort(idx)
+
+	idx.search = newSearchIndex(visible)
+
+	for i := 0; i < len(idx.posts); i += 1 {
+		// Navigation.
+		post := idx.posts[i]
+		if i != 0 {
+			post.PreviousPath = idx.posts[i-1].Path
+			post.PreviousTitle = idx.posts[i-1].Title
+		}
+
+		if i != len(idx.posts)-1 {
+			post.NextPath = idx.posts[i+1].Path
+			post.NextTitle = idx.posts[i+1].Title
+		}
+
+		// Date based archives.
+		year := int(post.Published.Year)
+		zeroBasedMonth := int(post.Published.Month) - 1
+
+		monthPosts, foundYear := idx.postsByYear[year]
+		if !foundYear {
+			idx.postsByYear[year] = new(monthlyPosts)
+			monthPosts = idx.postsByYear[year]
+		}
+		monthPosts.byMonth[zeroBasedMonth] = append(monthPosts.byMonth[zeroBasedMonth], post)
+
+		// Other archives.
+		for _, tag := range post.Tags {
+			idx.postsByTag[tag] = append(idx.postsByTag[tag], post)
+		}
+
+		for _, category := range post.Categories {
+			idx.postsByCategory[category] = append(idx.postsByCategory[category], post)
+		}
+	}
+
+	return
+}
+
+// cloneAll copies items, dropping the item with uuid remove (if any) and
+// appending add (if not nil).
+func cloneAll(items []*Post, add *Post, remove string) (result []*Post) {
+	for _, item := range items {
+		if item.Uuid != remove {
+			result = append(result, item.Copy())
+		}
+	}
+
+	if add != nil {
+		result = append(result, add)
+	}
+
+	return
+}
+
+// indexedStore implements the read side of Store on top of a postIndex that
+// can be replaced while requests are being served.
+type indexedStore struct {
+	lock      sync.RWMutex
+	index     *postIndex
+	listeners []func()
+
+	// Fires when the next scheduled item in the index is due.
+	timer *time.Timer
+
+	// The files skipped when the store was last loaded.
+	problems []*LoadProblem
+}
+
+// current returns the index in use.
+func (is *indexedStore) current() *postIndex {
+	is.lock.RLock()
+	defer is.lock.RUnlock()
+
+	return is.index
+}
+
+// swap replaces the index and notifies any listeners.
+func (is *indexedStore) swap(idx *postIndex) {
+	is.lock.Lock()
+	is.setIndex(idx)
+	listeners := is.listeners
+	is.lock.Unlock()
+
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+// replace swaps in the index that results from an edit made through the
+// store. Listeners aren't notified, as whoever made the edit knows what
+// changed.
+func (is *indexedStore) replace(idx *postIndex) {
+	is.lock.Lock()
+	defer is.lock.Unlock()
+
+	is.setIndex(idx)
+}
+
+// setIndex replaces the index without notifying listeners, and arranges for it
+// to be rebuilt when its next scheduled item is due. The caller must hold the
+// lock unless the store is still being created.
+func (is *indexedStore) setIndex(idx *postIndex) {
+	is.index = idx
+
+	if is.timer != nil {
+		is.timer.Stop()
+		is.timer = nil
+	}
+
+	if idx.nextPublish != 0 {
+		delay := idx.nextPublish - time.Seconds()
+		if delay < 0 {
+			delay = 0
+		}
+
+		is.timer = time.AfterFunc(delay*1e9, func() { is.publishScheduled(idx) })
+	}
+}
+
+// publishScheduled rebuilds idx now that a scheduled item is due, unless idx
+// has been replaced in the meantime, in which case the replacement will have
+// been scheduled instead. Items are copied, as the navigation changes.
+func (is *indexedStore) publishScheduled(idx *postIndex) {
+	next := newPostIndex(cloneAll(idx.items, nil, ""))
+
+	is.lock.Lock()
+	if is.index != idx {
+		is.lock.Unlock()
+		return
+	}
+
+	is.setIndex(next)
+	listeners := is.listeners
+	is.lock.Unlock()
+
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+// LoadProblems returns the problems with the files that were skipped when the
+// store was last loaded, which only happens when it isn't strict.
+func (is *indexedStore) LoadProblems() []*LoadProblem {
+	is.lock.RLock()
+	defer is.lock.RUnlock()
+
+	return is.problems
+}
+
+// setProblems records the problems found when the store was last loaded.
+func (is *indexedStore) setProblems(problems []*LoadProblem) {
+	is.lock.Lock()
+	defer is.lock.Unlock()
+
+	is.problems = problems
+}
+
+// OnReload registers fn to be called whenever the store is reloaded or a
+// scheduled post is published.
+func (is *indexedStore) OnReload(fn func()) {
+	is.lock.Lock()
+	defer is.lock.Unlock()
+
+	is.listeners = append(is.listeners, fn)
+}
+
+// GetRecentPosts returns the most recent numPosts posts.
+func (is *indexedStore) GetRecentPosts(numPosts int) (posts []*Post) {
+	idx := is.current()
+	if numPosts > len(idx.posts) {
+		numPosts = len(idx.posts)
+	}
+
+	posts = idx.posts[:numPosts]
+
+	return
+}
+
+// NumPosts returns the number of published posts.
+func (is *indexedStore) NumPosts() int {
+	return len(is.current().posts)
+}
+
+// GetPostsPage returns up to n posts, newest first, after skipping the offset
+// newest.
+func (is *indexedStore) GetPostsPage(offset, n int) []*Post {
+	posts := is.current().posts
+	if offset < 0 || n <= 0 || offset >= len(posts) {
+		return nil
+	}
+	if n > len(posts)-offset {
+		n = len(posts) - offset
+	}
+
+	return posts[offset : offset+n]
+}
+
+// Search returns the published posts and pages matching a query, best matches
+// first. Every term in the query must match; text in double quotes matches a
+// phrase and a term ending in * matches any term starting with it.
+func (is *indexedStore) Search(query string) []*Post {
+	return is.current().search.search(query)
+}
+
+// GetAllPosts returns every post and page, including drafts and items that are
+// scheduled for later.
+func (is *indexedStore) GetAllPosts() []*Post {
+	return is.current().items
+}
+
+// GetPage returns a page with the given name.
+func (is *indexedStore) GetPage(name string) (post *Post, found bool) {
+	post, found = is.current().pages[name]
+
+	return
+}
+
+// GetPages returns all the published pages, ordered by path.
+func (is *indexedStore) GetPages() (pages []*Post) {
+	idx := is.current()
+
+	var paths []string
+	for path, _ := range idx.pages {
+		paths = append(paths, path)
+	}
+	sort.SortStrings(paths)
+
+	for _, path := range paths {
+		pages = append(pages, idx.pages[path])
+	}
+
+	return
+}
+
+// GetPostsByPath returns a post given a date based path.
+func (is *indexedStore) GetPostByPath(path string) (post *Post, found bool) {
+	post, found = is.current().postsByPath[path]
+
+	return
+}
+
+// GetPostByUuid returns a post or page, published or not, given its uuid.
+func (is *indexedStore) GetPostByUuid(uuid string) (post *Post, found bool) {
+	post, found = is.current().postsByUuid[uuid]
+
+	return
+}
+
+// GetPostsByYear returns all the posts for a given year.
+func (is *indexedStore) GetPostsByYear(year int) (posts []*Post, found bool) {
+	if monthPosts, foundPosts := is.current().postsByYear[year]; foundPosts {
+		for month := numMonthsPerYear - 1; month >= 0; month -= 1 {
+			for _, post := range monthPosts.byMonth[month] {
+				posts = append(posts, post)
+			}
+		}
+	}
+
+	found = len(posts) > 0
+
+	return
+}
+
+// GetPostsByYearMonth returns all the posts for a given year and month.
+func (is *indexedStore) GetPostsByYearMonth(year, month int) (posts []*Post, found bool) {
+	if month < 1 || month > numMonthsPerYear {
+		found = false
+	} else {
+		if monthPosts, foundPosts := is.current().postsByYear[year]; foundPosts {
+			posts = monthPosts.byMonth[month-1]
+		}
+
+		found = len(posts) != 0
+	}
+
+	return
+}
+
+// GetPostsByTag returns all the posts for a given tag.
+func (is *indexedStore) GetPostsByTag(tag string) (posts []*Post, found bool) {
+	posts, found = is.current().postsByTag[tag]
+
+	return
+}
+
+// GetPostsByCategory returns all the posts for a given category.
+func (is *indexedStore) GetPostsByCategory(category string) (posts []*Post, found bool) {
+	posts, found = is.current().postsByCategory[category]
+
+	return
+}
+
+// GetTags returns all the tags.
+func (is *indexedStore) GetTags() (tags []string) {
+	for tag, _ := range is.current().postsByTag {
+		tags = append(tags, tag)
+	}
+
+	return
+}
+
+// GetCategories returns all the categories.
+func (is *indexedStore) GetCategories() (categories []string) {
+	for category, _ := range is.current().postsByCategory {
+		categories = append(categories, category)
+	}
+
+	return
+}
+
+// GetArchives returns the yearly archives.
+func (is *indexedStore) GetArchives() (archives Archives) {
+	for year, posts := range is.current().postsByYear {
+		for month := 0; month < numMonthsPerYear; month += 1 {
+			if len(posts.byMonth[month]) != 0 {
+				archives = append(archives, newArchive(year, month))
+			}
+		}
+	}
+
+	sort.Sort(archives)
+
+	return
+}
+
+// newArchive returns the archive for a year and zero based month.
+func newArchive(year, month int) Archive {
+	return Archive{year, month,
+		fmt.Sprintf("%s %d", monthNames[month], year),
+		fmt.Sprintf("/%d/%02d/", year, month+1)}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestPost(uuid, status string, published int64) *Post {
+	return &Post{Uuid: uuid, Type: "post", Status: status, Title: uuid,
+		Path: "/" + uuid, Published: time.SecondsToUTC(published)}
+}
+
+func TestScheduledPosts(t *testing.T) {
+	now := time.Seconds()
+	idx := newPostIndex([]*Post{
+		newTestPost("old", "publish", now-60),
+		newTestPost("future", "publish", now+3600),
+		newTestPost("scheduled", "scheduled", now+60),
+		newTestPost("due", "scheduled", now-30),
+		newTestPost("draft", "draft", now-10),
+	})
+
+	if len(idx.posts) != 2 || idx.posts[0].Uuid != "due" || idx.posts[1].Uuid != "old" {
+		t.Errorf("got %d visible posts", len(idx.posts))
+	}
+	if _, found := idx.postsByPath["/scheduled"]; found {
+		t.Errorf("scheduled post is visible early")
+	}
+	if _, found := idx.postsByUuid["scheduled"]; !found {
+		t.Errorf("scheduled post is missing")
+	}
+	if idx.nextPublish != now+60 {
+		t.Errorf("nextPublish = %d want %d", idx.nextPublish, now+60)
+	}
+}
+
+func TestPublishScheduled(t *testing.T) {
+	is := new(indexedStore)
+	is.setIndex(newPostIndex([]*Post{newTestPost("soon", "scheduled", time.Seconds()+1)}))
+
+	reloaded := make(chan bool, 1)
+	is.OnReload(func() { reloaded <- true })
+
+	if len(is.GetRecentPosts(10)) != 0 {
+		t.Fatalf("scheduled post is visible early")
+	}
+
+	<-reloaded
+	if posts := is.GetRecentPosts(10); len(posts) != 1 || posts[0].Uuid != "soon" {
+		t.Errorf("scheduled post was not published")
+	}
+}
+
+var postsPageTests = []struct {
+	offset, n int
+	want      string
+}{
+	{0, 2, "c,b"},
+	{2, 2, "a"},
+	{1, 10, "b,a"},
+	{3, 1, ""},
+	{0, 0, ""},
+	{-1, 2, ""},
+}
+
+func TestGetPostsPage(t *testing.T) {
+	now := time.Seconds()
+	is := new(indexedStore)
+	is.setIndex(newPostIndex([]*Post{
+		newTestPost("a", "publish", now-30),
+		newTestPost("b", "publish", now-20),
+		newTestPost("c", "publish", now-10),
+		newTestPost("draft", "draft", now-5),
+	}))
+
+	if n := is.NumPosts(); n != 3 {
+		t.Errorf("NumPosts() = %d want 3", n)
+	}
+	for _, pt := range postsPageTests {
+		if s := titles(is.GetPostsPage(pt.offset, pt.n)); s != pt.want {
+			t.Errorf("GetPostsPage(%d, %d) = '%s' want '%s'", pt.offset, pt.n, s, pt.want)
+		}
+	}
+}
@@ -17,295 +17,305 @@ limitations under the License.
 package store
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"github.com/stevela/lwb/lwb"
 	"io/ioutil"
 	"json"
+	"log"
 	"os"
 	"path"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 var flagJsonPath *string = flag.String("json_dir", "json_store", "Path to the json store")
 
 const (
-	postSuffix       = ".post"
-	pageSuffix       = ".page"
-	timeFormat       = "Mon Jan 2 15:04:05 MST 2006"
-	numMonthsPerYear = 12
+	postSuffix = ".post"
+	pageSuffix = ".page"
+	bodySuffix = ".body"
+
+	// Dates are written in RFC 3339 format, with an offset from UTC, but the
+	// legacy format, with a zone abbreviation, can still be read.
+	timeFormat       = time.RFC3339
+	legacyTimeFormat = "Mon Jan 2 15:04:05 MST 2006"
 )
 
-var monthNames = [...]string{"January", "February", "March", "April",
-	"May", "June", "July", "August", "September", "October", "November", "December"}
-
-type monthlyPosts struct {
-	byMonth [numMonthsPerYear][]*Post
+// The offsets from UTC of the zone abbreviations accepted in legacy dates.
+// Parsing only knows the offset of the local zone, so the others are filled
+// in from here.
+var zoneOffsets = map[string]int{
+	"UTC":  0,
+	"GMT":  0,
+	"BST":  1 * 60 * 60,
+	"CET":  1 * 60 * 60,
+	"CEST": 2 * 60 * 60,
+	"EST":  -5 * 60 * 60,
+	"EDT":  -4 * 60 * 60,
+	"CST":  -6 * 60 * 60,
+	"CDT":  -5 * 60 * 60,
+	"MST":  -7 * 60 * 60,
+	"MDT":  -6 * 60 * 60,
+	"PST":  -8 * 60 * 60,
+	"PDT":  -7 * 60 * 60,
+	"AKST": -9 * 60 * 60,
+	"AKDT": -8 * 60 * 60,
+	"HST":  -10 * 60 * 60,
 }
 
 type jsonStore struct {
-	// The pages in the store.
-	pages map[string]*Post
-
-	// The posts in the store.
-	posts []*Post
-
-	// A map of path -> post.
-	postsByPath map[string]*Post
-
-	// A map of year -> array of posts by month.
-	postsByYear map[int]*monthlyPosts
-
-	// A map of tag -> array of posts.
-	postsByTag map[string][]*Post
+	indexedStore
 
-	// A map of category -> array of posts.
-	postsByCategory map[string][]*Post
-}
+	// The directory the store is loaded from.
+	dir string
 
-// sort.Interface
-func (js *jsonStore) Len() int {
-	return len(js.posts)
-}
+	config       *lwb.BlogConfig
+	postLoadHook func(*Post)
 
-func (js *jsonStore) Less(i, j int) bool {
-	// Reverse sort.
-	return js.posts[i].Published.Seconds() > js.posts[j].Published.Seconds()
-}
-
-func (js *jsonStore) Swap(i, j int) {
-	tmp := js.posts[i]
-	js.posts[i] = js.posts[j]
-	js.posts[j] = tmp
+	// Serializes reloads and edits.
+	writeLock sync.Mutex
 }
 
 // NewJsonStore creates a new store. If not nil, postLoadHook can be used to make modifications to
-// the post after it has been loaded.
+// the post after it has been loaded. If any posts can't be loaded, a strict store fails with a
+// *LoadError, while a lenient one skips them.
 func NewJsonStore(config *lwb.BlogConfig, postLoadHook func(*Post)) (js *jsonStore, err os.Error) {
-	// Load all the posts and pages.
-	fileInfos, err := ioutil.ReadDir(*flagJsonPath)
+	js = &jsonStore{
+		dir:          *flagJsonPath,
+		config:       config,
+		postLoadHook: postLoadHook,
+	}
+
+	items, problems, err := js.load()
 	if err != nil {
-		panic("Failed to scan for posts: " + err.String())
+		return nil, err
 	}
 
-	js = new(jsonStore)
+	js.problems = problems
+	js.setIndex(newPostIndex(items))
 
-	// Build the store.
-	js = &jsonStore{
-		pages:           make(map[string]*Post),
-		postsByPath:     make(map[string]*Post),
-		postsByYear:     make(map[int]*monthlyPosts),
-		postsByTag:      make(map[string][]*Post),
-		postsByCategory: make(map[string][]*Post),
+	return
+}
+
+// load reads all the posts and pages from disk, along with the problems with
+// any files that had to be skipped.
+func (js *jsonStore) load() (items []*Post, problems []*LoadProblem, err os.Error) {
+	// Load all the posts and pages.
+	fileInfos, err := ioutil.ReadDir(js.dir)
+	if err != nil {
+		return nil, nil, os.NewError("Failed to scan for posts: " + err.String())
 	}
 
+	report := newLoadReport(js.dir)
 	for _, fileInfo := range fileInfos {
 		if !strings.HasSuffix(fileInfo.Name, postSuffix) &&
 			!strings.HasSuffix(fileInfo.Name, pageSuffix) {
 			continue
 		}
 
-		data, err := ioutil.ReadFile(path.Join(*flagJsonPath, fileInfo.Name))
+		data, err := ioutil.ReadFile(path.Join(js.dir, fileInfo.Name))
 		if err != nil {
-			panic("Failed to read file: " + err.String())
-		}
-
-		item := new(Post)
-		if err = json.Unmarshal(data, item); err != nil {
-			panic("Failed to parse item: " + err.String())
-		}
-
-		if !item.IsPublished() {
+			report.add(fileInfo.Name, os.NewError("Failed to read file: "+err.String()))
 			continue
 		}
 
-		if len(item.Body) == 0 {
-			// Try loading from external page.
-			ext := path.Ext(fileInfo.Name)
-			base := fileInfo.Name[0 : len(fileInfo.Name)-len(ext)]
-			data, err := ioutil.ReadFile(path.Join(*flagJsonPath, fmt.Sprintf("%s.body", base)))
-			if err == nil {
-				item.Body = string(data)
-			}
+		item, err := parseJsonItem(fileInfo.Name, data, func(name string) ([]byte, os.Error) {
+			return ioutil.ReadFile(path.Join(js.dir, name))
+		})
+		if err == nil {
+			err = js.prepare(item)
 		}
-
-		if len(item.Body) == 0 {
-			panic("No body in post for " + fileInfo.Name)
+		if err != nil {
+			report.add(fileInfo.Name, err)
+			continue
 		}
 
-		// Convert times.
-		if item.LastModified, err = time.Parse(timeFormat, item.LastModifiedDate); err != nil {
-			panic("Failed to parse last modified time: " + err.String())
-		}
-		if item.Published, err = time.Parse(timeFormat, item.PublishedDate); err != nil {
-			panic("Failed to parse published time: " + err.String())
-		}
+		items = append(items, item)
+	}
 
-		// Type.
-		switch item.Type {
-		case "post":
-			item.Path = fmt.Sprintf("/%d/%.02d/%s",
-				item.Published.Year, item.Published.Month, item.Basename)
-
-			js.posts = append(js.posts, item)
-			js.postsByPath[item.Path] = item
-		case "page":
-			item.Path = fmt.Sprintf("/page/%s", item.Basename)
-			js.pages[item.Path] = item
-		default:
-			panic("Unknown item type: " + item.Type)
-		}
+	return items, report.problems, report.err()
+}
 
-		item.CanonicalBlogUrl = config.BlogUrl
-		item.CanonicalPath = item.Path
+// parseJsonItem creates a post or page from the contents of a .post or .page
+// file. If the body isn't in the file, it's read from the .body file of the
+// same name using readFile.
+func parseJsonItem(name string, data []byte, readFile func(name string) ([]byte, os.Error)) (*Post, os.Error) {
+	item := new(Post)
+	if err := json.Unmarshal(data, item); err != nil {
+		return nil, fieldProblem("", "Failed to parse item: "+err.String())
+	}
 
-		// Run hook.
-		if postLoadHook != nil {
-			postLoadHook(item)
+	base := name[:len(name)-len(path.Ext(name))]
+	if len(item.Body) == 0 {
+		// Try loading from external page.
+		if data, err := readFile(base + bodySuffix); err == nil {
+			item.Body = string(data)
 		}
 	}
 
-	sort.Sort(js)
+	if err := checkBody(item); err != nil {
+		return nil, err
+	}
 
-	for i := 0; i < len(js.posts); i += 1 {
-		// Navigation.
-		post := js.posts[i]
-		if i != 0 {
-			post.PreviousPath = js.posts[i-1].Path
-			post.PreviousTitle = js.posts[i-1].Title
-		}
+	if err := parseDates(item); err != nil {
+		return nil, err
+	}
 
-		if i != len(js.posts)-1 {
-			post.NextPath = js.posts[i+1].Path
-			post.NextTitle = js.posts[i+1].Title
-		}
+	item.file = base
 
-		// Date based archives.
-		year := int(post.Published.Year)
-		zeroBasedMonth := int(post.Published.Month) - 1
+	return item, nil
+}
 
-		monthPosts, foundYear := js.postsByYear[year]
-		if !foundYear {
-			js.postsByYear[year] = new(monthlyPosts)
-			monthPosts = js.postsByYear[year]
-		}
-		monthPosts.byMonth[zeroBasedMonth] = append(monthPosts.byMonth[zeroBasedMonth], post)
+// checkBody returns a problem if a post that is, or will be, visible has no
+// body. It's checked both when loading and saving posts, so that the store
+// never writes anything it can't load again.
+func checkBody(item *Post) os.Error {
+	if len(item.Body) == 0 && (item.IsPublished() || item.IsScheduled()) {
+		return fieldProblem("body", "No body in published or scheduled post")
+	}
 
-		// Other archives.
-		for _, tag := range post.Tags {
-			js.postsByTag[tag] = append(js.postsByTag[tag], post)
-		}
+	return nil
+}
 
-		for _, category := range post.Categories {
-			js.postsByCategory[category] = append(js.postsByCategory[category], post)
-		}
+// parsePostTime parses a date in a post file, in either RFC 3339 or the legacy
+// format.
+func parsePostTime(value string) (t *time.Time, err os.Error) {
+	if t, err = time.Parse(timeFormat, value); err == nil {
+		return
+	}
+	if t, err = time.Parse(legacyTimeFormat, value); err == nil {
+		fixZoneOffset(t)
 	}
 
 	return
 }
 
-// GetRecentPosts returns the most recent numPosts posts.
-func (js *jsonStore) GetRecentPosts(numPosts int) (posts []*Post) {
-	if numPosts > len(js.posts) {
-		numPosts = len(js.posts)
+// fixZoneOffset fills in the offset of a time parsed with a zone abbreviation
+// other than the local one.
+func fixZoneOffset(t *time.Time) {
+	if offset, found := zoneOffsets[t.Zone]; found && t.ZoneOffset == 0 {
+		t.ZoneOffset = offset
 	}
-
-	posts = js.posts[:numPosts]
-
-	return
 }
 
-// GetPage returns a page with the given name.
-func (js *jsonStore) GetPage(name string) (post *Post, found bool) {
-	post, found = js.pages[name]
+// parseDates converts the dates of a loaded post.
+func parseDates(item *Post) (err os.Error) {
+	if item.LastModified, err = parsePostTime(item.LastModifiedDate); err != nil {
+		return fieldProblem("lastModifiedDate", "Failed to parse last modified time: "+err.String())
+	}
+	if item.Published, err = parsePostTime(item.PublishedDate); err != nil {
+		return fieldProblem("publishedDate", "Failed to parse published time: "+err.String())
+	}
 
 	return
 }
 
-// GetPostsByPath returns a post given a date based path.
-func (js *jsonStore) GetPostByPath(path string) (post *Post, found bool) {
-	post, found = js.postsByPath[path]
-
-	return
+// prepare computes the paths of a loaded post and runs the post load hook.
+func (js *jsonStore) prepare(item *Post) os.Error {
+	return preparePost(item, js.config, js.postLoadHook)
 }
 
-// GetPostsByYear returns all the posts for a given year.
-func (js *jsonStore) GetPostsByYear(year int) (posts []*Post, found bool) {
-	if monthPosts, foundPosts := js.postsByYear[year]; foundPosts {
-		for month := numMonthsPerYear - 1; month >= 0; month -= 1 {
-			for _, post := range monthPosts.byMonth[month] {
-				posts = append(posts, post)
-			}
-		}
+// preparePost moves the dates of a loaded post into the blog's time zone,
+// computes its paths and runs postLoadHook, if not nil. It is shared by all the
+// stores so that they produce identical posts.
+func preparePost(item *Post, config *lwb.BlogConfig, postLoadHook func(*Post)) os.Error {
+	// The archives, and so the paths, go by the dates in the blog's time
+	// zone, or if it has none, the zones the dates were written in.
+	item.Published = config.TimeZone.In(item.Published)
+	item.LastModified = config.TimeZone.In(item.LastModified)
+
+	// Type.
+	switch item.Type {
+	case "post":
+		item.Path = fmt.Sprintf("/%d/%.02d/%s",
+			item.Published.Year, item.Published.Month, item.Basename)
+	case "page":
+		item.Path = fmt.Sprintf("/page/%s", item.Basename)
+	default:
+		return fieldProblem("type", "Unknown item type: "+item.Type)
 	}
 
-	found = len(posts) > 0
+	item.CanonicalBlogUrl = config.BlogUrl
+	item.CanonicalPath = item.Path
 
-	return
+	// Run hook.
+	if postLoadHook != nil {
+		postLoadHook(item)
+	}
+
+	return nil
 }
 
-// GetPostsByYearMonth returns all the posts for a given year and month.
-func (js *jsonStore) GetPostsByYearMonth(year, month int) (posts []*Post, found bool) {
-	if month < 1 || month > numMonthsPerYear {
-		found = false
-	} else {
-		if monthPosts, foundPosts := js.postsByYear[year]; foundPosts {
-			posts = monthPosts.byMonth[month-1]
-		}
+// Reload rereads the store from disk and swaps in the new contents. The
+// existing contents are kept if the store fails to load.
+func (js *jsonStore) Reload() os.Error {
+	js.writeLock.Lock()
+	defer js.writeLock.Unlock()
 
-		found = len(posts) != 0
+	items, problems, err := js.load()
+	if err != nil {
+		return err
 	}
 
-	return
-}
-
-// GetPostsByTag returns all the posts for a given tag.
-func (js *jsonStore) GetPostsByTag(tag string) (posts []*Post, found bool) {
-	posts, found = js.postsByTag[tag]
+	js.setProblems(problems)
+	js.swap(newPostIndex(items))
 
-	return
+	return nil
 }
 
-// GetPostsByCategory returns all the posts for a given category.
-func (js *jsonStore) GetPostsByCategory(category string) (posts []*Post, found bool) {
-	posts, found = js.postsByCategory[category]
+// signature returns a string that changes whenever a post, page or body file
+// in the store is added, removed or modified.
+func (js *jsonStore) signature() string {
+	return dirSignature(js.dir, postSuffix, pageSuffix, bodySuffix)
+}
 
-	return
+// Watch polls the store directory every intervalNs nanoseconds and reloads
+// the store when anything in it changes.
+func (js *jsonStore) Watch(intervalNs int64) {
+	watchStore(intervalNs, func() string { return js.signature() }, func() os.Error { return js.Reload() })
 }
 
-// GetTags returns all the tags.
-func (js *jsonStore) GetTags() (tags []string) {
-	for tag, _ := range js.postsByTag {
-		tags = append(tags, tag)
+// dirSignature returns a string that changes whenever a file in dir with one
+// of the given suffixes is added, removed or modified.
+func dirSignature(dir string, suffixes ...string) string {
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
 	}
 
-	return
-}
-
-// GetCategories returns all the categories.
-func (js *jsonStore) GetCategories() (categories []string) {
-	for category, _ := range js.postsByCategory {
-		categories = append(categories, category)
+	var buf bytes.Buffer
+	for _, fileInfo := range fileInfos {
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(fileInfo.Name, suffix) {
+				fmt.Fprintf(&buf, "%s %d %d\n", fileInfo.Name, fileInfo.Mtime_ns, fileInfo.Size)
+				break
+			}
+		}
 	}
 
-	return
+	return buf.String()
 }
 
-// GetArchives returns the yearly archives.
-func (js *jsonStore) GetArchives() (archives Archives) {
-	for year, posts := range js.postsByYear {
-		for month := 0; month < numMonthsPerYear; month += 1 {
-			if len(posts.byMonth[month]) != 0 {
-				archives = append(archives, Archive{year, month,
-					fmt.Sprintf("%s %d", monthNames[month], year),
-					fmt.Sprintf("/%d/%02d/", year, month+1)})
+// watchStore calls signature every intervalNs nanoseconds and calls reload
+// whenever the result differs from the last one, starting with its result
+// before watchStore returns.
+func watchStore(intervalNs int64, signature func() string, reload func() os.Error) {
+	last := signature()
+	go func() {
+		for _ = range time.Tick(intervalNs) {
+			current := signature()
+			if current == last {
+				continue
 			}
-		}
-	}
 
-	sort.Sort(archives)
+			if err := reload(); err != nil {
+				log.Println(err)
+			}
 
-	return
+			last = current
+		}
+	}()
 }
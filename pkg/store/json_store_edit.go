@@ -0,0 +1,283 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"json"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// SavePost creates a new post or page, assigning it a uuid if it doesn't
+// already have one. Missing fields are given sensible defaults.
+func (js *jsonStore) SavePost(post *Post) (err os.Error) {
+	js.writeLock.Lock()
+	defer js.writeLock.Unlock()
+
+	idx := js.current()
+
+	item := post.Copy()
+	if _, found := idx.postsByUuid[item.Uuid]; found {
+		return os.NewError("Post already exists: " + item.Uuid)
+	}
+	if err = setDefaults(item); err != nil {
+		return
+	}
+	item.file = item.Uuid
+
+	if err = js.write(item, nil); err != nil {
+		return
+	}
+
+	js.replace(newPostIndex(cloneAll(idx.items, item, "")))
+	*post = *item
+
+	return
+}
+
+// UpdatePost replaces the post or page with the same uuid.
+func (js *jsonStore) UpdatePost(post *Post) (err os.Error) {
+	js.writeLock.Lock()
+	defer js.writeLock.Unlock()
+
+	return js.update(post.Copy())
+}
+
+// SetPostStatus changes the status ("publish", "scheduled" or "draft") of a
+// post or page.
+func (js *jsonStore) SetPostStatus(uuid, status string) os.Error {
+	js.writeLock.Lock()
+	defer js.writeLock.Unlock()
+
+	old, found := js.current().postsByUuid[uuid]
+	if !found {
+		return os.NewError("No such post: " + uuid)
+	}
+
+	item := old.Copy()
+	item.Status = status
+
+	return js.update(item)
+}
+
+// DeletePost removes the post or page with the given uuid.
+func (js *jsonStore) DeletePost(uuid string) os.Error {
+	js.writeLock.Lock()
+	defer js.writeLock.Unlock()
+
+	idx := js.current()
+	old, found := idx.postsByUuid[uuid]
+	if !found {
+		return os.NewError("No such post: " + uuid)
+	}
+
+	if err := os.Remove(js.fileName(old.file, old.Type)); err != nil {
+		return err
+	}
+	os.Remove(path.Join(js.dir, old.file+bodySuffix))
+
+	js.replace(newPostIndex(cloneAll(idx.items, nil, uuid)))
+
+	return nil
+}
+
+// update writes an edited item and replaces the index with one containing it.
+// The caller must hold the write lock.
+func (js *jsonStore) update(item *Post) os.Error {
+	idx := js.current()
+	old, found := idx.postsByUuid[item.Uuid]
+	if !found {
+		return os.NewError("No such post: " + item.Uuid)
+	}
+
+	if item.Published == nil {
+		item.Published = old.Published
+	}
+	item.file = old.file
+
+	if err := js.write(item, old); err != nil {
+		return err
+	}
+
+	js.replace(newPostIndex(cloneAll(idx.items, item, item.Uuid)))
+
+	return nil
+}
+
+// write brings the files on disk up to date with item, which replaces old if
+// old is not nil. Both files are written in full before either is renamed into
+// place, so a failed write leaves the old version intact.
+func (js *jsonStore) write(item *Post, old *Post) (err os.Error) {
+	if err = checkBody(item); err != nil {
+		return
+	}
+
+	item.LastModified = time.LocalTime()
+	item.LastModifiedDate = item.LastModified.Format(timeFormat)
+	item.PublishedDate = item.Published.Format(timeFormat)
+
+	if err = js.prepare(item); err != nil {
+		return
+	}
+
+	data, err := json.MarshalIndent(postRecord(item), "", "    ")
+	if err != nil {
+		return
+	}
+
+	// The body always lives in a separate file so that it can be edited by hand.
+	bodyName := path.Join(js.dir, item.file+bodySuffix)
+	bodyTemp, err := writeTempFile(bodyName, []byte(item.Body))
+	if err != nil {
+		return
+	}
+	defer os.Remove(bodyTemp)
+
+	itemName := js.fileName(item.file, item.Type)
+	itemTemp, err := writeTempFile(itemName, data)
+	if err != nil {
+		return
+	}
+	defer os.Remove(itemTemp)
+
+	if err = os.Rename(bodyTemp, bodyName); err != nil {
+		return
+	}
+	if err = os.Rename(itemTemp, itemName); err != nil {
+		return
+	}
+
+	if old != nil && old.Type != item.Type {
+		os.Remove(js.fileName(old.file, old.Type))
+	}
+
+	return
+}
+
+// setDefaults assigns a new post or page a uuid, if it doesn't already have
+// one, and gives any other missing fields sensible defaults.
+func setDefaults(item *Post) (err os.Error) {
+	if item.Uuid == "" {
+		if item.Uuid, err = newUuid(); err != nil {
+			return
+		}
+	}
+
+	if item.Type == "" {
+		item.Type = "post"
+	}
+	if item.Status == "" {
+		item.Status = "draft"
+	}
+	if item.Format == "" {
+		item.Format = "textile"
+	}
+	if item.Basename == "" {
+		item.Basename = makeBasename(item.Title)
+	}
+	if item.Published == nil {
+		item.Published = time.LocalTime()
+	}
+
+	return
+}
+
+// postRecord returns the stored fields of a post, other than its body, keyed
+// by their names in a .post file.
+func postRecord(item *Post) map[string]interface{} {
+	return map[string]interface{}{
+		"basename":         item.Basename,
+		"categories":       item.Categories,
+		"commentOnPage":    item.CommentOnPage,
+		"format":           item.Format,
+		"isOldEntry":       item.IsOldEntry,
+		"lastModifiedDate": item.LastModifiedDate,
+		"publishedDate":    item.PublishedDate,
+		"status":           item.Status,
+		"tags":             item.Tags,
+		"title":            item.Title,
+		"type":             item.Type,
+		"uuid":             item.Uuid,
+	}
+}
+
+// fileName returns the path of the file holding an item of the given type.
+func (js *jsonStore) fileName(base, itemType string) string {
+	if itemType == "page" {
+		return path.Join(js.dir, base+pageSuffix)
+	}
+
+	return path.Join(js.dir, base+postSuffix)
+}
+
+// writeTempFile writes data to a temporary file in the same directory as
+// filename, to be renamed into place once complete, so that readers never see
+// a partially written file. It returns the name of the temporary file.
+func writeTempFile(filename string, data []byte) (string, os.Error) {
+	dir, base := path.Split(filename)
+	f, err := ioutil.TempFile(dir, "."+base)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// newUuid returns a random (version 4) uuid.
+func newUuid() (string, os.Error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// makeBasename derives a basename from a title, e.g. "Hello, World" becomes
+// "hello_world".
+func makeBasename(title string) string {
+	parts := strings.FieldsFunc(strings.ToLower(title), func(c int) bool {
+		return !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9')
+	})
+
+	if len(parts) == 0 {
+		return "untitled"
+	}
+
+	return strings.Join(parts, "_")
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"github.com/stevela/lwb/lwb"
+	"http"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var jsonStoreFiles = map[string]string{
+	"good.post": `{"title": "Good", "basename": "good", "type": "post", "status": "publish",
+		"uuid": "good", "lastModifiedDate": "Mon May 02 22:33:46 PST 2011",
+		"publishedDate": "Mon May 02 22:33:46 PST 2011"}`,
+	"good.body": "Hello.",
+	"json.post": `{"title": "Bad JSON",`,
+	"nobody.post": `{"title": "No body", "basename": "nobody", "type": "post", "status": "publish",
+		"uuid": "nobody", "lastModifiedDate": "Mon May 02 22:33:46 PST 2011",
+		"publishedDate": "Mon May 02 22:33:46 PST 2011"}`,
+	"date.post": `{"title": "Bad date", "body": "Hi", "type": "post", "status": "publish",
+		"uuid": "date", "lastModifiedDate": "Mon May 02 22:33:46 PST 2011",
+		"publishedDate": "yesterday"}`,
+	"type.page": `{"title": "Bad type", "body": "Hi", "type": "note", "status": "publish",
+		"uuid": "type", "lastModifiedDate": "Mon May 02 22:33:46 PST 2011",
+		"publishedDate": "Mon May 02 22:33:46 PST 2011"}`,
+}
+
+// The problems with jsonStoreFiles, in the order they are found.
+var jsonStoreProblems = []LoadProblem{
+	{"date.post", "publishedDate", ""},
+	{"json.post", "", ""},
+	{"nobody.post", "body", ""},
+	{"type.page", "type", ""},
+}
+
+func checkProblems(t *testing.T, problems []*LoadProblem) {
+	if len(problems) != len(jsonStoreProblems) {
+		t.Fatalf("found %d problems want %d: %v", len(problems), len(jsonStoreProblems), problems)
+	}
+	for i, p := range problems {
+		want := jsonStoreProblems[i]
+		if p.File != want.File || p.Field != want.Field || p.Problem == "" {
+			t.Errorf("problem %d = %s want file '%s' and field '%s'", i, p, want.File, want.Field)
+		}
+	}
+}
+
+func TestJsonStoreLoading(t *testing.T) {
+	dir, err := ioutil.TempDir("", "json_store_test")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, data := range jsonStoreFiles {
+		if err = ioutil.WriteFile(path.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %s", err)
+		}
+	}
+
+	config := &lwb.BlogConfig{BlogUrl: &http.URL{Scheme: "http", Host: "example.com"}}
+	*flagJsonPath = dir
+	defer func() { *flagStrict = true }()
+
+	// A strict store fails, listing every problem.
+	*flagStrict = true
+	if _, err = NewJsonStore(config, nil); err == nil {
+		t.Fatalf("NewJsonStore() succeeded in strict mode")
+	}
+	if loadErr, ok := err.(*LoadError); !ok {
+		t.Errorf("NewJsonStore() failed with %v want a *LoadError", err)
+	} else {
+		checkProblems(t, loadErr.Problems)
+	}
+
+	// A lenient store skips the bad posts.
+	*flagStrict = false
+	js, err := NewJsonStore(config, nil)
+	if err != nil {
+		t.Fatalf("NewJsonStore() failed in lenient mode: %s", err)
+	}
+	if s := titles(js.GetAllPosts()); s != "Good" {
+		t.Errorf("GetAllPosts() = '%s' want 'Good'", s)
+	}
+	checkProblems(t, js.LoadProblems())
+}
+
+var postTimeTests = []struct {
+	in      string
+	seconds int64
+}{
+	{"Mon May 02 22:33:46 PST 2011", 1304404426},
+	{"Mon May 02 22:33:46 PDT 2011", 1304400826},
+	{"Mon May 02 22:33:46 UTC 2011", 1304375626},
+	{"2011-05-02T22:33:46-07:00", 1304400826},
+	{"2011-05-03T05:33:46Z", 1304400826},
+}
+
+func TestParsePostTime(t *testing.T) {
+	for _, pt := range postTimeTests {
+		if tm, err := parsePostTime(pt.in); err != nil || tm.Seconds() != pt.seconds {
+			t.Errorf("parsePostTime(%q) = %v, %v want %d", pt.in, tm, err, pt.seconds)
+		}
+	}
+
+	if _, err := parsePostTime("May 2 2011"); err == nil {
+		t.Errorf("parsePostTime() accepted a bad date")
+	}
+}
+
+func TestPreparePostTimeZone(t *testing.T) {
+	zone, err := lwb.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Logf("LoadLocation() failed, skipping: %s", err)
+		return
+	}
+
+	// Early on June 1st in UTC is still May 31st in Seattle.
+	config := &lwb.BlogConfig{BlogUrl: &http.URL{Scheme: "http", Host: "example.com"}}
+	for _, zt := range []struct {
+		zone *lwb.Location
+		path string
+	}{
+		{nil, "/2011/06/hello"},
+		{zone, "/2011/05/hello"},
+	} {
+		published, _ := parsePostTime("2011-06-01T05:00:00Z")
+		item := &Post{Type: "post", Basename: "hello", Published: published, LastModified: published}
+		config.TimeZone = zt.zone
+		if err := preparePost(item, config, nil); err != nil {
+			t.Fatalf("preparePost() failed: %s", err)
+		}
+		if item.Path != zt.path || item.Published.Seconds() != published.Seconds() {
+			t.Errorf("preparePost() in %v gave %s at %v want %s", zt.zone, item.Path, item.Published, zt.path)
+		}
+	}
+}
+
+// storeFiles returns the names of the files in a store's directory, which
+// shouldn't include any temporary files left over from writing.
+func storeFiles(t *testing.T, dir string) string {
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("Readdirnames() failed: %s", err)
+	}
+	sort.SortStrings(names)
+
+	return strings.Join(names, ",")
+}
+
+func TestJsonStoreEditing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "json_store_test")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &lwb.BlogConfig{BlogUrl: &http.URL{Scheme: "http", Host: "example.com"}}
+	*flagJsonPath = dir
+	js, err := NewJsonStore(config, nil)
+	if err != nil {
+		t.Fatalf("NewJsonStore() failed: %s", err)
+	}
+
+	// Edits don't notify the reload listeners, only reloads do.
+	reloads := 0
+	js.OnReload(func() { reloads++ })
+
+	// Visible posts need a body, as the store couldn't load them otherwise.
+	if err = js.SavePost(&Post{Uuid: "empty", Title: "Empty", Status: "publish"}); err == nil {
+		t.Errorf("SavePost() accepted a published post without a body")
+	}
+	if err = js.SavePost(&Post{Uuid: "later", Title: "Later", Status: "scheduled"}); err == nil {
+		t.Errorf("SavePost() accepted a scheduled post without a body")
+	}
+	if s := storeFiles(t, dir); s != "" {
+		t.Errorf("failed saves left files '%s'", s)
+	}
+
+	post := &Post{Uuid: "hello", Title: "Hello", Status: "publish", Body: "Hello."}
+	if err = js.SavePost(post); err != nil {
+		t.Fatalf("SavePost() failed: %s", err)
+	}
+	if post.Path == "" || post.Basename != "hello" {
+		t.Errorf("SavePost() gave path '%s' and basename '%s'", post.Path, post.Basename)
+	}
+	if err = js.SavePost(&Post{Uuid: "hello", Title: "Again", Body: "Again."}); err == nil {
+		t.Errorf("SavePost() accepted a duplicate uuid")
+	}
+
+	edited := post.Copy()
+	edited.Title = "Hello, again"
+	edited.Body = ""
+	if err = js.UpdatePost(edited); err == nil {
+		t.Errorf("UpdatePost() accepted a published post without a body")
+	}
+	edited.Body = "Hello again."
+	if err = js.UpdatePost(edited); err != nil {
+		t.Fatalf("UpdatePost() failed: %s", err)
+	}
+	if s := titles(js.GetRecentPosts(10)); s != "Hello, again" {
+		t.Errorf("GetRecentPosts() after edit = '%s'", s)
+	}
+
+	draft := &Post{Uuid: "draft", Title: "Draft"}
+	if err = js.SavePost(draft); err != nil {
+		t.Fatalf("SavePost() of a draft without a body failed: %s", err)
+	}
+	if err = js.SetPostStatus("draft", "publish"); err == nil {
+		t.Errorf("SetPostStatus() published a post without a body")
+	}
+	if err = js.SetPostStatus("hello", "draft"); err != nil {
+		t.Fatalf("SetPostStatus() failed: %s", err)
+	}
+	if s := titles(js.GetRecentPosts(10)); s != "" {
+		t.Errorf("GetRecentPosts() after unpublishing = '%s'", s)
+	}
+	if s := storeFiles(t, dir); s != "draft.body,draft.post,hello.body,hello.post" {
+		t.Errorf("store files = '%s'", s)
+	}
+
+	if err = js.DeletePost("draft"); err != nil {
+		t.Fatalf("DeletePost() failed: %s", err)
+	}
+	if err = js.DeletePost("draft"); err == nil {
+		t.Errorf("DeletePost() of a missing post succeeded")
+	}
+
+	if reloads != 0 {
+		t.Errorf("editing notified the reload listeners %d times", reloads)
+	}
+	if err = js.Reload(); err != nil || reloads != 1 {
+		t.Errorf("Reload() = %v and notified the reload listeners %d times", err, reloads)
+	}
+
+	// Everything is still there after reloading.
+	js, err = NewJsonStore(config, nil)
+	if err != nil {
+		t.Fatalf("NewJsonStore() failed after editing: %s", err)
+	}
+	if s := titles(js.GetAllPosts()); s != "Hello, again" {
+		t.Errorf("GetAllPosts() after reloading = '%s'", s)
+	}
+	if post, found := js.GetPostByUuid("hello"); !found || post.Body != "Hello again." || post.Status != "draft" {
+		t.Errorf("GetPostByUuid() after reloading = %v, %v", post, found)
+	}
+}
+
+func TestJsonStoreWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "json_store_test")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &lwb.BlogConfig{BlogUrl: &http.URL{Scheme: "http", Host: "example.com"}}
+	*flagJsonPath = dir
+	js, err := NewJsonStore(config, nil)
+	if err != nil {
+		t.Fatalf("NewJsonStore() failed: %s", err)
+	}
+
+	reloaded := make(chan bool, 1)
+	js.OnReload(func() {
+		select {
+		case reloaded <- true:
+		default:
+		}
+	})
+	js.Watch(1e7)
+
+	// A second store writing to the same directory stands in for an editor.
+	editor, err := NewJsonStore(config, nil)
+	if err != nil {
+		t.Fatalf("NewJsonStore() failed: %s", err)
+	}
+	post := &Post{Uuid: "hello", Title: "Hello", Status: "publish", Body: "Hello."}
+	if err = editor.SavePost(post); err != nil {
+		t.Fatalf("SavePost() failed: %s", err)
+	}
+
+	// The watcher may see the body before the post, so wait for both.
+	timeout := time.After(5e9)
+	for titles(js.GetRecentPosts(10)) != "Hello" {
+		select {
+		case <-reloaded:
+		case <-timeout:
+			t.Fatalf("the watched store didn't pick up the new post")
+		}
+	}
+	if found, ok := js.GetPostByPath(post.Path); !ok || found.Body != "Hello." {
+		t.Errorf("GetPostByPath() after reloading = %v, %v", found, ok)
+	}
+}
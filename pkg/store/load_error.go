@@ -0,0 +1,116 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+var flagStrict *bool = flag.Bool("strict", true,
+	"Refuse to load a store containing bad posts, rather than logging and skipping them")
+
+// LoadProblem describes why a file in a store couldn't be loaded.
+type LoadProblem struct {
+	// The file, relative to the store.
+	File string
+
+	// The field of the post at fault, or "" if it's the file as a whole.
+	Field string
+
+	// What's wrong.
+	Problem string
+}
+
+func (p *LoadProblem) String() string {
+	var parts []string
+	for _, part := range []string{p.File, p.Field, p.Problem} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, ": ")
+}
+
+// fieldProblem returns a problem with a field of a post. The file is filled in
+// by the store loading it.
+func fieldProblem(field, problem string) *LoadProblem {
+	return &LoadProblem{Field: field, Problem: problem}
+}
+
+// LoadError is returned by strict stores that contain files that can't be
+// loaded. It lists every problem found.
+type LoadError struct {
+	// The store's directory.
+	Dir string
+
+	Problems []*LoadProblem
+}
+
+func (e *LoadError) String() string {
+	lines := []string{fmt.Sprintf("Failed to load %s:", e.Dir)}
+	for _, p := range e.Problems {
+		lines = append(lines, "\t"+p.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// loadReport collects the problems found while loading a store.
+type loadReport struct {
+	dir      string
+	strict   bool
+	problems []*LoadProblem
+}
+
+func newLoadReport(dir string) *loadReport {
+	return &loadReport{dir: dir, strict: *flagStrict}
+}
+
+// add records that file couldn't be loaded because of err. Lenient stores skip
+// the file, so it's logged.
+func (r *loadReport) add(file string, err os.Error) {
+	p, ok := err.(*LoadProblem)
+	if ok {
+		copy := *p
+		p = &copy
+	} else {
+		p = &LoadProblem{Problem: err.String()}
+	}
+	if p.File == "" {
+		p.File = file
+	}
+
+	r.problems = append(r.problems, p)
+	if !r.strict {
+		log.Printf("%s: skipping %s", r.dir, p)
+	}
+}
+
+// err returns a *LoadError if the store is strict and any files couldn't be
+// loaded.
+func (r *loadReport) err() os.Error {
+	if !r.strict || len(r.problems) == 0 {
+		return nil
+	}
+
+	return &LoadError{r.dir, r.problems}
+}
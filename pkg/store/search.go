@@ -0,0 +1,303 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"bytes"
+	"github.com/stevela/lwb/markdown"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// The fields of a post that are indexed, and how much a match in each counts
+// towards the ranking of a post.
+const (
+	fieldTitle = iota
+	fieldBody
+	fieldTags
+	fieldCategories
+)
+
+var fieldWeights = [...]float64{4, 1, 2, 2}
+
+// termPosition is an occurrence of a term within a field of a post.
+type termPosition struct {
+	field    int
+	position int
+}
+
+// searchIndex is an inverted index of the published posts and pages in a
+// store. Like the postIndex it belongs to, it is immutable once built.
+type searchIndex struct {
+	// A map of term -> post -> occurrences of the term in the post.
+	postings map[string]map[*Post][]termPosition
+
+	// All the terms in the index in sorted order, for prefix queries.
+	terms []string
+}
+
+var (
+	textileBlockRegexp = regexp.MustCompile("^(h[1-6]|p|bq|bc|pre|fn[0-9]+)[^ .]*\\. ")
+	textileLinkRegexp  = regexp.MustCompile("\":[^ \\t\\r\\n]+")
+	textileImageRegexp = regexp.MustCompile("![^! \\t\\r\\n]+!(:[^ \\t\\r\\n]+)?")
+	htmlTagRegexp      = regexp.MustCompile("<[^>]*>")
+	htmlEntityRegexp   = regexp.MustCompile("&#?[a-zA-Z0-9]+;")
+)
+
+// stripMarkup removes textile and html markup from the body of a post, leaving
+// just the text. Link and image urls are dropped as they make poor search terms.
+func stripMarkup(body string) string {
+	lines := strings.Split(body, "\n", -1)
+	for i, line := range lines {
+		line = textileBlockRegexp.ReplaceAllString(line, "")
+		line = textileImageRegexp.ReplaceAllString(line, " ")
+		lines[i] = textileLinkRegexp.ReplaceAllString(line, "\" ")
+	}
+
+	return stripHtml(strings.Join(lines, "\n"))
+}
+
+// stripHtml removes html tags and entities.
+func stripHtml(body string) string {
+	return htmlEntityRegexp.ReplaceAllString(htmlTagRegexp.ReplaceAllString(body, " "), " ")
+}
+
+// tokenize splits text into lower case terms made up of letters and digits.
+func tokenize(text string) (terms []string) {
+	start := -1
+	for i, c := range text {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			if start < 0 {
+				start = i
+			}
+		} else if start >= 0 {
+			terms = append(terms, strings.ToLower(text[start:i]))
+			start = -1
+		}
+	}
+
+	if start >= 0 {
+		terms = append(terms, strings.ToLower(text[start:]))
+	}
+
+	return
+}
+
+// newSearchIndex builds a search index over the titles, bodies, tags and
+// categories of posts.
+func newSearchIndex(posts []*Post) *searchIndex {
+	si := &searchIndex{postings: make(map[string]map[*Post][]termPosition)}
+
+	for _, post := range posts {
+		si.add(post, fieldTitle, post.Title)
+		if post.IsFormatTextile() {
+			si.add(post, fieldBody, stripMarkup(post.Body))
+		} else if post.IsFormatMarkdown() {
+			var buf bytes.Buffer
+			markdown.Render(&buf, []byte(post.Body), "")
+			si.add(post, fieldBody, stripHtml(buf.String()))
+		} else {
+			si.add(post, fieldBody, stripHtml(post.Body))
+		}
+
+		// Each tag or category is separate, so don't let phrases span them.
+		si.add(post, fieldTags, strings.Join(post.Tags, " . "))
+		si.add(post, fieldCategories, strings.Join(post.Categories, " . "))
+	}
+
+	for term, _ := range si.postings {
+		si.terms = append(si.terms, term)
+	}
+	sort.SortStrings(si.terms)
+
+	return si
+}
+
+func (si *searchIndex) add(post *Post, field int, text string) {
+	position := 0
+	for _, term := range strings.Fields(text) {
+		terms := tokenize(term)
+		if len(terms) == 0 {
+			// Punctuation breaks phrases.
+			position += 1
+			continue
+		}
+
+		for _, term := range terms {
+			occurrences, found := si.postings[term]
+			if !found {
+				occurrences = make(map[*Post][]termPosition)
+				si.postings[term] = occurrences
+			}
+
+			occurrences[post] = append(occurrences[post], termPosition{field, position})
+			position += 1
+		}
+	}
+}
+
+// lookup returns the occurrences of a term, or of every term starting with it
+// if prefix is set.
+func (si *searchIndex) lookup(term string, prefix bool) map[*Post][]termPosition {
+	if !prefix {
+		return si.postings[term]
+	}
+
+	occurrences := make(map[*Post][]termPosition)
+	for i := sort.SearchStrings(si.terms, term); i < len(si.terms); i += 1 {
+		if !strings.HasPrefix(si.terms[i], term) {
+			break
+		}
+
+		for post, positions := range si.postings[si.terms[i]] {
+			occurrences[post] = append(occurrences[post], positions...)
+		}
+	}
+
+	return occurrences
+}
+
+// queryClause is a single term or a phrase of consecutive terms. The last term
+// may be a prefix.
+type queryClause struct {
+	terms  []string
+	prefix bool
+}
+
+// parseQuery splits a query into clauses. Text in double quotes is a phrase
+// and a trailing * makes a term a prefix.
+func parseQuery(query string) (clauses []queryClause) {
+	for query = strings.TrimSpace(query); query != ""; query = strings.TrimSpace(query) {
+		var text string
+		if query[0] == '"' {
+			end := strings.Index(query[1:], "\"")
+			if end < 0 {
+				text, query = query[1:], ""
+			} else {
+				text, query = query[1:end+1], query[end+2:]
+			}
+		} else {
+			end := strings.IndexAny(query, " \t\r\n\"")
+			if end < 0 {
+				end = len(query)
+			}
+			text, query = query[:end], query[end:]
+		}
+
+		prefix := strings.HasSuffix(text, "*")
+		if terms := tokenize(text); len(terms) > 0 {
+			clauses = append(clauses, queryClause{terms, prefix})
+		}
+	}
+
+	return
+}
+
+// match returns the posts matching a clause along with their scores.
+func (si *searchIndex) match(clause queryClause) map[*Post]float64 {
+	last := len(clause.terms) - 1
+	occurrences := make([]map[*Post][]termPosition, len(clause.terms))
+	for i, term := range clause.terms {
+		occurrences[i] = si.lookup(term, clause.prefix && i == last)
+	}
+
+	scores := make(map[*Post]float64)
+	for post, positions := range occurrences[0] {
+		var score float64
+		for _, start := range positions {
+			matched := true
+			for i := 1; i <= last && matched; i += 1 {
+				matched = hasPosition(occurrences[i][post], start.field, start.position+i)
+			}
+
+			if matched {
+				score += fieldWeights[start.field] * float64(len(clause.terms))
+			}
+		}
+
+		if score > 0 {
+			scores[post] = score
+		}
+	}
+
+	return scores
+}
+
+func hasPosition(positions []termPosition, field, position int) bool {
+	for _, p := range positions {
+		if p.field == field && p.position == position {
+			return true
+		}
+	}
+
+	return false
+}
+
+// searchResults sorts posts by descending score, most recent first for equal
+// scores.
+type searchResults struct {
+	posts  []*Post
+	scores map[*Post]float64
+}
+
+func (sr *searchResults) Len() int {
+	return len(sr.posts)
+}
+
+func (sr *searchResults) Less(i, j int) bool {
+	a, b := sr.posts[i], sr.posts[j]
+	if sr.scores[a] != sr.scores[b] {
+		return sr.scores[a] > sr.scores[b]
+	}
+
+	return a.Published.Seconds() > b.Published.Seconds()
+}
+
+func (sr *searchResults) Swap(i, j int) {
+	sr.posts[i], sr.posts[j] = sr.posts[j], sr.posts[i]
+}
+
+// search returns the posts that match every clause of the query, best matches
+// first.
+func (si *searchIndex) search(query string) []*Post {
+	clauses := parseQuery(query)
+	if len(clauses) == 0 {
+		return nil
+	}
+
+	scores := si.match(clauses[0])
+	for _, clause := range clauses[1:] {
+		clauseScores := si.match(clause)
+		for post, score := range scores {
+			if clauseScore, found := clauseScores[post]; found {
+				scores[post] = score + clauseScore
+			} else {
+				scores[post] = 0, false
+			}
+		}
+	}
+
+	results := &searchResults{scores: scores}
+	for post, _ := range scores {
+		results.posts = append(results.posts, post)
+	}
+	sort.Sort(results)
+
+	return results.posts
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var searchPosts = []*Post{
+	&Post{Title: "Hello Go world", Format: "textile", Tags: []string{"go lang", "web"},
+		Body: "h2. Intro\n\nSome \"linked text\":http://golang.org/doc and *bold* words. Searching is fun."},
+	&Post{Title: "Another", Format: "none", Tags: []string{"lang"},
+		Body: "<p>Go is fun &amp; searchable</p>"},
+	&Post{Title: "Third", Format: "markdown",
+		Body: "## Notes\n\nA [markdown link](http://example.com/) about *gophers*."},
+}
+
+var searchTests = []struct {
+	query  string
+	titles string
+}{
+	{"", ""},
+	{"go", "Hello Go world,Another"},
+	{"GO World", "Hello Go world"},
+	{"\"linked text\"", "Hello Go world"},
+	{"\"text linked\"", ""},
+	{"golang", ""},
+	{"amp", ""},
+	{"intro", "Hello Go world"},
+	{"search*", "Another,Hello Go world"},
+	{"\"go lang\"", "Hello Go world"},
+	{"\"lang web\"", ""},
+	{"fun \"go world\"", "Hello Go world"},
+	{"gophers notes", "Third"},
+	{"\"markdown link\"", "Third"},
+	{"example", ""},
+}
+
+func TestSearch(t *testing.T) {
+	for i, post := range searchPosts {
+		post.Published = time.SecondsToUTC(int64(i))
+	}
+
+	si := newSearchIndex(searchPosts)
+	for _, st := range searchTests {
+		var titles []string
+		for _, post := range si.search(st.query) {
+			titles = append(titles, post.Title)
+		}
+
+		if s := strings.Join(titles, ","); s != st.titles {
+			t.Errorf("search(%q) = '%s' want '%s'", st.query, s, st.titles)
+		}
+	}
+}
@@ -18,6 +18,7 @@ package store
 
 import (
 	"http"
+	"os"
 	"time"
 )
 
@@ -58,7 +59,16 @@ func (a Archives) Swap(i, j int) {
 // Store represents an interface to the underlying storage.
 type Store interface {
 	GetRecentPosts(numPosts int) []*Post
+
+	// NumPosts returns the number of published posts.
+	NumPosts() int
+
+	// GetPostsPage returns up to n published posts, newest first, after
+	// skipping the offset newest, e.g. to show a page of an index.
+	GetPostsPage(offset, n int) []*Post
+
 	GetPage(name string) (*Post, bool)
+	GetPages() []*Post
 	GetPostByPath(path string) (*Post, bool)
 	GetPostsByYear(year int) ([]*Post, bool)
 	GetPostsByYearMonth(year, month int) ([]*Post, bool)
@@ -67,6 +77,81 @@ type Store interface {
 	GetTags() []string
 	GetCategories() []string
 	GetArchives() Archives
+
+	// Search returns the published posts and pages matching a query, best
+	// matches first.
+	Search(query string) []*Post
+}
+
+// WritableStore is implemented by stores that can be edited. Changes are
+// written through to the underlying storage and are visible immediately. Posts
+// returned by the store are shared, so edit a Copy and pass that to UpdatePost.
+// Editors need to see drafts and scheduled items too, so every writable store
+// is also an Exporter.
+type WritableStore interface {
+	Store
+	Exporter
+
+	// GetPostByUuid returns a post or page, published or not, given its uuid.
+	GetPostByUuid(uuid string) (*Post, bool)
+
+	// SavePost creates a new post or page, assigning it a uuid if it doesn't
+	// already have one.
+	SavePost(post *Post) os.Error
+
+	// UpdatePost replaces the post or page with the same uuid.
+	UpdatePost(post *Post) os.Error
+
+	// DeletePost removes the post or page with the given uuid.
+	DeletePost(uuid string) os.Error
+
+	// SetPostStatus changes the status ("publish", "scheduled" or "draft") of a
+	// post or page.
+	SetPostStatus(uuid, status string) os.Error
+}
+
+// Exporter is implemented by stores that can list their entire contents, e.g.
+// to copy them to another store.
+type Exporter interface {
+	// GetAllPosts returns every post and page, including drafts and items
+	// that are scheduled for later.
+	GetAllPosts() []*Post
+}
+
+// Historian is implemented by stores that keep the revision history of posts.
+type Historian interface {
+	// GetPostHistory returns the revisions of a post or page, newest first.
+	GetPostHistory(uuid string) ([]*Revision, os.Error)
+}
+
+// Revision is a change to a post or page.
+type Revision struct {
+	// The commit that made the change.
+	Commit string
+
+	// Who made the change, e.g. "Steve Lacey <steve@steve-lacey.com>".
+	Author string
+
+	// When the change was made.
+	Date *time.Time
+
+	// A summary of the change.
+	Subject string
+
+	// The change itself, as a unified diff.
+	Diff string
+}
+
+// Reloader is implemented by stores that can pick up changes to the underlying
+// storage without restarting the server.
+type Reloader interface {
+	// Reload rebuilds the store from the underlying storage.
+	Reload() os.Error
+
+	// OnReload registers a function to be called whenever the store is
+	// reloaded or a scheduled post is published. Edits made through the store
+	// don't call it, as whoever made them knows what changed.
+	OnReload(fn func())
 }
 
 // Post represents a post in the system.
@@ -80,10 +165,11 @@ type Post struct {
 	// The base name of the post.
 	Basename string
 
-	// The format of the post ("none", "convertbreaks" or "textile").
+	// The format of the post ("none", "convertbreaks", "textile" or "markdown").
 	Format string
 
-	// The status of the post ("publish" or "draft").
+	// The status of the post ("publish", "scheduled" or "draft"). Published
+	// and scheduled posts both appear once their published date arrives.
 	Status string
 
 	// The type of the post ("post" or "page").
@@ -137,6 +223,29 @@ type Post struct {
 	// Cached data.
 	CachedPost             []byte
 	CachedPostWithFeedback []byte
+
+	// The name of the file the post was loaded from, without its extension.
+	file string
+}
+
+// Copy returns a copy of the post, suitable for editing, without any of the
+// navigation or cached data, which depend on the other posts in the store.
+func (p *Post) Copy() *Post {
+	c := *p
+	c.PreviousPath = ""
+	c.PreviousTitle = ""
+	c.NextPath = ""
+	c.NextTitle = ""
+	c.CachedPost = nil
+	c.CachedPostWithFeedback = nil
+
+	return &c
+}
+
+// File returns the name of the file the post was loaded from, without its
+// extension, or "" if it didn't come from a file.
+func (p *Post) File() string {
+	return p.file
 }
 
 // IsFormatTextile returns whether the post should be formatted using the TextileFormatter.
@@ -144,6 +253,11 @@ func (p *Post) IsFormatTextile() bool {
 	return p.Format == "textile"
 }
 
+// IsFormatMarkdown returns whether the post should be formatted using the MarkdownFormatter.
+func (p *Post) IsFormatMarkdown() bool {
+	return p.Format == "markdown"
+}
+
 // IsFormatConvertBreaks returns whether the post should be formatted using the ConvertBreaksFormatter.
 func (p *Post) IsFormatConvertBreaks() bool {
 	return p.Format == "convertbreaks"
@@ -154,6 +268,17 @@ func (p *Post) IsPublished() bool {
 	return p.Status == "publish"
 }
 
+// IsScheduled returns whether the post is waiting to be published.
+func (p *Post) IsScheduled() bool {
+	return p.Status == "scheduled"
+}
+
+// IsVisibleAt returns whether the post is public at the given time, in seconds
+// since the epoch.
+func (p *Post) IsVisibleAt(seconds int64) bool {
+	return (p.IsPublished() || p.IsScheduled()) && p.Published.Seconds() <= seconds
+}
+
 // IsPost returns whether the post is a post..
 func (p *Post) IsPost() bool {
 	return p.Type == "post"
@@ -173,3 +298,13 @@ func (p *Post) PublishedShort() string {
 func (p *Post) PublishedRFC1123() string {
 	return p.Published.Format(time.RFC1123)
 }
+
+// PublishedRFC3339 returns a date string in RFC3339 format.
+func (p *Post) PublishedRFC3339() string {
+	return p.Published.Format(time.RFC3339)
+}
+
+// LastModifiedRFC3339 returns a date string in RFC3339 format.
+func (p *Post) LastModifiedRFC3339() string {
+	return p.LastModified.Format(time.RFC3339)
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"github.com/stevela/lwb/lwb"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var flagTextPath *string = flag.String("text_dir", "text_store", "Path to the text store")
+
+// The formats implied by the extensions of text files.
+var textFormats = map[string]string{
+	".md":       "markdown",
+	".markdown": "markdown",
+	".textile":  "textile",
+}
+
+// The layouts accepted for dates in front matter.
+var frontMatterTimeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	legacyTimeFormat,
+}
+
+// textStore is a read only store of posts and pages held in text files. Each
+// file starts with YAML front matter between "---" lines, or TOML front matter
+// between "+++" lines, followed by the body, e.g.
+//
+//	---
+//	title: Hello, World
+//	date: 2011-05-02T22:33:46-07:00
+//	tags: [go, web]
+//	---
+//	Some *markdown*.
+//
+// The keys are title, date, lastmod, tags, categories, status, format,
+// basename, type and uuid. Only title and, for posts, date are required; the
+// format defaults to one implied by the file's extension and the basename to
+// the file's name.
+type textStore struct {
+	indexedStore
+
+	// The directory the store is loaded from.
+	dir string
+
+	config       *lwb.BlogConfig
+	postLoadHook func(*Post)
+
+	// Serializes reloads.
+	reloadLock sync.Mutex
+}
+
+// NewTextStore creates a new store from the text files in the directory given
+// by the -text_dir flag. If not nil, postLoadHook can be used to make
+// modifications to the post after it has been loaded. If any posts can't be
+// loaded, a strict store fails with a *LoadError, while a lenient one skips
+// them.
+func NewTextStore(config *lwb.BlogConfig, postLoadHook func(*Post)) (ts *textStore, err os.Error) {
+	ts = &textStore{
+		dir:          *flagTextPath,
+		config:       config,
+		postLoadHook: postLoadHook,
+	}
+
+	items, problems, err := ts.load()
+	if err != nil {
+		return nil, err
+	}
+
+	ts.problems = problems
+	ts.setIndex(newPostIndex(items))
+
+	return
+}
+
+// load reads all the posts and pages from disk, along with the problems with
+// any files that had to be skipped.
+func (ts *textStore) load() (items []*Post, problems []*LoadProblem, err os.Error) {
+	fileInfos, err := ioutil.ReadDir(ts.dir)
+	if err != nil {
+		return nil, nil, os.NewError("Failed to scan for posts: " + err.String())
+	}
+
+	report := newLoadReport(ts.dir)
+	for _, fileInfo := range fileInfos {
+		if _, ok := textFormats[path.Ext(fileInfo.Name)]; !ok || strings.HasPrefix(fileInfo.Name, ".") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(path.Join(ts.dir, fileInfo.Name))
+		if err != nil {
+			report.add(fileInfo.Name, os.NewError("Failed to read file: "+err.String()))
+			continue
+		}
+
+		item, err := parseTextItem(fileInfo.Name, data)
+		if err == nil {
+			err = preparePost(item, ts.config, ts.postLoadHook)
+		}
+		if err != nil {
+			report.add(fileInfo.Name, err)
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return items, report.problems, report.err()
+}
+
+// parseTextItem creates a post or page from the contents of a text file.
+func parseTextItem(name string, data []byte) (*Post, os.Error) {
+	fm, body, err := splitFrontMatter(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	ext := path.Ext(name)
+	item := &Post{
+		Body:       strings.TrimLeft(body, "\n"),
+		Basename:   name[:len(name)-len(ext)],
+		Format:     textFormats[ext],
+		Status:     "publish",
+		Type:       "post",
+		Uuid:       nameUuid(name),
+		Tags:       fm.getList("tags"),
+		Categories: fm.getList("categories"),
+		file:       name[:len(name)-len(ext)],
+	}
+
+	// Optional strings override the defaults.
+	for key, field := range map[string]*string{
+		"title":    &item.Title,
+		"basename": &item.Basename,
+		"format":   &item.Format,
+		"status":   &item.Status,
+		"type":     &item.Type,
+		"uuid":     &item.Uuid,
+	} {
+		value, err := fm.getString(key)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			*field = value
+		}
+	}
+
+	if draft, _ := fm.getString("draft"); draft == "true" {
+		item.Status = "draft"
+	}
+
+	if item.Title == "" {
+		return nil, fieldProblem("title", "Missing title")
+	}
+
+	// Dates.
+	date, err := fm.getString("date")
+	if err != nil {
+		return nil, err
+	}
+	if date == "" {
+		if item.IsPost() {
+			return nil, fieldProblem("date", "Missing date")
+		}
+		item.Published = time.SecondsToUTC(0)
+	} else if item.Published, err = parseFrontMatterTime(date); err != nil {
+		return nil, fieldProblem("date", err.String())
+	}
+
+	item.LastModified = item.Published
+	if lastmod, err := fm.getString("lastmod"); err != nil {
+		return nil, err
+	} else if lastmod != "" {
+		if item.LastModified, err = parseFrontMatterTime(lastmod); err != nil {
+			return nil, fieldProblem("lastmod", err.String())
+		}
+	}
+
+	item.PublishedDate = item.Published.Format(timeFormat)
+	item.LastModifiedDate = item.LastModified.Format(timeFormat)
+
+	return item, nil
+}
+
+// parseFrontMatterTime parses a date in any of the accepted layouts.
+func parseFrontMatterTime(value string) (*time.Time, os.Error) {
+	for _, layout := range frontMatterTimeFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			fixZoneOffset(t)
+			return t, nil
+		}
+	}
+
+	return nil, os.NewError("Bad date: " + value)
+}
+
+// nameUuid returns a name based (version 5) uuid, so that a post keeps the
+// same uuid, and so the same feed entry id, across reloads.
+func nameUuid(name string) string {
+	h := sha1.New()
+	h.Write([]byte("lwb:" + name))
+	b := h.Sum()[:16]
+
+	b[6] = (b[6] & 0x0f) | 0x50
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Reload rereads the store from disk and swaps in the new contents. The
+// existing contents are kept if the store fails to load.
+func (ts *textStore) Reload() os.Error {
+	ts.reloadLock.Lock()
+	defer ts.reloadLock.Unlock()
+
+	items, problems, err := ts.load()
+	if err != nil {
+		return err
+	}
+
+	ts.setProblems(problems)
+	ts.swap(newPostIndex(items))
+
+	return nil
+}
+
+// Watch polls the store directory every intervalNs nanoseconds and reloads
+// the store when anything in it changes.
+func (ts *textStore) Watch(intervalNs int64) {
+	watchStore(intervalNs, func() string {
+		var suffixes []string
+		for ext, _ := range textFormats {
+			suffixes = append(suffixes, ext)
+		}
+		return dirSignature(ts.dir, suffixes...)
+	}, func() os.Error { return ts.Reload() })
+}
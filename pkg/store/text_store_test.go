@@ -0,0 +1,113 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"github.com/stevela/lwb/lwb"
+	"http"
+	"reflect"
+	"testing"
+)
+
+// The same post as YAML and TOML.
+var textItems = []struct {
+	name string
+	data string
+}{
+	{"html5_media_stats.md", "---\n" +
+		"title: \"HTML5 Media Statistics\"\n" +
+		"date: 2011-05-02T22:33:46-08:00  # PST\n" +
+		"format: textile\n" +
+		"tags: [html5, tech, 'chrome', \"video\", whatwg]\n" +
+		"categories:\n" +
+		"  - tech\n" +
+		"  - chrome\n" +
+		"---\n\n" +
+		"Some text.\n"},
+	{"html5_media_stats.textile", "+++\r\n" +
+		"# A comment.\r\n" +
+		"title = 'HTML5 Media Statistics'\r\n" +
+		"date = 2011-05-02T22:33:46-08:00\r\n" +
+		"tags = [\"html5\", \"tech\", \"chrome\",\r\n" +
+		"        \"video\", \"whatwg\"]\r\n" +
+		"categories = [\"tech\", \"chrome\"]\r\n" +
+		"+++\r\n" +
+		"Some text.\r\n"},
+}
+
+func TestParseTextItem(t *testing.T) {
+	config := &lwb.BlogConfig{BlogUrl: &http.URL{Scheme: "http", Host: "example.com"}}
+
+	for _, ti := range textItems {
+		item, err := parseTextItem(ti.name, []byte(ti.data))
+		if err != nil {
+			t.Errorf("parseTextItem(%q) failed: %s", ti.name, err)
+			continue
+		}
+		if err = preparePost(item, config, nil); err != nil {
+			t.Errorf("preparePost(%q) failed: %s", ti.name, err)
+			continue
+		}
+
+		if item.Title != "HTML5 Media Statistics" || item.Body != "Some text.\n" || item.Format != "textile" {
+			t.Errorf("%s: got title '%s' body %q format '%s'", ti.name, item.Title, item.Body, item.Format)
+		}
+		if item.Path != "/2011/05/html5_media_stats" || item.Type != "post" || !item.IsPublished() {
+			t.Errorf("%s: got path '%s' type '%s' status '%s'", ti.name, item.Path, item.Type, item.Status)
+		}
+		if !reflect.DeepEqual(item.Tags, []string{"html5", "tech", "chrome", "video", "whatwg"}) ||
+			!reflect.DeepEqual(item.Categories, []string{"tech", "chrome"}) {
+			t.Errorf("%s: got tags %v categories %v", ti.name, item.Tags, item.Categories)
+		}
+		if item.Published.Seconds() != 1304404426 || item.LastModified.Seconds() != 1304404426 {
+			t.Errorf("%s: got published %v last modified %v", ti.name, item.Published, item.LastModified)
+		}
+		if item.Uuid != nameUuid(ti.name) || len(item.Uuid) != 36 {
+			t.Errorf("%s: got uuid '%s'", ti.name, item.Uuid)
+		}
+	}
+}
+
+var badTextItems = []string{
+	"title: No front matter\n",
+	"---\ntitle: Unterminated\n",
+	"---\ndate: 2011-05-02\n---\n",
+	"---\ntitle: Bad date\ndate: yesterday\n---\n",
+	"---\ntitle: [a, b]\ndate: 2011-05-02\n---\n",
+	"---\ntitle: \"Unterminated\ndate: 2011-05-02\n---\n",
+	"+++\ntitle: Wrong syntax\n+++\n",
+}
+
+func TestParseBadTextItem(t *testing.T) {
+	for _, data := range badTextItems {
+		if _, err := parseTextItem("bad.md", []byte(data)); err == nil {
+			t.Errorf("parseTextItem(%q) succeeded", data)
+		}
+	}
+}
+
+func TestParseTextPage(t *testing.T) {
+	item, err := parseTextItem("bio.md", []byte("---\ntitle: Bio\ntype: page\ndraft: true\n---\nHi"))
+	if err != nil {
+		t.Fatalf("parseTextItem() failed: %s", err)
+	}
+
+	if item.Type != "page" || item.Basename != "bio" || item.Format != "markdown" || item.Status != "draft" {
+		t.Errorf("got type '%s' basename '%s' format '%s' status '%s'",
+			item.Type, item.Basename, item.Format, item.Status)
+	}
+}
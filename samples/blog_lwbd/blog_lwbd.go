@@ -29,18 +29,34 @@ import (
 	"log"
 	"net"
 	"os"
-	"sort"
 )
 
 var flagDebug *bool = flag.Bool("debug", false, "Run in debug mode")
 var flagDebugLog *bool = flag.Bool("debuglog", false, "Output debug logs")
 var flagCache *bool = flag.Bool("cache", true, "Run with a cache")
+var flagCacheEntries *int = flag.Int("cache_entries", lwb.DefaultCacheEntries,
+	"The most pages to keep in the cache (0 for no limit)")
+var flagCacheBytes *int64 = flag.Int64("cache_bytes", lwb.DefaultCacheBytes,
+	"The most bytes of pages to keep in the cache (0 for no limit)")
 var flagGenerator *string = flag.String("generator", "Light Weight Blogging (http://github.com/stevela/lwb)",
 	"A link to the software that generated this site")
 var flagHost *string = flag.String("host", "example.com", "Host to run this server as")
 var flagLog *string = flag.String("log", "access.log", "Path to access.log")
 var flagPort *int = flag.Int("port", 8080, "Port to run the server on")
 var flagProtocol *string = flag.String("protocol", "http", "Protocol to run this server on")
+var flagReload *int = flag.Int("reload", 0, "Check the store for changes every n seconds (0 to disable)")
+var flagStore *string = flag.String("store", "json", "The store to use (json, text, db or git)")
+var flagTimeZone *string = flag.String("timezone", "America/Los_Angeles",
+	"The time zone the blog's archives go by and dates are shown in")
+
+// blogStore is implemented by all the stores the blog can be served from. The
+// editing interfaces are only available for those that are also a
+// store.WritableStore.
+type blogStore interface {
+	store.Store
+	store.Reloader
+	Watch(intervalNs int64)
+}
 
 var config = &lwb.BlogConfig{
 	Author:      "Your Name",
@@ -51,8 +67,10 @@ var config = &lwb.BlogConfig{
         Version: 1,
 
 	// Main Index.
-	NumMainIndexPosts: 20,
-	MainIndexRegexp:   "/",
+	NumMainIndexPosts:   20,
+	MainIndexRegexp:     "/",
+	MainIndexPageRegexp: "/index/<page:[0-9]+>", // Not under /page/, which is for pages.
+	MainIndexPageUrl:    "/index/%d",
 
 	// Sidebar.
 	NumRecentPosts: 10,
@@ -64,11 +82,25 @@ var config = &lwb.BlogConfig{
 	PostRegexp: "/<year:[0-9][0-9][0-9][0-9]>/<month:[0-9][0-9]>/<basename:[^/]*>",
 
 	// Archives.
+	NumArchivePosts:       20,
 	MonthlyArchiveRegexp:  "/<year:[0-9][0-9][0-9][0-9]>/<month:[0-9][0-9]>/",
 	YearlyArchiveRegexp:   "/<year:[0-9][0-9][0-9][0-9]>/",
 	TagArchiveRegexp:      "/tag/<tag:[^/]*>/",
 	CategoryArchiveRegexp: "/category/<tag:[^/]*>/",
 
+	TagRssFeedRegexp:       "/tag/<tag:[^/]*>/index.xml",
+	TagAtomFeedRegexp:      "/tag/<tag:[^/]*>/atom.xml",
+	CategoryRssFeedRegexp:  "/category/<tag:[^/]*>/index.xml",
+	CategoryAtomFeedRegexp: "/category/<tag:[^/]*>/atom.xml",
+
+	// Search.
+	SearchUrl:    "/search",
+	SearchRegexp: "/search",
+
+	// Sitemap.
+	SitemapUrl:    "/sitemap.xml",
+	SitemapRegexp: "/sitemap.xml",
+
 	// Other content.
 	StaticRegexp: "/<path:.*>",
 
@@ -77,8 +109,42 @@ var config = &lwb.BlogConfig{
 	RssUrl:          "/index.xml", // What to use in rendered content.
 	RssFeedRegexp:   "/index.xml", // What to actual serve. Maybe different if using something like feedburner.
 
+	// Atom.
+	AtomUrl:        "/atom.xml",
+	AtomFeedRegexp: "/atom.xml",
+
+	// JSON Feed.
+	JsonFeedUrl:    "/feed.json",
+	JsonFeedRegexp: "/feed.json",
+
 	// Comments.
 	DisqusShortname: "xxx", // Replace with your own disqus shortname.
+
+	// Remote editing.
+	RpcRegexp:   "/xmlrpc",
+	RpcUsername: "admin",
+	RpcPassword: "", // Set a password to enable editing.
+
+	AtomPubUrl:              "/app",
+	AtomPubServiceRegexp:    "/app",
+	AtomPubCollectionRegexp: "/app/<collection:[a-z]+>",
+	AtomPubMemberRegexp:     "/app/<collection:[a-z]+>/<member:[^/]+>",
+
+	MicropubRegexp:        "/micropub",
+	MicropubTokenEndpoint: "", // e.g. "https://tokens.indieauth.com/token" to enable Micropub.
+
+	// Uploaded media.
+	MediaPath: "static/media",
+	MediaUrl:  "/media",
+
+	// Draft previews.
+	PreviewUrl:    "/preview",
+	PreviewRegexp: "/preview/<uuid:[^/]+>",
+	PreviewSecret: "", // Set a secret to enable previews.
+
+	// Reloading the store.
+	ReloadRegexp: "/reload",
+	ReloadSecret: "", // Set a secret to enable reloading, e.g. from tools/post-receive.sample.
 }
 
 func pathHandler(req *web.Request, targetPattern string) {
@@ -97,34 +163,57 @@ func main() {
 	if config.BlogUrl, err = http.ParseURL(fmt.Sprintf("%s://%s", *flagProtocol, *flagHost)); err != nil {
 		panic("Invalid protocol and/or host")
 	}
+	if config.TimeZone, err = lwb.LoadLocation(*flagTimeZone); err != nil {
+		panic(err.String())
+	}
 
 	// Cache.
 	if *flagCache {
-		config.Cache = lwb.NewCache()
+		config.Cache = lwb.NewLimitedCache(*flagCacheEntries, *flagCacheBytes)
 	} else {
 		config.Cache = lwb.NewDummyCache()
 	}
 
 	// Initialize the database.
-	db, _ := store.NewJsonStore(config, nil)
+	var db blogStore
+	switch *flagStore {
+	case "json":
+		db, err = store.NewJsonStore(config, nil)
+	case "text":
+		db, err = store.NewTextStore(config, nil)
+	case "db":
+		db, err = store.NewDbStore(config, nil)
+	case "git":
+		db, err = store.NewGitStore(config, nil)
+	default:
+		err = os.NewError("Unknown store: " + *flagStore)
+	}
+	if err != nil {
+		// With -strict, this lists every post that couldn't be loaded.
+		fmt.Fprintf(os.Stderr, "Failed to open the store: %s\n", err)
+		os.Exit(1)
+	}
 
 	// Context for rendering.
-	tags := db.GetTags()
-	categories := db.GetCategories()
-	sort.SortStrings(tags)
-	sort.SortStrings(categories)
-
 	context := &handlers.RenderContext{
-		Db:          db,
-		Config:      config,
-		Generator:   *flagGenerator,
-		RecentPosts: db.GetRecentPosts(config.NumRecentPosts),
-		Tags:        tags,
-		Categories:  categories,
-		Archives:    db.GetArchives(),
-		UseCache:    *flagCache,
-		Title:       config.Title,
-		Path:        config.BlogUrl.String(),
+		Db:        db,
+		Config:    config,
+		Generator: *flagGenerator,
+		UseCache:  *flagCache,
+		Title:     config.Title,
+		Path:      config.BlogUrl.String(),
+	}
+	context.Refresh()
+
+	// Pick up posts changed behind the store's back, by the watcher or a pull
+	// through the reload handler, and newly published scheduled posts. Edits
+	// made through the handlers only discard the pages they affect.
+	db.OnReload(func() {
+		context.Refresh()
+		config.Cache.Flush()
+	})
+	if *flagReload > 0 {
+		db.Watch(int64(*flagReload) * 1e9)
 	}
 
 	// Templates...
@@ -145,10 +234,12 @@ func main() {
 		web.HeaderCacheControl: {fmt.Sprintf("max-age=%d", maxAge)},
 	}
 
-	serveFileOptions := &web.ServeFileOptions{fileMimeTypes, fileHeaders}
+	mainIndexHandler := handlers.MainIndexHandler(context)
+	tagLookup := func(key string) ([]*store.Post, bool) { return db.GetPostsByTag(key) }
+	categoryLookup := func(key string) ([]*store.Post, bool) { return db.GetPostsByCategory(key) }
 
 	// Register all path handlers.
-	rh := handlers.DebugFilter(*flagDebug, config, web.NewRouter().
+	router := web.NewRouter().
 		// Stats.
 		Register("/expvar", "GET", web.HandlerFunc(expvar.ServeWeb)).
 
@@ -164,17 +255,45 @@ func main() {
 		//Register("/index.xml", "GET", web.RedirectHandler("http://feeds.feedburner.com/steve-lacey-main", false)).
 
 		// Handlers.
-		Register(config.MainIndexRegexp, "GET", handlers.MainIndexHandler(context)).
+		Register(config.MainIndexRegexp, "GET", mainIndexHandler).
+		Register(config.MainIndexPageRegexp, "GET", mainIndexHandler).
 		Register(config.RssFeedRegexp, "GET", handlers.RssFeedHandler(context)).
+		Register(config.AtomFeedRegexp, "GET", handlers.AtomFeedHandler(context)).
+		Register(config.JsonFeedRegexp, "GET", handlers.JsonFeedHandler(context)).
 		Register(config.MonthlyArchiveRegexp, "GET", handlers.DateArchiveHandler(context)).
 		Register(config.YearlyArchiveRegexp, "GET", handlers.DateArchiveHandler(context)).
-		Register(config.TagArchiveRegexp, "GET", handlers.TagArchiveHandler(context,
-			func(key string) ([]*store.Post, bool) { return db.GetPostsByTag(key) })).
-		Register(config.CategoryArchiveRegexp, "GET", handlers.TagArchiveHandler(context,
-			func(key string) ([]*store.Post, bool) { return db.GetPostsByCategory(key) })).
+		Register(config.TagRssFeedRegexp, "GET", handlers.TagRssFeedHandler(context, tagLookup)).
+		Register(config.TagAtomFeedRegexp, "GET", handlers.TagAtomFeedHandler(context, tagLookup)).
+		Register(config.CategoryRssFeedRegexp, "GET", handlers.TagRssFeedHandler(context, categoryLookup)).
+		Register(config.CategoryAtomFeedRegexp, "GET", handlers.TagAtomFeedHandler(context, categoryLookup)).
+		Register(config.TagArchiveRegexp, "GET", handlers.TagArchiveHandler(context, tagLookup)).
+		Register(config.CategoryArchiveRegexp, "GET", handlers.TagArchiveHandler(context, categoryLookup)).
+		Register(config.SearchRegexp, "GET", handlers.SearchHandler(context)).
+		Register(config.SitemapRegexp, "GET", handlers.SitemapHandler(context)).
 		Register(config.PostRegexp, "GET", handlers.SinglePostHandler(context)).
 		Register(config.PageRegexp, "GET", handlers.PageHandler(context)).
-		Register(config.StaticRegexp, "GET", web.DirectoryHandler("static/", serveFileOptions)))
+		Register(config.ReloadRegexp, "POST", handlers.ReloadHandler(context, db))
+
+	// Editing, for the stores that support it.
+	if editable, ok := db.(store.WritableStore); ok {
+		atomPubHandler := handlers.AtomPubHandler(context, editable)
+		micropubHandler := handlers.MicropubHandler(context, editable, &handlers.TokenEndpointVerifier{
+			Endpoint: config.MicropubTokenEndpoint,
+			Me:       config.BlogUrl.String(),
+		})
+
+		router.
+			Register(config.PreviewRegexp, "GET", handlers.PreviewHandler(context, editable)).
+			Register(config.RpcRegexp, "POST", handlers.XmlRpcHandler(context, editable)).
+			Register(config.AtomPubServiceRegexp, "GET", atomPubHandler).
+			Register(config.AtomPubCollectionRegexp, "GET", atomPubHandler, "POST", atomPubHandler).
+			Register(config.AtomPubMemberRegexp, "GET", atomPubHandler, "PUT", atomPubHandler, "DELETE", atomPubHandler).
+			Register(config.MicropubRegexp, "GET", micropubHandler, "POST", micropubHandler)
+	}
+
+	// Static content matches every path, so it has to come last.
+	router.Register(config.StaticRegexp, "GET", handlers.StaticHandler("static/", fileMimeTypes, fileHeaders))
+	rh := handlers.DebugFilter(*flagDebug, config, router)
 
 	// Create a logger.
 	logFile, err := os.OpenFile(*flagLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
@@ -18,15 +18,27 @@
 
 import argparse
 import json
-from datetime import datetime
+import time
 import uuid
 
+def rfc3339(seconds):
+  """Formats a time as a local RFC 3339 date with its UTC offset."""
+  if time.localtime(seconds).tm_isdst > 0:
+    offset = -time.altzone
+  else:
+    offset = -time.timezone
+  sign = '+'
+  if offset < 0:
+    sign = '-'
+    offset = -offset
+  return time.strftime("%Y-%m-%dT%H:%M:%S", time.localtime(seconds)) + \
+      "%s%02d:%02d" % (sign, offset / 3600, offset % 3600 / 60)
+
 class Entry:
   """Represents an entry."""
 
   def __init__(self, title, body, post_type):
-    dt = datetime.now()
-    date_str = dt.strftime("%a %b %d %H:%M:%S PST %Y")
+    date_str = rfc3339(time.time())
     
     self._data = {
       'basename': 'a_base_name',
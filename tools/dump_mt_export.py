@@ -18,12 +18,25 @@
 
 import argparse
 import json
+import os
 import re
 import string
 import time
 import uuid
 
-TIMEZONE = "PST"
+def rfc3339(t):
+  """Formats a local struct_time as an RFC 3339 date with its UTC offset."""
+  seconds = time.mktime(t)
+  if time.localtime(seconds).tm_isdst > 0:
+    offset = -time.altzone
+  else:
+    offset = -time.timezone
+  sign = '+'
+  if offset < 0:
+    sign = '-'
+    offset = -offset
+  return time.strftime("%Y-%m-%dT%H:%M:%S", time.localtime(seconds)) + \
+      "%s%02d:%02d" % (sign, offset / 3600, offset % 3600 / 60)
 
 class Entry:
   """Represents an entry."""
@@ -73,8 +86,14 @@ def main():
                       help='the input file')
   parser.add_argument('-o', '--output', metavar='OUTPUT', dest='output',
                       help='the output directory')
+  parser.add_argument('-z', '--timezone', metavar='TIMEZONE', dest='timezone',
+                      default='America/Los_Angeles',
+                      help='the time zone of the dates in the export')
   args = parser.parse_args()
 
+  os.environ['TZ'] = args.timezone
+  time.tzset()
+
   file = open(args.input, 'r')
 
   entry = Entry()
@@ -130,7 +149,8 @@ def main():
 
         elif key == 'date':
           t = time.strptime(value, "%m/%d/%Y %I:%M:%S %p")
-          date_str = time.strftime("%a %b %d %H:%M:%S " + TIMEZONE + " %Y", t)
+          t = t[:8] + (-1,)
+          date_str = rfc3339(t)
           entry.set_attribute('publishedDate', date_str)
           entry.set_attribute('lastModifiedDate', date_str)
           
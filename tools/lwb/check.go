@@ -0,0 +1,277 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/markdown"
+	"github.com/stevela/lwb/store"
+	"github.com/stevela/lwb/textile"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var flagStatic *string = flag.String("static", "static", "Path to the static content served alongside the blog")
+
+// The formats the handlers know how to render.
+var knownFormats = map[string]bool{"": true, "none": true, "convertbreaks": true, "textile": true, "markdown": true}
+
+var (
+	// Links and images in rendered posts.
+	htmlLinkRegexp = regexp.MustCompile("<(a|img)[ \\t\\r\\n][^>]*(href|src)=\"([^\"]*)\"")
+
+	// The paths of posts and pages, which must be in the store.
+	postPathRegexp = regexp.MustCompile("^/[0-9][0-9][0-9][0-9]/[0-9][0-9]/[^/]+$")
+	pagePathRegexp = regexp.MustCompile("^/page/[^/]+$")
+)
+
+// problemReporter is implemented by the stores that can skip bad files.
+type problemReporter interface {
+	LoadProblems() []*store.LoadProblem
+}
+
+// checker collects the problems found by lwb check.
+type checker struct {
+	// Whether bodies can be kept in separate .body files.
+	bodyFiles bool
+
+	// The directory static content, including images, is served from.
+	staticDir string
+
+	// The paths of everything in the store that is published or scheduled.
+	paths map[string]bool
+
+	problems []*store.LoadProblem
+}
+
+// postName returns how to refer to a post in problems.
+func postName(post *store.Post) string {
+	if file := post.File(); file != "" {
+		return file
+	}
+
+	return post.Uuid
+}
+
+func (c *checker) add(file, field, format string, args ...interface{}) {
+	c.problems = append(c.problems, &store.LoadProblem{File: file, Field: field, Problem: fmt.Sprintf(format, args...)})
+}
+
+// checkDuplicates finds posts that share a uuid, and posts (or pages) that
+// share a path, all but one of which are hidden.
+func (c *checker) checkDuplicates(posts []*store.Post) {
+	byUuid := make(map[string][]string)
+	byPath := make(map[string][]string)
+	for _, post := range posts {
+		byUuid[post.Uuid] = append(byUuid[post.Uuid], postName(post))
+		byPath[post.Path] = append(byPath[post.Path], postName(post))
+	}
+
+	others := func(names []string, me string) string {
+		var result []string
+		for _, name := range names {
+			if name != me {
+				result = append(result, name)
+			}
+		}
+		return strings.Join(result, ", ")
+	}
+
+	for _, post := range posts {
+		me := postName(post)
+		if names := byUuid[post.Uuid]; len(names) > 1 {
+			c.add(me, "uuid", "Same uuid as %s", others(names, me))
+		}
+		if names := byPath[post.Path]; len(names) > 1 {
+			if post.IsPage() {
+				c.add(me, "basename", "Same basename as %s", others(names, me))
+			} else {
+				c.add(me, "basename", "Same basename and month as %s", others(names, me))
+			}
+		}
+	}
+}
+
+// checkTags finds tags, and categories, that only differ by case, which the
+// archives treat as different.
+func (c *checker) checkTags(posts []*store.Post, field string, tags func(post *store.Post) []string) {
+	var keys []string
+	spellings := make(map[string][]string)
+	users := make(map[string][]string)
+	for _, post := range posts {
+		for _, tag := range tags(post) {
+			key := strings.ToLower(tag)
+			if spellings[key] == nil {
+				keys = append(keys, key)
+			}
+			if users[tag] == nil {
+				spellings[key] = append(spellings[key], tag)
+			}
+			users[tag] = append(users[tag], postName(post))
+		}
+	}
+
+	for _, key := range keys {
+		if len(spellings[key]) < 2 {
+			continue
+		}
+
+		var uses []string
+		for _, tag := range spellings[key] {
+			uses = append(uses, fmt.Sprintf("'%s' (%s)", tag, strings.Join(users[tag], ", ")))
+		}
+		c.add("", field, "Differ only by case: %s", strings.Join(uses, ", "))
+	}
+}
+
+// render returns a post's body as html.
+func render(post *store.Post) string {
+	var buf bytes.Buffer
+	switch {
+	case post.IsFormatTextile():
+		textile.TextileFormatter(&buf, "", post.Body)
+	case post.IsFormatMarkdown():
+		markdown.MarkdownFormatter(&buf, "", post.Body)
+	case post.IsFormatConvertBreaks():
+		lwb.ConvertBreaksFormatter(&buf, "", post.Body)
+	default:
+		buf.WriteString(post.Body)
+	}
+
+	return buf.String()
+}
+
+// staticExists returns whether a path is served from the static directory.
+func (c *checker) staticExists(p string) bool {
+	fileInfo, err := os.Stat(path.Join(c.staticDir, path.Clean(p)))
+
+	return err == nil && fileInfo.IsRegular()
+}
+
+// bodyFileName returns the name of the .body file that can hold the body of the
+// post in the named file.
+func bodyFileName(name string) string {
+	for _, suffix := range []string{".post", ".page"} {
+		if strings.HasSuffix(name, suffix) {
+			name = name[:len(name)-len(suffix)]
+		}
+	}
+
+	return name + ".body"
+}
+
+// checkPost checks the body, format and links of a post.
+func (c *checker) checkPost(post *store.Post) {
+	me := postName(post)
+	if !knownFormats[post.Format] {
+		c.add(me, "format", "Unknown format: %s", post.Format)
+	}
+	if strings.TrimSpace(post.Body) == "" {
+		if c.bodyFiles {
+			c.add(me, "body", "No body, and no %s file", bodyFileName(me))
+		} else {
+			c.add(me, "body", "No body")
+		}
+		return
+	}
+
+	for _, match := range htmlLinkRegexp.FindAllStringSubmatch(render(post), -1) {
+		tag, url := match[1], strings.Replace(match[3], "&amp;", "&", -1)
+
+		// Only links within the blog can be checked.
+		if !strings.HasPrefix(url, "/") || strings.HasPrefix(url, "//") {
+			continue
+		}
+		if i := strings.IndexAny(url, "?#"); i >= 0 {
+			url = url[:i]
+		}
+
+		switch {
+		case tag == "img":
+			if !c.staticExists(url) {
+				c.add(me, "body", "Missing image: %s", url)
+			}
+		case postPathRegexp.MatchString(url) || pagePathRegexp.MatchString(url):
+			if !c.paths[url] {
+				c.add(me, "body", "Broken link: %s", url)
+			}
+		case path.Ext(url) != "":
+			if !c.staticExists(url) {
+				c.add(me, "body", "Broken link: %s", url)
+			}
+		}
+	}
+}
+
+// check finds the problems with everything in src, and returns how many posts
+// and pages there are.
+func (c *checker) check(src store.Exporter) int {
+	if reporter, ok := src.(problemReporter); ok {
+		c.problems = append(c.problems, reporter.LoadProblems()...)
+	}
+
+	posts := src.GetAllPosts()
+	for _, post := range posts {
+		if post.IsPublished() || post.IsScheduled() {
+			c.paths[post.Path] = true
+		}
+	}
+
+	c.checkDuplicates(posts)
+	c.checkTags(posts, "tags", func(post *store.Post) []string { return post.Tags })
+	c.checkTags(posts, "categories", func(post *store.Post) []string { return post.Categories })
+	for _, post := range posts {
+		c.checkPost(post)
+	}
+
+	return len(posts)
+}
+
+// runCheck loads a json, text or git store and reports any problems with its
+// contents.
+func runCheck(args []string) os.Error {
+	kind := "json"
+	if len(args) > 0 {
+		kind = args[0]
+	}
+
+	// Find everything wrong, rather than stopping at the first bad post.
+	flag.Set("strict", "false")
+	src, err := openSource(kind)
+	if err != nil {
+		return err
+	}
+
+	c := &checker{bodyFiles: kind != "text", staticDir: *flagStatic, paths: make(map[string]bool)}
+	n := c.check(src)
+
+	for _, p := range c.problems {
+		fmt.Println(p)
+	}
+	if len(c.problems) != 0 {
+		return fmt.Errorf("%d problems found", len(c.problems))
+	}
+
+	fmt.Printf("Checked %d posts and pages\n", n)
+
+	return nil
+}
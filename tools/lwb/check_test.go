@@ -0,0 +1,123 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"github.com/stevela/lwb/store"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// checkPostFile returns the contents of a .post file in the fixture store.
+func checkPostFile(uuid, basename, fields string) string {
+	return `{"title": "` + uuid + `", "uuid": "` + uuid + `", "basename": "` + basename + `",
+		"type": "post", "status": "publish", "format": "none",
+		"lastModifiedDate": "2011-05-02T22:33:46-07:00",
+		"publishedDate": "2011-05-02T22:33:46-07:00"` + fields + `}`
+}
+
+// A small json store with one of each problem lwb check finds.
+var checkStoreFiles = map[string]string{
+	"good.post": checkPostFile("good", "good", ""),
+	"good.body": `<img src="/images/there.png"> <a href="/2011/05/same">Same</a> <a href="http://example.org/x">Away</a>`,
+
+	"uuid1.post": checkPostFile("dup", "uuid1", `, "body": "One"`),
+	"uuid2.post": checkPostFile("dup", "uuid2", `, "body": "Two"`),
+
+	"path1.post": checkPostFile("path1", "same", `, "body": "One"`),
+	"path2.post": checkPostFile("path2", "same", `, "body": "Two"`),
+
+	"tag1.post": checkPostFile("tag1", "tag1", `, "body": "Go", "tags": ["Go"]`),
+	"tag2.post": checkPostFile("tag2", "tag2", `, "body": "go", "tags": ["go"]`),
+
+	"format.post": checkPostFile("format", "format", `, "body": "Hi", "format": "rtf"`),
+
+	"empty.post": `{"title": "Empty", "uuid": "empty", "basename": "empty", "type": "post", "status": "draft",
+		"lastModifiedDate": "2011-05-02T22:33:46-07:00", "publishedDate": "2011-05-02T22:33:46-07:00"}`,
+
+	"image.post": checkPostFile("image", "image", `, "body": "<img src=\"/images/missing.png\">"`),
+	"link.post":  checkPostFile("link", "link", `, "body": "<a href=\"/2011/05/nowhere\">Nowhere</a>"`),
+}
+
+var checkTests = []struct {
+	file, field, problem string
+}{
+	{"uuid1", "uuid", "Same uuid as uuid2"},
+	{"uuid2", "uuid", "Same uuid as uuid1"},
+	{"path1", "basename", "Same basename and month as path2"},
+	{"path2", "basename", "Same basename and month as path1"},
+	{"", "tags", "Differ only by case: "},
+	{"format", "format", "Unknown format: rtf"},
+	{"empty", "body", "No body, and no empty.body file"},
+	{"image", "body", "Missing image: /images/missing.png"},
+	{"link", "body", "Broken link: /2011/05/nowhere"},
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check_test")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	storeDir, staticDir := path.Join(dir, "json_store"), path.Join(dir, "static")
+	for _, d := range []string{storeDir, path.Join(staticDir, "images")} {
+		if err = os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %s", err)
+		}
+	}
+	files := map[string]string{path.Join(staticDir, "images", "there.png"): ""}
+	for name, data := range checkStoreFiles {
+		files[path.Join(storeDir, name)] = data
+	}
+	for name, data := range files {
+		if err = ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %s", err)
+		}
+	}
+
+	flag.Set("json_dir", storeDir)
+	flag.Set("strict", "false")
+	src, err := store.NewJsonStore(config, nil)
+	if err != nil {
+		t.Fatalf("NewJsonStore() failed: %s", err)
+	}
+
+	c := &checker{bodyFiles: true, staticDir: staticDir, paths: make(map[string]bool)}
+	if n := c.check(src); n != len(checkStoreFiles)-1 {
+		t.Errorf("check() = %d want %d", n, len(checkStoreFiles)-1)
+	}
+
+	for _, ct := range checkTests {
+		found := false
+		for _, p := range c.problems {
+			if p.File == ct.file && p.Field == ct.field && strings.HasPrefix(p.Problem, ct.problem) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no problem '%s: %s: %s' in %v", ct.file, ct.field, ct.problem, c.problems)
+		}
+	}
+	if len(c.problems) != len(checkTests) {
+		t.Errorf("found %d problems want %d: %v", len(c.problems), len(checkTests), c.problems)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2011 Steve Lacey
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// lwb manages the stores of a blog from the command line.
+//
+// Usage:
+//
+//	lwb [flags] command [arguments]
+//
+// The commands are:
+//
+//	import [json|text|git]
+//		Copy every post and page from the json store (-json_dir), the
+//		text store (-text_dir) or the git store (-git_repo, -git_path and
+//		-git_ref), into the database store (-db), replacing any that are
+//		already there. The blog must not be running, as only one process
+//		can have the database open.
+//
+//	check [json|text|git]
+//		Load a store and report anything wrong with it: posts that
+//		can't be loaded, posts that share a uuid or a path, unknown
+//		formats, missing bodies, links to posts, pages or static files
+//		(-static) that don't exist, and tags or categories that only
+//		differ by case.
+//
+//	history uuid
+//		Show the revisions of a post or page in the git store (-git_repo,
+//		-git_path and -git_ref), newest first, along with the changes
+//		made by each.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/stevela/lwb/kv"
+	"github.com/stevela/lwb/lwb"
+	"github.com/stevela/lwb/store"
+	"http"
+	"os"
+	"time"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) os.Error
+}
+
+var commands = []*command{
+	&command{"import", "import [json|text|git]", runImport},
+	&command{"check", "check [json|text|git]", runCheck},
+	&command{"history", "history uuid", runHistory},
+}
+
+// The stores only need the blog's url for the canonical links of posts, which
+// aren't used here.
+var config = &lwb.BlogConfig{BlogUrl: new(http.URL)}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: lwb [flags] command [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n", c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+
+	for _, c := range commands {
+		if c.name == flag.Arg(0) {
+			if err := c.run(flag.Args()[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "lwb %s: %s\n", c.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	usage()
+}
+
+// openSource opens the json, text or git store to read from.
+func openSource(kind string) (src store.Exporter, err os.Error) {
+	switch kind {
+	case "json":
+		src, err = store.NewJsonStore(config, nil)
+	case "text":
+		src, err = store.NewTextStore(config, nil)
+	case "git":
+		src, err = store.NewGitStore(config, nil)
+	default:
+		err = os.NewError("Unknown store: " + kind)
+	}
+
+	return
+}
+
+// runImport copies a json, text or git store into the database store.
+func runImport(args []string) os.Error {
+	kind := "json"
+	if len(args) > 0 {
+		kind = args[0]
+	}
+
+	src, err := openSource(kind)
+	if err != nil {
+		return err
+	}
+
+	db, err := store.NewDbStore(config, nil)
+	if err == kv.ErrLocked {
+		return os.NewError("The database is in use; stop the blog before importing")
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	n, err := db.Import(src)
+	if err != nil {
+		return err
+	}
+
+	// Reclaim the space taken by any posts that were replaced.
+	if err = db.Compact(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d posts and pages into %s\n", n, flag.Lookup("db").Value)
+
+	return nil
+}
+
+// runHistory shows the revisions of a post or page in the git store.
+func runHistory(args []string) os.Error {
+	if len(args) != 1 {
+		return os.NewError("Expected the uuid of a post or page")
+	}
+
+	// Bad posts elsewhere in the store don't matter here.
+	flag.Set("strict", "false")
+	gs, err := store.NewGitStore(config, nil)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := gs.GetPostHistory(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, r := range revisions {
+		fmt.Printf("commit %s\nAuthor: %s\nDate:   %s\n\n    %s\n\n%s\n",
+			r.Commit, r.Author, r.Date.Format(time.RFC1123), r.Subject, r.Diff)
+	}
+
+	return nil
+}